@@ -0,0 +1,103 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"tracky/internal/backup"
+	"tracky/internal/blobstore"
+	"tracky/internal/store/sqlstore"
+)
+
+const (
+	backupUsage  = "usage: tracky backup <file.zip>"
+	restoreUsage = "usage: tracky restore <file.zip>"
+)
+
+// runBackup implements `tracky backup <file.zip>`, which snapshots the
+// database and uploaded images while the server keeps running
+func runBackup(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, backupUsage)
+		os.Exit(2)
+	}
+	filename := args[0]
+
+	store, err := sqlstore.Open(dbConfig())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer store.Close()
+	blobs, err := blobStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize blob storage: %v", err)
+	}
+
+	m, err := writeBackup(store, blobs, filename)
+	if err != nil {
+		log.Fatalf("Backup failed: %v", err)
+	}
+
+	var rows int
+	for _, t := range m.Tables {
+		rows += t.Rows
+	}
+	fmt.Printf("Backed up %d rows in %d tables and %d images to %s\n", rows, len(m.Tables), len(m.Blobs), filename)
+}
+
+// runRestore implements `tracky restore <file.zip>`, which verifies a backup
+// and loads it into an empty database, of either dialect, and blob store
+func runRestore(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, restoreUsage)
+		os.Exit(2)
+	}
+
+	zr, err := zip.OpenReader(args[0])
+	if err != nil {
+		log.Fatalf("Failed to open backup: %v", err)
+	}
+	defer zr.Close()
+
+	store, err := sqlstore.Open(dbConfig())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer store.Close()
+	blobs, err := blobStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize blob storage: %v", err)
+	}
+
+	m, err := backup.Restore(context.Background(), store, blobs, &zr.Reader)
+	if err != nil {
+		log.Fatalf("Restore failed: %v", err)
+	}
+	fmt.Printf("Restored a %s backup from %s with %d tables and %d images\n", m.Dialect, m.CreatedAt.Format("2006-01-02 15:04"), len(m.Tables), len(m.Blobs))
+}
+
+// writeBackup writes the backup beside its destination and renames it once
+// it's complete, so that a failed backup never replaces a good one
+func writeBackup(store *sqlstore.SQLStore, blobs blobstore.BlobStore, filename string) (*backup.Manifest, error) {
+	f, err := os.CreateTemp(filepath.Dir(filename), ".tracky-backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name()) // Fails harmlessly after the rename
+
+	m, err := backup.Create(context.Background(), store, blobs, f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, os.Rename(f.Name(), filename)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"log"
@@ -10,22 +11,105 @@ import (
 	"time"
 
 	"tracky/internal/api"
+	"tracky/internal/blobstore"
+	"tracky/internal/llm"
 	"tracky/internal/middleware"
+	"tracky/internal/oidc"
 	"tracky/internal/store/sqlstore"
 )
 
 var version = strconv.FormatInt(time.Now().Unix(), 10)
 
-func main() {
-	// Determine database type from environment (default SQLite)
-	dbDriver := os.Getenv("DB_DRIVER")
-	if dbDriver == "" {
-		dbDriver = "sqlite3"
+// dbConfig determines the database from the environment (default SQLite)
+func dbConfig() (driver, connStr string) {
+	driver = os.Getenv("DB_DRIVER")
+	if driver == "" {
+		driver = "sqlite3"
+	}
+	connStr = os.Getenv("DB_CONN")
+	if connStr == "" {
+		connStr = "./tracky.db"
+	}
+	return driver, connStr
+}
+
+// blobStoreFromEnv picks where uploaded images are stored: the local
+// filesystem (default) or an S3-compatible bucket when BLOB_BACKEND=s3
+func blobStoreFromEnv() (blobstore.BlobStore, error) {
+	switch os.Getenv("BLOB_BACKEND") {
+	case "", "local":
+		dir := os.Getenv("UPLOADS_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return blobstore.NewLocal(dir), nil
+	case "s3":
+		pathStyle, _ := strconv.ParseBool(os.Getenv("S3_PATH_STYLE"))
+		return blobstore.NewS3(blobstore.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: pathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_BACKEND %q", os.Getenv("BLOB_BACKEND"))
 	}
-	dbConnStr := os.Getenv("DB_CONN")
-	if dbConnStr == "" {
-		dbConnStr = "./tracky.db"
+}
+
+// llmConfigFromEnv reads the model used for note analysis. LLM_PROVIDER is
+// gemini (default), openai, ollama or fake.
+func llmConfigFromEnv() llm.Config {
+	cfg := llm.Config{
+		Provider:       os.Getenv("LLM_PROVIDER"),
+		Model:          os.Getenv("LLM_MODEL"),
+		EmbeddingModel: os.Getenv("EMBEDDING_MODEL"),
+		BaseURL:        os.Getenv("LLM_BASE_URL"),
+		APIKey:         os.Getenv("LLM_API_KEY"),
+	}
+	if cfg.APIKey == "" && (cfg.Provider == "" || cfg.Provider == "gemini") {
+		cfg.APIKey = os.Getenv("GEMINI_API_KEY")
+	}
+	return cfg
+}
+
+// embeddingConfigFromEnv reads the model used for note embeddings when
+// EMBEDDING_PROVIDER names a provider other than the analysis one, with its
+// own EMBEDDING_BASE_URL and EMBEDDING_API_KEY. It returns false if
+// embeddings come from the analysis provider.
+func embeddingConfigFromEnv(analysis llm.Config) (llm.Config, bool) {
+	cfg := llm.Config{
+		Provider:       os.Getenv("EMBEDDING_PROVIDER"),
+		EmbeddingModel: analysis.EmbeddingModel,
+		BaseURL:        os.Getenv("EMBEDDING_BASE_URL"),
+		APIKey:         os.Getenv("EMBEDDING_API_KEY"),
 	}
+	if cfg.Provider == "" || cfg.Provider == analysis.Provider {
+		return analysis, false
+	}
+	if cfg.APIKey == "" && cfg.Provider == "gemini" {
+		cfg.APIKey = os.Getenv("GEMINI_API_KEY")
+	}
+	return cfg, true
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "backup":
+			runBackup(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		}
+	}
+
+	dbDriver, dbConnStr := dbConfig()
 
 	// Initialize store
 	store, err := sqlstore.New(dbDriver, dbConnStr)
@@ -37,6 +121,63 @@ func main() {
 	// Create handlers
 	handlers := api.NewHandlers(store)
 
+	blobs, err := blobStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize blob storage: %v", err)
+	}
+	handlers.Blobs = blobs
+
+	llmConfig := llmConfigFromEnv()
+	provider, err := llm.New(context.Background(), llmConfig)
+	if err != nil {
+		log.Printf("Note analysis disabled: %v", err)
+	} else {
+		handlers.LLM = provider
+	}
+
+	// Embeddings come from the analysis provider unless EMBEDDING_PROVIDER
+	// names another one
+	embedProvider := provider
+	if embedConfig, ok := embeddingConfigFromEnv(llmConfig); ok {
+		embedProvider, err = llm.New(context.Background(), embedConfig)
+		if err != nil {
+			log.Printf("Semantic search disabled: %v", err)
+		}
+	}
+	if embedder, ok := embedProvider.(llm.Embedder); ok {
+		handlers.Embedder = embedder
+		go handlers.RunEmbeddingIndexer(context.Background(), 10*time.Minute)
+	}
+	if handlers.LLM != nil {
+		go handlers.RunDigestScheduler(context.Background(), time.Hour)
+	}
+
+	// Permanently delete trashed items after TRASH_RETENTION (e.g. "720h")
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		retention, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid TRASH_RETENTION: %v", err)
+		}
+		handlers.TrashRetention = retention
+	}
+	go handlers.RunTrashPurger(context.Background(), time.Hour)
+	go handlers.RunSessionCleanup(context.Background(), time.Hour)
+
+	// Single sign-on through an OpenID Connect provider, alongside passwords
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		provider, err := oidc.Discover(context.Background(), oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"), // e.g. https://tracky.example.com/api/oidc/callback
+		})
+		if err != nil {
+			log.Printf("Single sign-on disabled: %v", err)
+		} else {
+			handlers.OIDC = provider
+		}
+	}
+
 	mux := http.NewServeMux()
 
 	// Serve index.html with cache-busting version
@@ -46,7 +187,7 @@ func main() {
 			http.FileServer(http.Dir("./static")).ServeHTTP(w, r)
 			return
 		}
-		tmpl.Execute(w, map[string]string{"Version": version})
+		tmpl.Execute(w, map[string]interface{}{"Version": version, "SSO": handlers.OIDC != nil})
 	})
 
 	// Serve other static files
@@ -54,17 +195,65 @@ func main() {
 
 	mux.HandleFunc("/api/signup", handlers.SignupHandler)
 	mux.HandleFunc("/api/login", handlers.LoginHandler)
+	mux.HandleFunc("/api/login/totp", handlers.LoginTOTPHandler)
+	mux.HandleFunc("/api/oidc/login", handlers.OIDCLoginHandler)
+	mux.HandleFunc("/api/oidc/callback", handlers.OIDCCallbackHandler)
 	mux.HandleFunc("/api/logout", handlers.LogoutHandler)
 	mux.HandleFunc("/api/notebooks", handlers.NotebooksHandler)
+	mux.HandleFunc("/api/notebooks/import", handlers.NotebookImportHandler)
+	mux.HandleFunc("/api/notebooks/{id}/export", handlers.NotebookExportHandler)
+	mux.HandleFunc("/api/imports", handlers.ImportsHandler)
+	mux.HandleFunc("/api/imports/{id}", handlers.ImportHandler)
+	mux.HandleFunc("/api/notebooks/{id}/members", handlers.NotebookMembersHandler)
+	mux.HandleFunc("/api/notebooks/{id}/members/{user_id}", handlers.NotebookMemberHandler)
 	mux.HandleFunc("/api/notes", handlers.NotesHandler)
+	mux.HandleFunc("/api/notes/{id}/revisions", handlers.RevisionsHandler)
+	mux.HandleFunc("/api/notes/{id}/revisions/{revision_id}/restore", handlers.RestoreRevisionHandler)
+	mux.HandleFunc("/api/account", handlers.AccountHandler)
+	mux.HandleFunc("/api/account/export", handlers.AccountExportHandler)
+	mux.HandleFunc("/api/account/totp", handlers.TOTPHandler)
+	mux.HandleFunc("/api/sessions", handlers.SessionsHandler)
+	mux.HandleFunc("/api/sessions/{id}", handlers.SessionHandler)
+	mux.HandleFunc("/api/tokens", handlers.TokensHandler)
+	mux.HandleFunc("/api/tokens/{id}", handlers.TokenHandler)
+	mux.HandleFunc("/api/shares", handlers.ShareLinksHandler)
+	mux.HandleFunc("/api/shares/{id}", handlers.ShareLinkHandler)
+	mux.HandleFunc("/api/tags", handlers.TagsHandler)
 	mux.HandleFunc("/api/images", handlers.ImagesHandler)
+	mux.HandleFunc("/api/trash", handlers.TrashHandler)
 	mux.HandleFunc("/api/analysis", handlers.AnalysisHandler)
+	mux.HandleFunc("/api/analysis/stream", handlers.AnalysisStreamHandler)
+	mux.HandleFunc("/api/conversations", handlers.ConversationsHandler)
+	mux.HandleFunc("/api/conversations/{id}", handlers.ConversationHandler)
+	mux.HandleFunc("/api/digests", handlers.DigestsHandler)
+	mux.HandleFunc("/api/digests/{id}", handlers.DigestHandler)
+	mux.HandleFunc("/api/search", handlers.SearchHandler)
 
 	// Serve uploaded images with authentication
 	mux.HandleFunc("/uploads/", handlers.ServeImageHandler)
 
-	// Apply middleware: Logging -> Auth
-	handler := middleware.Logging(middleware.Auth(mux))
+	// Public share links
+	mux.HandleFunc("/s/{token}", handlers.PublicShareHandler)
+	mux.HandleFunc("/s/{token}/images/{id}", handlers.PublicShareImageHandler)
+
+	// Throttle password guessing per client, guessing the password or second
+	// factor to change a signed-in account per user, and analysis per user
+	// since every question costs a model call
+	limiter := middleware.NewRateLimiter()
+	limiter.Route(middleware.Limit{Requests: 10, Per: time.Minute}, middleware.Limit{}, "/api/login", "/api/login/totp")
+	limiter.Route(middleware.Limit{}, middleware.Limit{Requests: 10, Per: time.Minute}, "/api/account", "/api/account/totp")
+	limiter.Route(middleware.Limit{Requests: 5, Per: time.Hour}, middleware.Limit{}, "/api/signup")
+	limiter.Route(middleware.Limit{Requests: 60, Per: time.Hour}, middleware.Limit{Requests: 30, Per: time.Hour}, "/api/analysis", "/api/analysis/stream")
+
+	// Clients are identified by X-Forwarded-For only behind TRUSTED_PROXIES,
+	// a comma-separated list of addresses and ranges (e.g. "10.0.0.0/8")
+	proxies, err := middleware.ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Apply middleware: RealIP -> Logging -> Auth -> CSRF -> RateLimit
+	handler := middleware.RealIP(proxies, middleware.Logging(middleware.Auth(store, middleware.CSRF(limiter.Middleware(mux)))))
 
 	fmt.Println("Server started at :8080")
 	log.Fatal(http.ListenAndServe(":8080", handler))
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"tracky/internal/store/sqlstore"
+)
+
+const migrateUsage = "usage: tracky migrate up|down [steps]|status"
+
+// runMigrate implements `tracky migrate up|down [steps]|status`
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	store, err := sqlstore.Open(dbConfig())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "up":
+		if err := store.MigrateUp(); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		printMigrationStatus(store)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("Invalid step count %q", args[1])
+			}
+		}
+		if err := store.MigrateDown(steps); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		printMigrationStatus(store)
+
+	case "status":
+		printMigrationStatus(store)
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
+
+func printMigrationStatus(store *sqlstore.SQLStore) {
+	statuses, err := store.MigrationStatus()
+	if err != nil {
+		log.Fatalf("Failed to read migration status: %v", err)
+	}
+	for _, st := range statuses {
+		applied := "pending"
+		if st.AppliedAt != nil {
+			applied = "applied " + st.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-30s %s\n", st.Version, st.Name, applied)
+	}
+}
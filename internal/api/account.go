@@ -0,0 +1,271 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"tracky/internal/auth"
+	"tracky/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AccountHandler shows the signed-in account (GET), changes its password
+// (PUT with {"current_password": ..., "new_password": ...}) or permanently
+// deletes it with all its data (DELETE with {"password": ...}).
+// Changing the password signs out every other session. Accounts created
+// through single sign-on have no password: they set their first one without
+// a current password, and need it to delete the account.
+// Route: /api/account
+func (h *Handlers) AccountHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	username, hash, err := h.Store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(models.User{ID: userID, Username: username})
+
+	case http.MethodPut:
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.NewPassword == "" {
+			http.Error(w, "New password is required", http.StatusBadRequest)
+			return
+		}
+		if h.rejectLockedAccount(w, userID) {
+			return
+		}
+		if hash != "" && !h.checkPassword(w, userID, hash, req.CurrentPassword) {
+			return
+		}
+
+		newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.Store.UpdatePassword(userID, string(newHash)); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.Store.DeleteUserSessions(userID); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.startSession(w, r, userID); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		var req struct {
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if h.rejectLockedAccount(w, userID) {
+			return
+		}
+		if !h.checkPassword(w, userID, hash, req.Password) {
+			return
+		}
+
+		filenames, err := h.Store.DeleteUser(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		for _, filename := range filenames {
+			if err := h.Blobs.Delete(context.Background(), filename); err != nil {
+				log.Printf("Failed to remove image %s of deleted user: %v", filename, err)
+			}
+		}
+		auth.ClearAuthCookie(w)
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// checkPassword confirms a sensitive change with the account's password,
+// writing an error response and returning false if it's wrong or the
+// account has none yet. Wrong passwords count towards the account lockout,
+// so callers check rejectLockedAccount first.
+func (h *Handlers) checkPassword(w http.ResponseWriter, userID int, hash, password string) bool {
+	if hash == "" {
+		http.Error(w, "Set a password for your account first", http.StatusForbidden)
+		return false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		h.failLogin(w, userID, http.StatusForbidden, "Invalid credentials")
+		return false
+	}
+	return true
+}
+
+// accountExport is the data.json file of an account export
+type accountExport struct {
+	Username   string            `json:"username"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Notebooks  []notebookExport  `json:"notebooks"`
+	Digests    []models.Digest   `json:"digests"`
+	APITokens  []models.APIToken `json:"api_tokens"` // Names and scopes only
+	Sessions   []models.Session  `json:"sessions"`
+}
+
+type notebookExport struct {
+	models.Notebook
+	Notes         []noteExport          `json:"notes"`
+	Conversations []models.Conversation `json:"conversations"`
+}
+
+type noteExport struct {
+	models.Note
+	Revisions []models.NoteRevision `json:"revisions"`
+}
+
+// AccountExportHandler downloads a zip archive of the user's data: data.json
+// with every notebook, note, revision, conversation and setting, and the
+// note images under images/. Items in the trash are not included. API tokens
+// can't download it.
+// Route: GET /api/account/export
+func (h *Handlers) AccountExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	export, images, err := h.exportAccount(userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tracky-%s-%s.zip"`, export.Username, export.ExportedAt.Format("2006-01-02")))
+
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("data.json")
+	if err != nil {
+		return
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(export); err != nil {
+		return
+	}
+
+	for _, filename := range images {
+		if err := h.exportImage(r.Context(), zw, filename); err != nil {
+			log.Printf("Failed to export image %s: %v", filename, err)
+		}
+	}
+	zw.Close()
+}
+
+// exportAccount gathers the user's data and the filenames of their images
+func (h *Handlers) exportAccount(userID int) (accountExport, []string, error) {
+	username, _, err := h.Store.GetUserByID(userID)
+	if err != nil {
+		return accountExport{}, nil, err
+	}
+	export := accountExport{Username: username, ExportedAt: time.Now(), Notebooks: []notebookExport{}}
+
+	notebooks, err := h.Store.GetNotebooks(userID)
+	if err != nil {
+		return export, nil, err
+	}
+	var images []string
+	for _, nb := range notebooks {
+		nbExport := notebookExport{Notebook: nb, Notes: []noteExport{}, Conversations: []models.Conversation{}}
+
+		notes, err := h.Store.GetNotes(userID, nb.ID)
+		if err != nil {
+			return export, nil, err
+		}
+		// Only the user's own notes in notebooks shared with them are theirs
+		if nb.Role != models.RoleOwner {
+			notes = slices.DeleteFunc(notes, func(n models.Note) bool { return n.UserID != userID })
+		}
+		h.attachNoteDetails(notes)
+		for _, note := range notes {
+			revisions, err := h.Store.GetNoteRevisions(note.ID, userID)
+			if err != nil {
+				return export, nil, err
+			}
+			for _, img := range note.Images {
+				images = append(images, img.Filename)
+			}
+			nbExport.Notes = append(nbExport.Notes, noteExport{Note: note, Revisions: revisions})
+		}
+
+		conversations, err := h.Store.GetConversations(userID, nb.ID)
+		if err != nil {
+			return export, nil, err
+		}
+		for _, c := range conversations {
+			conversation, err := h.Store.GetConversation(c.ID, userID)
+			if err != nil {
+				return export, nil, err
+			}
+			nbExport.Conversations = append(nbExport.Conversations, conversation)
+		}
+		export.Notebooks = append(export.Notebooks, nbExport)
+	}
+
+	if export.Digests, err = h.Store.GetDigests(userID); err != nil {
+		return export, nil, err
+	}
+	if export.APITokens, err = h.Store.GetAPITokens(userID); err != nil {
+		return export, nil, err
+	}
+	if export.Sessions, err = h.Store.GetSessions(userID); err != nil {
+		return export, nil, err
+	}
+	return export, images, nil
+}
+
+// exportImage copies one image from blob storage into the archive
+func (h *Handlers) exportImage(ctx context.Context, zw *zip.Writer, filename string) error {
+	blob, _, err := h.Blobs.Get(ctx, filename)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	f, err := zw.Create("images/" + filename)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, blob)
+	return err
+}
@@ -0,0 +1,425 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"tracky/internal/auth"
+	"tracky/internal/llm"
+	"tracky/internal/models"
+)
+
+const noNotesAnswer = "There are no notes in this notebook to analyze."
+
+// analysisNoteLimit caps how many notes are sent to the model. Larger
+// notebooks send the notes most relevant to the question when embeddings are
+// available, and the most recent notes otherwise.
+const analysisNoteLimit = 50
+
+// conversationTitleLength is how much of the first question names a new
+// conversation
+const conversationTitleLength = 60
+
+// analysis is a validated question about a notebook, ready for the model
+type analysis struct {
+	userID         int
+	notebookIDs    []int
+	conversationID int // 0 starts a new conversation
+	question       string
+	request        *llm.Request // nil if the notebook has no notes
+}
+
+// AnalysisHandler answers questions about a notebook's notes using the
+// configured language model. The question and answer are saved to the
+// conversation given by conversation_id, or to a new one whose ID is returned.
+func (h *Handlers) AnalysisHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a, ok := h.prepareAnalysis(w, r)
+	if !ok {
+		return
+	}
+	if a.request == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"answer":          noNotesAnswer,
+			"conversation_id": a.conversationID,
+		})
+		return
+	}
+
+	answer, err := h.LLM.Complete(r.Context(), *a.request)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Analysis failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	conversationID, err := h.saveExchange(a, answer)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"answer":          answer,
+		"conversation_id": conversationID,
+	})
+}
+
+// AnalysisStreamHandler is AnalysisHandler streamed as Server-Sent Events:
+// "token" events carry {"text": ...} pieces of the answer as the model writes
+// them, followed by a "done" event with {"conversation_id": ...}, or an
+// "error" event if the model fails. Generation stops, and nothing is saved,
+// if the client disconnects.
+func (h *Handlers) AnalysisStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a, ok := h.prepareAnalysis(w, r)
+	if !ok {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, data interface{}) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if a.request == nil {
+		send("token", map[string]string{"text": noNotesAnswer})
+		send("done", map[string]int{"conversation_id": a.conversationID})
+		return
+	}
+
+	var answer strings.Builder
+	err := h.LLM.Stream(r.Context(), *a.request, func(chunk string) error {
+		answer.WriteString(chunk)
+		return send("token", map[string]string{"text": chunk})
+	})
+	if r.Context().Err() != nil {
+		return // Client went away
+	}
+	if err != nil {
+		send("error", map[string]string{"error": fmt.Sprintf("Analysis failed: %v", err)})
+		return
+	}
+
+	conversationID, err := h.saveExchange(a, answer.String())
+	if err != nil {
+		send("error", map[string]string{"error": "Failed to save conversation"})
+		return
+	}
+	send("done", map[string]int{"conversation_id": conversationID})
+}
+
+// analysisScope is the set of notes a question is about
+type analysisScope struct {
+	notebookIDs []int
+	start, end  time.Time      // Both zero if the whole history is in scope
+	location    *time.Location // For interpreting dates
+}
+
+// prepareAnalysis decodes an analysis request, loads the conversation so far
+// and builds the model conversation from the notes in scope: one or more
+// notebooks (notebook_id or notebook_ids), optionally limited to start/end
+// dates or a relative range such as "last_week" in the given timezone. A
+// conversation continues on the notebooks it was started on, which the
+// request may only repeat. It writes an error response and returns false if
+// the request can't be served.
+func (h *Handlers) prepareAnalysis(w http.ResponseWriter, r *http.Request) (*analysis, bool) {
+	if h.LLM == nil {
+		http.Error(w, "Analysis is not configured", http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var req struct {
+		NotebookID     int    `json:"notebook_id"`
+		NotebookIDs    []int  `json:"notebook_ids"`
+		ConversationID int    `json:"conversation_id"`
+		Question       string `json:"question"`
+		Start          string `json:"start"`    // RFC 3339 or YYYY-MM-DD
+		End            string `json:"end"`      // RFC 3339 or YYYY-MM-DD (inclusive)
+		Range          string `json:"range"`    // e.g. "last_week", instead of start/end
+		Timezone       string `json:"timezone"` // IANA name, e.g. "Europe/Paris"
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if req.Question == "" {
+		http.Error(w, "Question is required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	// History comes from the saved conversation, never from the client
+	var history []models.ChatMessage
+	if req.ConversationID != 0 {
+		conversation, err := h.Store.GetConversation(req.ConversationID, userID)
+		if err != nil {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return nil, false
+		}
+		// A conversation stays about the notebooks it was started on
+		other := func(id int) bool { return !slices.Contains(conversation.NotebookIDs, id) }
+		if (req.NotebookID != 0 && other(req.NotebookID)) || slices.ContainsFunc(req.NotebookIDs, other) {
+			http.Error(w, "The conversation is about another notebook", http.StatusBadRequest)
+			return nil, false
+		}
+		req.NotebookID, req.NotebookIDs = 0, conversation.NotebookIDs
+		history = conversation.Messages
+	}
+
+	scope, err := parseAnalysisScope(req.NotebookID, req.NotebookIDs, req.Start, req.End, req.Range, req.Timezone)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	for _, notebookID := range scope.notebookIDs {
+		role, err := h.Store.GetNotebookRole(notebookID, userID)
+		if !checkRole(w, role, err, false, "Notebook not found") {
+			return nil, false
+		}
+	}
+
+	a := &analysis{
+		userID:         userID,
+		notebookIDs:    scope.notebookIDs,
+		conversationID: req.ConversationID,
+		question:       req.Question,
+	}
+
+	notes, err := h.scopeNotes(userID, scope)
+	if err != nil {
+		http.Error(w, "Failed to fetch notes", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if len(notes) == 0 {
+		return a, true
+	}
+	if len(notes) > analysisNoteLimit {
+		notes, err = h.relevantNotes(r.Context(), userID, scope, req.Question, notes)
+		if err != nil {
+			http.Error(w, "Failed to fetch notes", http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+
+	var notebookNames map[int]string
+	if len(scope.notebookIDs) > 1 {
+		notebookNames = make(map[int]string)
+		notebooks, err := h.Store.GetNotebooks(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return nil, false
+		}
+		for _, nb := range notebooks {
+			notebookNames[nb.ID] = nb.Name
+		}
+	}
+	llmReq := analysisRequest(notes, scope, notebookNames, req.Question, history)
+	a.request = &llmReq
+	return a, true
+}
+
+// parseAnalysisScope validates the notebooks and time range of an analysis
+// request
+func parseAnalysisScope(notebookID int, notebookIDs []int, start, end, rangeName, timezone string) (analysisScope, error) {
+	scope := analysisScope{notebookIDs: notebookIDs, location: time.Local}
+	if notebookID != 0 && !slices.Contains(notebookIDs, notebookID) {
+		scope.notebookIDs = append([]int{notebookID}, notebookIDs...)
+	}
+	if len(scope.notebookIDs) == 0 {
+		return scope, fmt.Errorf("Notebook is required")
+	}
+
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return scope, fmt.Errorf("Invalid timezone")
+		}
+		scope.location = loc
+	}
+
+	var err error
+	switch {
+	case rangeName != "" && (start != "" || end != ""):
+		return scope, fmt.Errorf("Use either range or start and end")
+	case rangeName != "":
+		scope.start, scope.end, err = parseTimeRange(rangeName, time.Now().In(scope.location))
+		if err != nil {
+			return scope, fmt.Errorf("Invalid range")
+		}
+	case start != "" || end != "":
+		scope.end = time.Now().In(scope.location)
+		if start != "" {
+			if scope.start, err = parseRangeBound(start, scope.location, false); err != nil {
+				return scope, fmt.Errorf("Invalid start")
+			}
+		}
+		if end != "" {
+			if scope.end, err = parseRangeBound(end, scope.location, true); err != nil {
+				return scope, fmt.Errorf("Invalid end")
+			}
+		}
+		if scope.end.Before(scope.start) {
+			return scope, fmt.Errorf("End must not be before start")
+		}
+	}
+	return scope, nil
+}
+
+// scopeNotes fetches the notes in scope from every notebook, newest first
+func (h *Handlers) scopeNotes(userID int, scope analysisScope) ([]models.Note, error) {
+	var notes []models.Note
+	for _, notebookID := range scope.notebookIDs {
+		var batch []models.Note
+		var err error
+		if scope.end.IsZero() {
+			batch, err = h.Store.GetNotes(userID, notebookID)
+		} else {
+			batch, err = h.Store.GetNotesByTimeRange(userID, notebookID, scope.start, scope.end)
+		}
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, batch...)
+	}
+	if len(scope.notebookIDs) > 1 {
+		sort.SliceStable(notes, func(i, j int) bool {
+			return notes[i].CreatedAt.After(notes[j].CreatedAt)
+		})
+	}
+	return notes, nil
+}
+
+// saveExchange appends the question and answer to the analysis's
+// conversation, first creating one named after the question if needed, and
+// returns the conversation's ID
+func (h *Handlers) saveExchange(a *analysis, answer string) (int, error) {
+	conversationID := a.conversationID
+	if conversationID == 0 {
+		title := a.question
+		if runes := []rune(title); len(runes) > conversationTitleLength {
+			title = string(runes[:conversationTitleLength]) + "…"
+		}
+		id, err := h.Store.CreateConversation(a.userID, a.notebookIDs, title)
+		if err != nil {
+			return 0, err
+		}
+		conversationID = int(id)
+	}
+
+	err := h.Store.AddConversationMessages(conversationID, a.userID,
+		models.ChatMessage{Role: "user", Content: a.question},
+		models.ChatMessage{Role: "model", Content: answer},
+	)
+	return conversationID, err
+}
+
+// relevantNotes picks the analysisNoteLimit notes most similar to the
+// question, newest first, falling back to the latest notes when there's no
+// embedder or nothing has been indexed yet
+func (h *Handlers) relevantNotes(ctx context.Context, userID int, scope analysisScope, question string, notes []models.Note) ([]models.Note, error) {
+	if h.Embedder == nil {
+		return notes[:analysisNoteLimit], nil
+	}
+	results, err := h.semanticSearch(ctx, userID, question, models.SearchFilters{
+		NotebookIDs: scope.notebookIDs,
+		Start:       scope.start,
+		End:         scope.end,
+		Limit:       analysisNoteLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return notes[:analysisNoteLimit], nil
+	}
+
+	relevant := make([]models.Note, len(results))
+	for i, result := range results {
+		relevant[i] = result.Note
+	}
+	sort.Slice(relevant, func(i, j int) bool {
+		return relevant[i].CreatedAt.After(relevant[j].CreatedAt)
+	})
+	return relevant, nil
+}
+
+// analysisRequest builds the model conversation for a question about notes:
+// the notes go in the system prompt, followed by the chat history and
+// question. notebookNames labels each note with its notebook when set.
+func analysisRequest(notes []models.Note, scope analysisScope, notebookNames map[int]string, question string, history []models.ChatMessage) llm.Request {
+	const dateFormat = "Mon, 02 Jan 2006"
+
+	// Build context from notes
+	var notesContext strings.Builder
+	notesContext.WriteString("You are a helpful assistant analyzing a user's personal notes. ")
+	fmt.Fprintf(&notesContext, "Today is %s. ", time.Now().In(scope.location).Format(dateFormat))
+	if len(scope.notebookIDs) > 1 {
+		fmt.Fprintf(&notesContext, "Here are the notes from %d of their notebooks", len(scope.notebookIDs))
+	} else {
+		notesContext.WriteString("Here are the notes from their notebook")
+	}
+	switch {
+	case !scope.start.IsZero():
+		fmt.Fprintf(&notesContext, " written between %s and %s", scope.start.In(scope.location).Format(dateFormat), scope.end.In(scope.location).Format(dateFormat))
+	case !scope.end.IsZero():
+		fmt.Fprintf(&notesContext, " written up to %s", scope.end.In(scope.location).Format(dateFormat))
+	}
+	notesContext.WriteString(":\n\n")
+
+	for _, note := range notes {
+		timestamp := note.CreatedAt.In(scope.location).Format(time.RFC1123)
+		if name, ok := notebookNames[note.NotebookID]; ok {
+			notesContext.WriteString(fmt.Sprintf("--- Note from %s in %q ---\n%s\n\n", timestamp, name, note.Content))
+		} else {
+			notesContext.WriteString(fmt.Sprintf("--- Note from %s ---\n%s\n\n", timestamp, note.Content))
+		}
+	}
+
+	// Convert history
+	var messages []llm.Message
+	for _, msg := range history {
+		role := llm.RoleUser
+		if msg.Role == "model" {
+			role = llm.RoleModel
+		}
+		messages = append(messages, llm.Message{Role: role, Content: msg.Content})
+	}
+	messages = append(messages, llm.Message{Role: llm.RoleUser, Content: question})
+
+	return llm.Request{System: notesContext.String(), Messages: messages}
+}
@@ -1,17 +1,29 @@
 package api
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"tracky/internal/auth"
+	"tracky/internal/blobstore"
+	"tracky/internal/llm"
+	"tracky/internal/middleware"
 	"tracky/internal/models"
+	"tracky/internal/oidc"
+	"tracky/internal/oidc/oidctest"
 	"tracky/internal/store/sqlstore"
 )
 
@@ -25,11 +37,18 @@ func TestMain(m *testing.M) {
 	}
 	testHandlers = NewHandlers(store)
 
+	uploadsDir, err := os.MkdirTemp("", "tracky-uploads-")
+	if err != nil {
+		panic(err)
+	}
+	testHandlers.Blobs = blobstore.NewLocal(uploadsDir)
+
 	// Run tests
 	code := m.Run()
 
 	// Teardown
 	store.Close()
+	os.RemoveAll(uploadsDir)
 	os.Exit(code)
 }
 
@@ -131,3 +150,2053 @@ func TestNotesFlow(t *testing.T) {
 		t.Errorf("Expected note content 'This is a test note', got '%s'", notes[0].Content)
 	}
 }
+
+func TestSearch(t *testing.T) {
+	testHandlers.Store.CreateUser("searchuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("searchuser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+	otherID, _ := testHandlers.Store.CreateNotebook(userID, "Work")
+
+	testHandlers.Store.CreateNote(userID, int(notebookID), "Walked the dog <3 in the park")
+	testHandlers.Store.CreateNote(userID, int(otherID), "Deployed the release to staging")
+	testHandlers.Store.CreateNote(userID, int(otherID), "Dog sitter booked for the release party")
+
+	// Another user's notes must never show up
+	testHandlers.Store.CreateUser("searchother", "hash")
+	otherUserID, _ := testHandlers.Store.GetUserID("searchother")
+	otherNotebookID, _ := testHandlers.Store.CreateDefaultNotebook(otherUserID)
+	testHandlers.Store.CreateNote(otherUserID, int(otherNotebookID), "My dog is great")
+
+	search := func(query string) []models.SearchResult {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/search?"+query, nil)
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		testHandlers.SearchHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v", w.Code)
+		}
+		var results []models.SearchResult
+		if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return results
+	}
+
+	results := search("q=dog")
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.UserID != userID {
+			t.Errorf("Got result from another user: %+v", r)
+		}
+		if !strings.Contains(r.Snippet, "<mark>") {
+			t.Errorf("Expected highlighted snippet, got %q", r.Snippet)
+		}
+		if strings.Contains(r.Snippet, "<3") {
+			t.Errorf("Expected snippet to be HTML-escaped, got %q", r.Snippet)
+		}
+	}
+
+	results = search(fmt.Sprintf("q=release&notebook_id=%d", otherID))
+	if len(results) != 2 {
+		t.Errorf("Expected 2 results in notebook, got %d", len(results))
+	}
+	results = search(fmt.Sprintf("q=release&notebook_id=%d", notebookID))
+	if len(results) != 0 {
+		t.Errorf("Expected 0 results in notebook, got %d", len(results))
+	}
+
+	// Edits are reflected in the index
+	testHandlers.Store.UpdateNote(singleResultID(t, search("q=staging")), userID, "Deployed to production")
+	if len(search("q=staging")) != 0 {
+		t.Error("Expected updated note to drop out of results")
+	}
+
+	req := httptest.NewRequest("GET", "/api/search", nil)
+	req = requestWithUserID(req, userID)
+	w := httptest.NewRecorder()
+	testHandlers.SearchHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest for empty query, got %v", w.Code)
+	}
+}
+
+func singleResultID(t *testing.T, results []models.SearchResult) int {
+	t.Helper()
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+	return results[0].ID
+}
+
+func TestNotesPagination(t *testing.T) {
+	testHandlers.Store.CreateUser("pageuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("pageuser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+	for i := 0; i < 5; i++ {
+		testHandlers.Store.CreateNote(userID, int(notebookID), fmt.Sprintf("note %d", i))
+	}
+
+	var seen []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("Pagination did not terminate")
+		}
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/notes?notebook_id=%d&limit=2&cursor=%s", notebookID, cursor), nil)
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		testHandlers.NotesHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v", w.Code)
+		}
+
+		var page models.NotePage
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(page.Notes) > 2 {
+			t.Errorf("Expected at most 2 notes per page, got %d", len(page.Notes))
+		}
+		for _, n := range page.Notes {
+			seen = append(seen, n.Content)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	expected := []string{"note 4", "note 3", "note 2", "note 1", "note 0"}
+	if strings.Join(seen, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, seen)
+	}
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/notes?notebook_id=%d&cursor=garbage", notebookID), nil)
+	req = requestWithUserID(req, userID)
+	w := httptest.NewRecorder()
+	testHandlers.NotesHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest for invalid cursor, got %v", w.Code)
+	}
+}
+
+func TestTags(t *testing.T) {
+	testHandlers.Store.CreateUser("taguser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("taguser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+
+	testHandlers.Store.CreateNote(userID, int(notebookID), "Kickoff for #ProjectX and #hiring")
+	noteID, _ := testHandlers.Store.CreateNote(userID, int(notebookID), "More #projectx work")
+	testHandlers.Store.CreateNote(userID, int(notebookID), "No tags here, just issue #42")
+
+	req := httptest.NewRequest("GET", "/api/tags", nil)
+	req = requestWithUserID(req, userID)
+	w := httptest.NewRecorder()
+	testHandlers.TagsHandler(w, req)
+
+	var tags []models.Tag
+	if err := json.NewDecoder(w.Body).Decode(&tags); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(tags) != 2 || tags[0] != (models.Tag{Name: "projectx", Count: 2}) || tags[1] != (models.Tag{Name: "hiring", Count: 1}) {
+		t.Errorf("Unexpected tags: %+v", tags)
+	}
+
+	listByTag := func(tag string) []models.Note {
+		t.Helper()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/notes?notebook_id=%d&tag=%s", notebookID, tag), nil)
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		testHandlers.NotesHandler(w, req)
+		var notes []models.Note
+		if err := json.NewDecoder(w.Body).Decode(&notes); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return notes
+	}
+
+	notes := listByTag("projectx")
+	if len(notes) != 2 {
+		t.Fatalf("Expected 2 notes tagged projectx, got %d", len(notes))
+	}
+	if len(notes[0].Tags) != 1 || notes[0].Tags[0] != "projectx" {
+		t.Errorf("Expected note tags [projectx], got %v", notes[0].Tags)
+	}
+
+	// Editing a note re-extracts its tags
+	testHandlers.Store.UpdateNote(int(noteID), userID, "Moved to #hiring")
+	if notes := listByTag("projectx"); len(notes) != 1 {
+		t.Errorf("Expected 1 note tagged projectx after edit, got %d", len(notes))
+	}
+	if notes := listByTag("hiring"); len(notes) != 2 {
+		t.Errorf("Expected 2 notes tagged hiring after edit, got %d", len(notes))
+	}
+
+	results, _ := testHandlers.Store.Search(userID, "kickoff", models.SearchFilters{Tag: "hiring"})
+	if len(results) != 1 {
+		t.Errorf("Expected 1 search result tagged hiring, got %d", len(results))
+	}
+	results, _ = testHandlers.Store.Search(userID, "kickoff", models.SearchFilters{Tag: "nope"})
+	if len(results) != 0 {
+		t.Errorf("Expected 0 search results for unknown tag, got %d", len(results))
+	}
+}
+
+func TestNoteRevisions(t *testing.T) {
+	testHandlers.Store.CreateUser("revuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("revuser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+	noteID, _ := testHandlers.Store.CreateNote(userID, int(notebookID), "line one\nline two")
+	testHandlers.Store.UpdateNote(int(noteID), userID, "line one\nline 2")
+	testHandlers.Store.UpdateNote(int(noteID), userID, "oops")
+
+	getRevisions := func(uid int) (*httptest.ResponseRecorder, []revisionResponse) {
+		t.Helper()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/notes/%d/revisions", noteID), nil)
+		req.SetPathValue("id", fmt.Sprint(noteID))
+		req = requestWithUserID(req, uid)
+		w := httptest.NewRecorder()
+		testHandlers.RevisionsHandler(w, req)
+		var revisions []revisionResponse
+		json.NewDecoder(w.Body).Decode(&revisions)
+		return w, revisions
+	}
+
+	_, revisions := getRevisions(userID)
+	if len(revisions) != 3 {
+		t.Fatalf("Expected 3 revisions, got %d", len(revisions))
+	}
+	if revisions[0].Content != "oops" || revisions[2].Content != "line one\nline two" {
+		t.Errorf("Expected newest revision first, got %q ... %q", revisions[0].Content, revisions[2].Content)
+	}
+	middle := revisions[1].Diff
+	if len(middle) != 3 || middle[0].Op != "equal" || middle[1].Op != "delete" || middle[2].Text != "line 2" {
+		t.Errorf("Unexpected diff: %+v", middle)
+	}
+
+	// Restore the first version
+	original := revisions[2].ID
+	restore := func() {
+		t.Helper()
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/notes/%d/revisions/%d/restore", noteID, original), nil)
+		req.SetPathValue("id", fmt.Sprint(noteID))
+		req.SetPathValue("revision_id", fmt.Sprint(original))
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		testHandlers.RestoreRevisionHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v", w.Code)
+		}
+	}
+	restore()
+
+	_, revisions = getRevisions(userID)
+	if len(revisions) != 4 || revisions[0].Content != "line one\nline two" {
+		t.Errorf("Expected restore to add a revision with the original content, got %+v", revisions)
+	}
+
+	// Restoring the content the note already has is still recorded
+	restore()
+	if _, revisions = getRevisions(userID); len(revisions) != 5 || len(revisions[0].Diff) != 2 || revisions[0].Diff[0].Op != "equal" {
+		t.Errorf("Expected a second restore to add an unchanged revision, got %+v", revisions)
+	}
+
+	// Other users can't see the history
+	testHandlers.Store.CreateUser("revother", "hash")
+	otherID, _ := testHandlers.Store.GetUserID("revother")
+	if w, _ := getRevisions(otherID); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound for another user, got %v", w.Code)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	testHandlers.Store.CreateUser("trashuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("trashuser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+	noteID, _ := testHandlers.Store.CreateNote(userID, int(notebookID), "Keep me #trashed")
+	otherNotebookID, _ := testHandlers.Store.CreateNotebook(userID, "Old project")
+	testHandlers.Store.CreateNote(userID, int(otherNotebookID), "Inside a deleted notebook")
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		switch {
+		case strings.HasPrefix(url, "/api/notes"):
+			testHandlers.NotesHandler(w, req)
+		case strings.HasPrefix(url, "/api/notebooks"):
+			testHandlers.NotebooksHandler(w, req)
+		default:
+			testHandlers.TrashHandler(w, req)
+		}
+		return w
+	}
+	listTrash := func() []models.TrashItem {
+		t.Helper()
+		var items []models.TrashItem
+		json.NewDecoder(do("GET", "/api/trash", "").Body).Decode(&items)
+		return items
+	}
+
+	if w := do("DELETE", fmt.Sprintf("/api/notes?id=%d", noteID), ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK deleting note, got %v", w.Code)
+	}
+	if w := do("DELETE", fmt.Sprintf("/api/notebooks?id=%d", otherNotebookID), ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK deleting notebook, got %v", w.Code)
+	}
+
+	notes, _ := testHandlers.Store.GetNotes(userID, int(notebookID))
+	if len(notes) != 0 {
+		t.Errorf("Expected deleted note to be hidden, got %d notes", len(notes))
+	}
+	if results, _ := testHandlers.Store.Search(userID, "deleted notebook", models.SearchFilters{}); len(results) != 0 {
+		t.Errorf("Expected notes in a deleted notebook to be hidden from search, got %d", len(results))
+	}
+	if tags, _ := testHandlers.Store.GetTags(userID); len(tags) != 0 {
+		t.Errorf("Expected tags of deleted notes to be hidden, got %v", tags)
+	}
+
+	items := listTrash()
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 trash items, got %d", len(items))
+	}
+	for _, item := range items {
+		if !item.PurgeAt.Equal(item.DeletedAt.Add(testHandlers.TrashRetention)) {
+			t.Errorf("Expected purge_at to be deleted_at + retention, got %+v", item)
+		}
+	}
+
+	if w := do("POST", "/api/trash", fmt.Sprintf(`{"type": "note", "id": %d}`, noteID)); w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK restoring note, got %v", w.Code)
+	}
+	notes, _ = testHandlers.Store.GetNotes(userID, int(notebookID))
+	if len(notes) != 1 {
+		t.Errorf("Expected restored note to be visible, got %d notes", len(notes))
+	}
+	if w := do("POST", "/api/trash", fmt.Sprintf(`{"type": "note", "id": %d}`, noteID)); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound restoring a live note, got %v", w.Code)
+	}
+
+	// Purging with a cutoff in the future removes the notebook and its notes for good
+	if _, err := testHandlers.Store.PurgeTrash(time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("PurgeTrash failed: %v", err)
+	}
+	if items := listTrash(); len(items) != 0 {
+		t.Errorf("Expected empty trash after purge, got %+v", items)
+	}
+	if w := do("POST", "/api/trash", fmt.Sprintf(`{"type": "notebook", "id": %d}`, otherNotebookID)); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound restoring a purged notebook, got %v", w.Code)
+	}
+
+	// A single trashed notebook can be purged right away, but not a live one
+	failedImportID, _ := testHandlers.Store.CreateNotebook(userID, "Half imported")
+	importedID, _ := testHandlers.Store.CreateNote(userID, int(failedImportID), "First note")
+	testHandlers.Store.CreateNoteImage(int(importedID), "imported.gif")
+	if filenames, err := testHandlers.Store.PurgeNotebook(int(failedImportID), userID); err != nil || len(filenames) != 0 {
+		t.Errorf("Expected a live notebook to be kept, got %v: %v", filenames, err)
+	}
+	testHandlers.Store.DeleteNotebook(int(failedImportID), userID)
+	filenames, err := testHandlers.Store.PurgeNotebook(int(failedImportID), userID)
+	if err != nil || len(filenames) != 1 || filenames[0] != "imported.gif" {
+		t.Errorf("Expected the notebook's image to be purged, got %v: %v", filenames, err)
+	}
+	if items := listTrash(); len(items) != 0 {
+		t.Errorf("Expected the purged notebook to leave the trash, got %+v", items)
+	}
+}
+
+func TestImageUploadAndServe(t *testing.T) {
+	testHandlers.Store.CreateUser("imageuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("imageuser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+	noteID, _ := testHandlers.Store.CreateNote(userID, int(notebookID), "Note with a picture")
+
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("note_id", fmt.Sprint(noteID))
+	part, _ := mw.CreateFormFile("image", "pixel.gif")
+	part.Write(gif)
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/api/images", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req = requestWithUserID(req, userID)
+	w := httptest.NewRecorder()
+	testHandlers.ImagesHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK uploading image, got %v: %s", w.Code, w.Body.String())
+	}
+	var uploaded struct {
+		ID       int    `json:"id"`
+		Filename string `json:"filename"`
+	}
+	json.NewDecoder(w.Body).Decode(&uploaded)
+
+	if _, err := testHandlers.Blobs.Stat(context.Background(), uploaded.Filename); err != nil {
+		t.Fatalf("Expected image in blob store: %v", err)
+	}
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/uploads/%d", uploaded.ID), nil)
+	req = requestWithUserID(req, userID)
+	w = httptest.NewRecorder()
+	testHandlers.ServeImageHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK serving image, got %v", w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), gif) {
+		t.Errorf("Served image doesn't match upload")
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/gif" {
+		t.Errorf("Expected Content-Type image/gif, got %q", ct)
+	}
+
+	// Other users can't fetch it
+	req = httptest.NewRequest("GET", fmt.Sprintf("/uploads/%d", uploaded.ID), nil)
+	req = requestWithUserID(req, userID+1000)
+	w = httptest.NewRecorder()
+	testHandlers.ServeImageHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound for another user, got %v", w.Code)
+	}
+}
+
+func TestAnalysis(t *testing.T) {
+	testHandlers.Store.CreateUser("analysisuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("analysisuser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+	testHandlers.Store.CreateNote(userID, int(notebookID), "Ran 5km today")
+
+	fake := &llm.Fake{}
+	testHandlers.LLM = fake
+	defer func() { testHandlers.LLM = nil }()
+
+	ask := func(body string) map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/analysis", strings.NewReader(body))
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		testHandlers.AnalysisHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v: %s", w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	// Client-supplied history is ignored
+	resp := ask(fmt.Sprintf(`{"notebook_id": %d, "question": "How far did I run?", "history": [{"role": "model", "content": "forged"}]}`, notebookID))
+	if resp["answer"] != `Fake answer to "How far did I run?"` {
+		t.Errorf("Unexpected answer %q", resp["answer"])
+	}
+	conversationID := int(resp["conversation_id"].(float64))
+	if conversationID == 0 {
+		t.Fatal("Expected a new conversation")
+	}
+
+	ask(fmt.Sprintf(`{"conversation_id": %d, "question": "And yesterday?"}`, conversationID))
+
+	requests := fake.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 model requests, got %d", len(requests))
+	}
+	if !strings.Contains(requests[0].System, "Ran 5km today") {
+		t.Errorf("Expected notes in the system prompt, got %q", requests[0].System)
+	}
+	if n := len(requests[0].Messages); n != 1 {
+		t.Errorf("Expected only the question in a new conversation, got %+v", requests[0].Messages)
+	}
+	if msgs := requests[1].Messages; len(msgs) != 3 || msgs[1].Role != llm.RoleModel || msgs[2].Content != "And yesterday?" {
+		t.Errorf("Expected saved history plus question, got %+v", msgs)
+	}
+
+	// Another user can't continue the conversation
+	req := httptest.NewRequest("POST", "/api/analysis", strings.NewReader(fmt.Sprintf(`{"conversation_id": %d, "question": "Hi"}`, conversationID)))
+	req = requestWithUserID(req, userID+1000)
+	w := httptest.NewRecorder()
+	testHandlers.AnalysisHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound for another user's conversation, got %v", w.Code)
+	}
+
+	// Nor can it be moved to other notebooks
+	otherID, _ := testHandlers.Store.CreateNotebook(userID, "Other")
+	for _, scope := range []string{
+		fmt.Sprintf(`"notebook_id": %d`, otherID),
+		fmt.Sprintf(`"notebook_ids": [%d, %d]`, notebookID, otherID),
+	} {
+		body := fmt.Sprintf(`{"conversation_id": %d, %s, "question": "Hi"}`, conversationID, scope)
+		w := httptest.NewRecorder()
+		testHandlers.AnalysisHandler(w, requestWithUserID(httptest.NewRequest("POST", "/api/analysis", strings.NewReader(body)), userID))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status Bad Request continuing with %s, got %v", scope, w.Code)
+		}
+	}
+	if n := len(fake.Requests()); n != 2 {
+		t.Errorf("Expected no model requests for another notebook, got %d", n-2)
+	}
+}
+
+func TestConversations(t *testing.T) {
+	testHandlers.Store.CreateUser("convuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("convuser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/conversations", testHandlers.ConversationsHandler)
+		mux.HandleFunc("/api/conversations/{id}", testHandlers.ConversationHandler)
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/conversations", fmt.Sprintf(`{"notebook_id": %d, "title": "Sleep"}`, notebookID))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v", w.Code)
+	}
+	var created map[string]int
+	json.NewDecoder(w.Body).Decode(&created)
+	id := created["id"]
+	testHandlers.Store.AddConversationMessages(id, userID,
+		models.ChatMessage{Role: "user", Content: "How did I sleep?"},
+		models.ChatMessage{Role: "model", Content: "Well."},
+	)
+
+	if w := do("PUT", fmt.Sprintf("/api/conversations/%d", id), `{"title": "Sleep review"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK renaming, got %v", w.Code)
+	}
+
+	var conversation models.Conversation
+	json.NewDecoder(do("GET", fmt.Sprintf("/api/conversations/%d", id), "").Body).Decode(&conversation)
+	if conversation.Title != "Sleep review" || len(conversation.Messages) != 2 || conversation.Messages[1].Content != "Well." {
+		t.Errorf("Unexpected conversation %+v", conversation)
+	}
+
+	var list []models.Conversation
+	json.NewDecoder(do("GET", fmt.Sprintf("/api/conversations?notebook_id=%d", notebookID), "").Body).Decode(&list)
+	if len(list) != 1 || list[0].ID != id || list[0].Messages != nil {
+		t.Errorf("Expected one conversation without messages, got %+v", list)
+	}
+
+	if w := do("DELETE", fmt.Sprintf("/api/conversations/%d", id), ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK deleting, got %v", w.Code)
+	}
+	if w := do("GET", fmt.Sprintf("/api/conversations/%d", id), ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound after delete, got %v", w.Code)
+	}
+
+	// Conversations about a trashed notebook can't be renamed
+	trashedID, _ := testHandlers.Store.CreateNotebook(userID, "Old plans")
+	trashedConv, _ := testHandlers.Store.CreateConversation(userID, []int{int(trashedID)}, "Plans")
+	testHandlers.Store.DeleteNotebook(int(trashedID), userID)
+	if w := do("PUT", fmt.Sprintf("/api/conversations/%d", trashedConv), `{"title": "Renamed"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound renaming in a trashed notebook, got %v", w.Code)
+	}
+}
+
+func TestAnalysisStream(t *testing.T) {
+	testHandlers.Store.CreateUser("streamuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("streamuser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+	testHandlers.Store.CreateNote(userID, int(notebookID), "Slept 8 hours")
+
+	testHandlers.LLM = &llm.Fake{}
+	defer func() { testHandlers.LLM = nil }()
+
+	stream := func(ctx context.Context) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"notebook_id": %d, "question": "How did I sleep?"}`, notebookID)
+		req := httptest.NewRequest("POST", "/api/analysis/stream", strings.NewReader(body)).WithContext(ctx)
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		testHandlers.AnalysisStreamHandler(w, req)
+		return w
+	}
+
+	w := stream(context.Background())
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q: %s", ct, w.Body.String())
+	}
+
+	var answer strings.Builder
+	var events []string
+	for _, block := range strings.Split(strings.TrimSpace(w.Body.String()), "\n\n") {
+		lines := strings.SplitN(block, "\n", 2)
+		event := strings.TrimPrefix(lines[0], "event: ")
+		events = append(events, event)
+		if event == "token" {
+			var data map[string]string
+			json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &data)
+			answer.WriteString(data["text"])
+		}
+	}
+	if len(events) < 3 || events[len(events)-1] != "done" {
+		t.Errorf("Expected several tokens then done, got %v", events)
+	}
+	if answer.String() != `Fake answer to "How did I sleep?"` {
+		t.Errorf("Unexpected streamed answer %q", answer.String())
+	}
+
+	// A disconnected client gets no further events
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if body := stream(ctx).Body.String(); strings.Contains(body, "event: done") {
+		t.Errorf("Expected no done event after cancellation, got %q", body)
+	}
+}
+
+func TestSemanticSearch(t *testing.T) {
+	testHandlers.Store.CreateUser("semanticuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("semanticuser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+	runID, _ := testHandlers.Store.CreateNote(userID, int(notebookID), "Morning run along the river")
+	testHandlers.Store.CreateNote(userID, int(notebookID), "Dentist appointment on Friday")
+
+	search := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/search?mode=semantic&q=river+run", nil)
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		testHandlers.SearchHandler(w, req)
+		return w
+	}
+
+	if w := search(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status ServiceUnavailable without an embedder, got %v", w.Code)
+	}
+
+	testHandlers.Embedder = &llm.Fake{}
+	defer func() { testHandlers.Embedder = nil }()
+	if err := testHandlers.IndexNotes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []models.SearchResult
+	json.NewDecoder(search().Body).Decode(&results)
+	if len(results) != 2 || results[0].ID != int(runID) {
+		t.Fatalf("Expected the run note first, got %+v", results)
+	}
+	if results[0].Rank <= results[1].Rank {
+		t.Errorf("Expected results ranked by similarity, got %v and %v", results[0].Rank, results[1].Rank)
+	}
+
+	// Edited notes are re-embedded
+	testHandlers.Store.UpdateNote(int(runID), userID, "Evening swim")
+	pending, _ := testHandlers.Store.GetNotesWithoutEmbedding("fake", 0, 10)
+	if len(pending) != 1 || pending[0].ID != int(runID) {
+		t.Errorf("Expected the edited note to need embedding, got %+v", pending)
+	}
+	testHandlers.IndexNotes(context.Background())
+	if pending, _ := testHandlers.Store.GetNotesWithoutEmbedding("fake", 0, 10); len(pending) != 0 {
+		t.Errorf("Expected every note to be embedded, got %d pending", len(pending))
+	}
+
+	// A note the model rejects doesn't hold up the others, and isn't retried
+	// until it's edited
+	embedder := &rejectingEmbedder{reject: "oversized"}
+	testHandlers.Embedder = embedder
+	badID, _ := testHandlers.Store.CreateNote(userID, int(notebookID), "An oversized note")
+	testHandlers.Store.CreateNote(userID, int(notebookID), "A normal note")
+	if err := testHandlers.IndexNotes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pending, _ = testHandlers.Store.GetNotesWithoutEmbedding("fake", 0, 10)
+	if len(pending) != 1 || pending[0].ID != int(badID) {
+		t.Errorf("Expected only the rejected note to be pending, got %+v", pending)
+	}
+	embedder.calls = 0
+	testHandlers.IndexNotes(context.Background())
+	if embedder.calls != 0 {
+		t.Errorf("Expected the rejected note to be skipped, got %d calls", embedder.calls)
+	}
+	testHandlers.Store.UpdateNote(int(badID), userID, "A shorter note")
+	testHandlers.IndexNotes(context.Background())
+	if pending, _ := testHandlers.Store.GetNotesWithoutEmbedding("fake", 0, 10); len(pending) != 0 {
+		t.Errorf("Expected the edited note to be embedded, got %d pending", len(pending))
+	}
+
+	// Failures of notes deleted since are forgotten
+	deletedID, _ := testHandlers.Store.CreateNote(userID, int(notebookID), "Another oversized note")
+	testHandlers.IndexNotes(context.Background())
+	if _, ok := testHandlers.embedFailures[int(deletedID)]; !ok {
+		t.Fatal("Expected the rejected note to be remembered")
+	}
+	testHandlers.Store.DeleteNote(int(deletedID), userID)
+	testHandlers.IndexNotes(context.Background())
+	if _, ok := testHandlers.embedFailures[int(deletedID)]; ok {
+		t.Error("Expected the deleted note's failure to be forgotten")
+	}
+}
+
+// rejectingEmbedder fails every batch with a text containing reject
+type rejectingEmbedder struct {
+	llm.Fake
+	reject string
+	calls  int
+}
+
+func (e *rejectingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	for _, text := range texts {
+		if strings.Contains(text, e.reject) {
+			return nil, errors.New("input too long")
+		}
+	}
+	return e.Fake.Embed(ctx, texts)
+}
+
+func TestParseTimeRange(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2026, 10, 14, 15, 30, 0, 0, time.UTC)
+	day := func(m time.Month, d int) time.Time { return time.Date(2026, m, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+	}{
+		{"today", day(10, 14), day(10, 15)},
+		{"yesterday", day(10, 13), day(10, 14)},
+		{"this_week", day(10, 12), day(10, 15)},
+		{"last_week", day(10, 5), day(10, 12)},
+		{"last_month", day(9, 1), day(10, 1)},
+		{"last_14_days", day(10, 1), day(10, 15)},
+	}
+	for _, tt := range tests {
+		start, end, err := parseTimeRange(tt.name, now)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		// end is inclusive, one nanosecond before the next period
+		if !start.Equal(tt.start) || !end.Equal(tt.end.Add(-time.Nanosecond)) {
+			t.Errorf("%s: got %v - %v, expected %v - %v", tt.name, start, end, tt.start, tt.end)
+		}
+	}
+
+	for _, bad := range []string{"last_sprint", "last_0_days", "last_3_daysx"} {
+		if _, _, err := parseTimeRange(bad, now); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestAnalysisScope(t *testing.T) {
+	testHandlers.Store.CreateUser("scopeuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("scopeuser")
+	workID, _ := testHandlers.Store.CreateNotebook(userID, "Work")
+	homeID, _ := testHandlers.Store.CreateNotebook(userID, "Home")
+	testHandlers.Store.CreateNote(userID, int(workID), "Shipped the release")
+	testHandlers.Store.CreateNote(userID, int(homeID), "Fixed the sink")
+
+	fake := &llm.Fake{}
+	testHandlers.LLM = fake
+	defer func() { testHandlers.LLM = nil }()
+
+	ask := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/analysis", strings.NewReader(body))
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		testHandlers.AnalysisHandler(w, req)
+		return w
+	}
+
+	w := ask(fmt.Sprintf(`{"notebook_ids": [%d, %d], "range": "this_week", "question": "What did I do?"}`, workID, homeID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", w.Code, w.Body.String())
+	}
+	requests := fake.Requests()
+	system := requests[len(requests)-1].System
+	if !strings.Contains(system, `in "Work"`) || !strings.Contains(system, `in "Home"`) {
+		t.Errorf("Expected notes from both notebooks, got %q", system)
+	}
+
+	// Continuing the conversation, naming its notebooks again or not, still
+	// covers both of them
+	var started map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&started)
+	conversationID := int(started["conversation_id"].(float64))
+	for _, body := range []string{
+		fmt.Sprintf(`{"conversation_id": %d, "notebook_ids": [%d, %d], "question": "And then?"}`, conversationID, workID, homeID),
+		fmt.Sprintf(`{"conversation_id": %d, "notebook_id": %d, "question": "And then?"}`, conversationID, homeID),
+		fmt.Sprintf(`{"conversation_id": %d, "question": "And then?"}`, conversationID),
+	} {
+		if w := ask(body); w.Code != http.StatusOK {
+			t.Fatalf("Expected status OK for %s, got %v: %s", body, w.Code, w.Body.String())
+		}
+		requests := fake.Requests()
+		if system := requests[len(requests)-1].System; !strings.Contains(system, `in "Work"`) || !strings.Contains(system, `in "Home"`) {
+			t.Errorf("Expected notes from both notebooks for %s, got %q", body, system)
+		}
+	}
+
+	// A range before the notes were written has nothing to analyze
+	w = ask(fmt.Sprintf(`{"notebook_id": %d, "start": "2020-01-01", "end": "2020-12-31", "question": "What did I do?"}`, workID))
+	var resp map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["answer"] != noNotesAnswer {
+		t.Errorf("Expected no notes in range, got %q", resp["answer"])
+	}
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"notebook_id": %d, "range": "last_sprint", "question": "?"}`, workID),
+		fmt.Sprintf(`{"notebook_id": %d, "range": "today", "start": "2020-01-01", "question": "?"}`, workID),
+		fmt.Sprintf(`{"notebook_id": %d, "start": "2021-01-01", "end": "2020-01-01", "question": "?"}`, workID),
+		`{"question": "?"}`,
+	} {
+		if w := ask(body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status BadRequest for %s, got %v", body, w.Code)
+		}
+	}
+}
+
+func TestDigests(t *testing.T) {
+	testHandlers.Store.CreateUser("digestuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("digestuser")
+	journalID, _ := testHandlers.Store.CreateNotebook(userID, "Journal")
+	workID, _ := testHandlers.Store.CreateNotebook(userID, "Work")
+	testHandlers.Store.CreateNote(userID, int(journalID), "Hiked up the hill")
+	testHandlers.Store.CreateNote(userID, int(workID), "Quarterly planning")
+
+	fake := &llm.Fake{}
+	testHandlers.LLM = fake
+	defer func() { testHandlers.LLM = nil }()
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/digests", testHandlers.DigestsHandler)
+		mux.HandleFunc("/api/digests/{id}", testHandlers.DigestHandler)
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/api/digests", `{"cadence": "daily"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest for an unknown cadence, got %v", w.Code)
+	}
+	testHandlers.Store.CreateUser("digestother", "hash")
+	otherID, _ := testHandlers.Store.GetUserID("digestother")
+	otherNotebookID, _ := testHandlers.Store.CreateNotebook(otherID, "Not yours")
+	for _, body := range []string{
+		`{"cadence": "weekly"}`,
+		fmt.Sprintf(`{"cadence": "weekly", "notebook_ids": [%d, 99999]}`, journalID),
+		fmt.Sprintf(`{"cadence": "weekly", "notebook_ids": [%d]}`, otherNotebookID),
+		fmt.Sprintf(`{"cadence": "weekly", "notebook_ids": [%d], "all_notebooks": true}`, journalID),
+	} {
+		if w := do("POST", "/api/digests", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status BadRequest for %s, got %v", body, w.Code)
+		}
+	}
+	w := do("POST", "/api/digests", fmt.Sprintf(`{"cadence": "weekly", "notebook_ids": [%d]}`, journalID))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v: %s", w.Code, w.Body.String())
+	}
+	var created map[string]int
+	json.NewDecoder(w.Body).Decode(&created)
+
+	var digests []models.Digest
+	json.NewDecoder(do("GET", "/api/digests", "").Body).Decode(&digests)
+	if len(digests) != 1 || digests[0].Prompt != defaultDigestPrompt || len(digests[0].NotebookIDs) != 1 || digests[0].NotebookIDs[0] != int(journalID) {
+		t.Fatalf("Expected the digest with the journal, got %+v", digests)
+	}
+
+	// Nothing is due until the week is over
+	if err := testHandlers.RunDueDigests(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Requests()); n != 0 {
+		t.Fatalf("Expected no digest yet, got %d model requests", n)
+	}
+
+	nextWeek := time.Now().AddDate(0, 0, 7)
+	for range 2 {
+		if err := testHandlers.RunDueDigests(context.Background(), nextWeek); err != nil {
+			t.Fatal(err)
+		}
+	}
+	requests := fake.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected one digest run, got %d model requests", len(requests))
+	}
+	if !strings.Contains(requests[0].System, "Hiked up the hill") || strings.Contains(requests[0].System, "Quarterly planning") {
+		t.Errorf("Expected only the journal's notes, got %q", requests[0].System)
+	}
+
+	digestNotebookID, err := testHandlers.Store.GetNotebookByName(userID, digestNotebookName)
+	if err != nil {
+		t.Fatalf("Expected a Digests notebook: %v", err)
+	}
+	notes, _ := testHandlers.Store.GetNotes(userID, digestNotebookID)
+	if len(notes) != 1 || !strings.HasPrefix(notes[0].Content, "Weekly digest: ") || !strings.Contains(notes[0].Content, "Fake answer") {
+		t.Errorf("Unexpected digest notes %+v", notes)
+	}
+	if w := do("PUT", fmt.Sprintf("/api/digests/%d", created["id"]), fmt.Sprintf(`{"cadence": "weekly", "notebook_ids": [%d]}`, digestNotebookID)); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest for the Digests notebook, got %v", w.Code)
+	}
+
+	w = do("PUT", fmt.Sprintf("/api/digests/%d", created["id"]), `{"cadence": "monthly", "prompt": "What went well?", "all_notebooks": true}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status OK updating, got %v", w.Code)
+	}
+	json.NewDecoder(do("GET", "/api/digests", "").Body).Decode(&digests)
+	if digests[0].Cadence != models.DigestMonthly || digests[0].Prompt != "What went well?" || !digests[0].AllNotebooks || len(digests[0].NotebookIDs) != 0 {
+		t.Errorf("Unexpected updated digest %+v", digests[0])
+	}
+
+	// All notebooks means all the user owns, not ones shared with them
+	testHandlers.Store.CreateNote(otherID, int(otherNotebookID), "Someone else's plans")
+	testHandlers.Store.SetNotebookMember(int(otherNotebookID), userID, models.RoleViewer)
+	if err := testHandlers.RunDueDigests(context.Background(), time.Now().AddDate(0, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	requests = fake.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("Expected a second digest run, got %d model requests", len(requests))
+	}
+	if !strings.Contains(requests[1].System, "Quarterly planning") || strings.Contains(requests[1].System, "Someone else's plans") {
+		t.Errorf("Expected only the user's own notebooks, got %q", requests[1].System)
+	}
+
+	if w := do("DELETE", fmt.Sprintf("/api/digests/%d", created["id"]), ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK deleting, got %v", w.Code)
+	}
+	if w := do("DELETE", fmt.Sprintf("/api/digests/%d", created["id"]), ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound deleting again, got %v", w.Code)
+	}
+}
+
+func TestDigestRetries(t *testing.T) {
+	testHandlers.Store.CreateUser("digestretry", "hash")
+	userID, _ := testHandlers.Store.GetUserID("digestretry")
+	notebookID, _ := testHandlers.Store.CreateNotebook(userID, "Journal")
+	digestID, _ := testHandlers.Store.CreateDigest(userID, models.Digest{Cadence: models.DigestWeekly, Prompt: "Summarize", NotebookIDs: []int{int(notebookID)}})
+	defer testHandlers.Store.DeleteDigest(int(digestID), userID)
+
+	// Midweek, so that the retries stay within one period
+	base := time.Now().AddDate(0, 0, 7)
+	for base.Weekday() != time.Wednesday {
+		base = base.AddDate(0, 0, 1)
+	}
+	base = time.Date(base.Year(), base.Month(), base.Day(), 12, 0, 0, 0, time.Local)
+	testHandlers.Store.CreateNoteAt(userID, int(notebookID), "Too long to summarize", base.AddDate(0, 0, -7))
+
+	fake := &llm.Fake{Reply: func(llm.Request) (string, error) { return "", errors.New("context length exceeded") }}
+	testHandlers.LLM = fake
+	defer func() { testHandlers.LLM = nil }()
+
+	// Retried after 1, 2 and 4 hours, then given up on for the week
+	for _, tt := range []struct {
+		hours int
+		runs  int
+	}{{0, 1}, {0, 1}, {1, 2}, {2, 2}, {3, 3}, {6, 3}, {7, 4}, {30, 4}} {
+		if err := testHandlers.RunDueDigests(context.Background(), base.Add(time.Duration(tt.hours)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(fake.Requests()); n != tt.runs {
+			t.Errorf("Expected %d runs after %d hours, got %d", tt.runs, tt.hours, n)
+		}
+	}
+	digests, _ := testHandlers.Store.GetDigests(userID)
+	if len(digests) != 1 || digests[0].Failures != 0 {
+		t.Errorf("Expected the failures to be reset after giving up, got %+v", digests)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	testHandlers.SignupHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/signup", strings.NewReader(`{"username": "sessionuser", "password": "password123"}`)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/logout", testHandlers.LogoutHandler)
+	mux.HandleFunc("/api/sessions", testHandlers.SessionsHandler)
+	mux.HandleFunc("/api/sessions/{id}", testHandlers.SessionHandler)
+	mux.HandleFunc("/api/account/export", testHandlers.AccountExportHandler)
+	handler := middleware.Auth(testHandlers.Store, mux)
+
+	login := func(userAgent string) *http.Cookie {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username": "sessionuser", "password": "password123"}`))
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		testHandlers.LoginHandler(w, req)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == auth.SessionCookieName {
+				return c
+			}
+		}
+		t.Fatal("Expected a session cookie")
+		return nil
+	}
+	do := func(cookie *http.Cookie, method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	laptop := login("Laptop")
+	phone := login("Phone")
+	tablet := login("Tablet")
+
+	w := do(laptop, "GET", "/api/sessions")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", w.Code)
+	}
+	var sessions []models.Session
+	json.NewDecoder(w.Body).Decode(&sessions)
+	if len(sessions) != 3 {
+		t.Fatalf("Expected 3 sessions, got %+v", sessions)
+	}
+	phoneID := 0
+	for _, s := range sessions {
+		if s.Current != (s.UserAgent == "Laptop") {
+			t.Errorf("Expected only the laptop session to be current, got %+v", s)
+		}
+		if s.UserAgent == "Phone" {
+			phoneID = s.ID
+		}
+	}
+
+	// A revoked session can no longer be used
+	if w := do(laptop, "DELETE", fmt.Sprintf("/api/sessions/%d", phoneID)); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK revoking, got %v", w.Code)
+	}
+	if w := do(phone, "GET", "/api/sessions"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for a revoked session, got %v", w.Code)
+	}
+
+	// Logging out changes state, so a GET (e.g. an <img> on another site)
+	// must not do it
+	if w := do(tablet, "GET", "/api/logout"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status Method Not Allowed logging out with GET, got %v", w.Code)
+	}
+	if w := do(tablet, "POST", "/api/logout"); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK logging out, got %v", w.Code)
+	}
+	if w := do(tablet, "GET", "/api/sessions"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized after logout, got %v", w.Code)
+	}
+
+	// Logging out everywhere revokes the current session too
+	other := login("Desktop")
+	if w := do(laptop, "DELETE", "/api/sessions"); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK logging out everywhere, got %v", w.Code)
+	}
+	for _, cookie := range []*http.Cookie{laptop, other} {
+		if w := do(cookie, "GET", "/api/sessions"); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status Unauthorized after logging out everywhere, got %v", w.Code)
+		}
+	}
+	if w := do(&http.Cookie{Name: auth.SessionCookieName, Value: "forged"}, "GET", "/api/sessions"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for an unknown token, got %v", w.Code)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	testHandlers.Store.CreateUser("tokenuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("tokenuser")
+	notebookID, _ := testHandlers.Store.CreateDefaultNotebook(userID)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/notes", testHandlers.NotesHandler)
+	mux.HandleFunc("/api/tokens", testHandlers.TokensHandler)
+	mux.HandleFunc("/api/tokens/{id}", testHandlers.TokenHandler)
+	mux.HandleFunc("/api/sessions", testHandlers.SessionsHandler)
+	mux.HandleFunc("/api/sessions/{id}", testHandlers.SessionHandler)
+	mux.HandleFunc("/api/account/export", testHandlers.AccountExportHandler)
+	handler := middleware.Auth(testHandlers.Store, mux)
+
+	create := func(body string) models.APIToken {
+		t.Helper()
+		req := requestWithUserID(httptest.NewRequest("POST", "/api/tokens", strings.NewReader(body)), userID)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status Created, got %v: %s", w.Code, w.Body.String())
+		}
+		var token models.APIToken
+		json.NewDecoder(w.Body).Decode(&token)
+		return token
+	}
+	do := func(token, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	readToken := create(`{"name": "Backup script"}`)
+	writeToken := create(`{"name": "CI", "scope": "write", "expires_in_days": 30}`)
+	if !strings.HasPrefix(readToken.Token, auth.APITokenPrefix) || readToken.Scope != models.ScopeRead || readToken.ExpiresAt != nil {
+		t.Errorf("Unexpected read token %+v", readToken)
+	}
+	if writeToken.ExpiresAt == nil {
+		t.Error("Expected the write token to expire")
+	}
+
+	notesURL := fmt.Sprintf("/api/notes?notebook_id=%d", notebookID)
+	if w := do(writeToken.Token, "POST", notesURL, `{"content": "Captured from CI"}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected status Created with a write token, got %v", w.Code)
+	}
+	if w := do(readToken.Token, "POST", notesURL, `{"content": "Nope"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden writing with a read token, got %v", w.Code)
+	}
+	w := do(readToken.Token, "GET", notesURL, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Captured from CI") {
+		t.Errorf("Expected to read notes with a read token, got %v: %s", w.Code, w.Body.String())
+	}
+
+	// Tokens can't manage tokens or sessions
+	for _, req := range []struct{ method, url string }{
+		{"GET", "/api/tokens"},
+		{"GET", "/api/sessions"},
+		{"DELETE", "/api/sessions"},
+		{"DELETE", "/api/sessions/1"},
+	} {
+		if w := do(writeToken.Token, req.method, req.url, ""); w.Code != http.StatusForbidden {
+			t.Errorf("Expected status Forbidden for %s %s with a token, got %v", req.method, req.url, w.Code)
+		}
+	}
+	if w := do(readToken.Token, "GET", "/api/account/export", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden exporting the account with a read token, got %v", w.Code)
+	}
+
+	req := requestWithUserID(httptest.NewRequest("GET", "/api/tokens", nil), userID)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var tokens []models.APIToken
+	json.NewDecoder(w.Body).Decode(&tokens)
+	if len(tokens) != 2 || tokens[0].Token != "" || tokens[1].LastUsedAt == nil {
+		t.Errorf("Expected two listed tokens without secrets, the read one used, got %+v", tokens)
+	}
+
+	req = requestWithUserID(httptest.NewRequest("DELETE", fmt.Sprintf("/api/tokens/%d", readToken.ID), nil), userID)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status OK revoking, got %v", w.Code)
+	}
+	if w := do(readToken.Token, "GET", notesURL, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for a revoked token, got %v", w.Code)
+	}
+	if w := do("tracky_forged", "GET", notesURL, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for an unknown token, got %v", w.Code)
+	}
+}
+
+func TestAccount(t *testing.T) {
+	testHandlers.SignupHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/signup", strings.NewReader(`{"username": "accountuser", "password": "old-password"}`)))
+	userID, _ := testHandlers.Store.GetUserID("accountuser")
+	notebooks, _ := testHandlers.Store.GetNotebooks(userID)
+	noteID, _ := testHandlers.Store.CreateNote(userID, notebooks[0].ID, "Keep this #forever")
+	testHandlers.Blobs.Put(context.Background(), "account.gif", strings.NewReader("GIF89a"), 6, "image/gif")
+	testHandlers.Store.CreateNoteImage(int(noteID), "account.gif")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/account", testHandlers.AccountHandler)
+	mux.HandleFunc("/api/account/export", testHandlers.AccountExportHandler)
+	handler := middleware.Auth(testHandlers.Store, mux)
+
+	login := func(password string) *http.Cookie {
+		w := httptest.NewRecorder()
+		testHandlers.LoginHandler(w, httptest.NewRequest("POST", "/api/login", strings.NewReader(fmt.Sprintf(`{"username": "accountuser", "password": %q}`, password))))
+		for _, c := range w.Result().Cookies() {
+			if c.Name == auth.SessionCookieName {
+				return c
+			}
+		}
+		return nil
+	}
+	do := func(cookie *http.Cookie, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	cookie := login("old-password")
+	otherDevice := login("old-password")
+
+	// Export
+	w := do(cookie, "GET", "/api/account/export", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Expected a zip export, got %v: %s", w.Code, w.Body.String())
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	if files["images/account.gif"] != "GIF89a" {
+		t.Errorf("Expected the image in the export, got files %v", archive.File)
+	}
+	var export accountExport
+	json.Unmarshal([]byte(files["data.json"]), &export)
+	if export.Username != "accountuser" || len(export.Notebooks) != 1 || len(export.Notebooks[0].Notes) != 1 {
+		t.Fatalf("Unexpected export %+v", export)
+	}
+	if note := export.Notebooks[0].Notes[0]; note.Content != "Keep this #forever" || len(note.Revisions) != 1 || len(note.Tags) != 1 {
+		t.Errorf("Unexpected exported note %+v", note)
+	}
+
+	// Password change
+	if w := do(cookie, "PUT", "/api/account", `{"current_password": "wrong", "new_password": "new-password"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden with the wrong password, got %v", w.Code)
+	}
+	w = do(cookie, "PUT", "/api/account", `{"current_password": "old-password", "new_password": "new-password"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK changing password, got %v", w.Code)
+	}
+	if login("old-password") != nil {
+		t.Error("Expected the old password to stop working")
+	}
+	if w := do(otherDevice, "GET", "/api/account", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected other sessions to be signed out, got %v", w.Code)
+	}
+	cookie = w.Result().Cookies()[0]
+	if w := do(cookie, "GET", "/api/account", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected this session to continue, got %v", w.Code)
+	}
+
+	// Deletion
+	if w := do(cookie, "DELETE", "/api/account", `{"password": "old-password"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden with the wrong password, got %v", w.Code)
+	}
+
+	// Wrong passwords count towards the account lockout, as at login
+	accountID, _ := testHandlers.Store.GetUserID("accountuser")
+	testHandlers.Store.ResetFailedLogins(accountID)
+	for range maxFailedLogins - 1 {
+		do(cookie, "DELETE", "/api/account", `{"password": "wrong"}`)
+	}
+	if w := do(cookie, "PUT", "/api/account", `{"current_password": "wrong", "new_password": "other-password"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status TooManyRequests once locked, got %v", w.Code)
+	}
+	if w := do(cookie, "DELETE", "/api/account", `{"password": "new-password"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a locked account to refuse the right password, got %v", w.Code)
+	}
+	testHandlers.Store.ResetFailedLogins(accountID)
+
+	if w := do(cookie, "DELETE", "/api/account", `{"password": "new-password"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK deleting account, got %v", w.Code)
+	}
+	if _, err := testHandlers.Store.GetUserID("accountuser"); err == nil {
+		t.Error("Expected the user to be deleted")
+	}
+	if _, err := testHandlers.Blobs.Stat(context.Background(), "account.gif"); err == nil {
+		t.Error("Expected the image file to be deleted")
+	}
+	if w := do(cookie, "GET", "/api/account", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the session to end with the account, got %v", w.Code)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	testHandlers.SignupHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/signup", strings.NewReader(`{"username": "totpuser", "password": "password123"}`)))
+	userID, _ := testHandlers.Store.GetUserID("totpuser")
+
+	enroll := func(method, body string) *httptest.ResponseRecorder {
+		req := requestWithUserID(httptest.NewRequest(method, "/api/account/totp", strings.NewReader(body)), userID)
+		w := httptest.NewRecorder()
+		testHandlers.TOTPHandler(w, req)
+		return w
+	}
+	login := func() string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		testHandlers.LoginHandler(w, httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username": "totpuser", "password": "password123"}`)))
+		if len(w.Result().Cookies()) != 0 {
+			t.Fatal("Expected no session before the second factor")
+		}
+		var resp struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			Challenge         string `json:"challenge"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if !resp.TwoFactorRequired || resp.Challenge == "" {
+			t.Fatalf("Expected a two-factor challenge, got %+v", resp)
+		}
+		return resp.Challenge
+	}
+	verify := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		testHandlers.LoginTOTPHandler(w, httptest.NewRequest("POST", "/api/login/totp", strings.NewReader(body)))
+		return w
+	}
+
+	// Enrollment
+	var setup map[string]string
+	json.NewDecoder(enroll("POST", "").Body).Decode(&setup)
+	if !strings.HasPrefix(setup["uri"], "otpauth://totp/Tracky:totpuser?") {
+		t.Fatalf("Unexpected provisioning URI %q", setup["uri"])
+	}
+	if w := enroll("PUT", `{"code": "000000"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest for a wrong code, got %v", w.Code)
+	}
+	code, _ := auth.GenerateTOTP(setup["secret"], time.Now())
+	w := enroll("PUT", fmt.Sprintf(`{"code": %q}`, code))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK verifying, got %v: %s", w.Code, w.Body.String())
+	}
+	var recovery map[string][]string
+	json.NewDecoder(w.Body).Decode(&recovery)
+	if len(recovery["recovery_codes"]) == 0 {
+		t.Fatal("Expected recovery codes")
+	}
+
+	// Login with the authenticator; the enrollment code can't be replayed
+	challenge := login()
+	if w := verify(fmt.Sprintf(`{"challenge": %q, "code": %q}`, challenge, code)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized replaying a code, got %v", w.Code)
+	}
+	next, _ := auth.GenerateTOTP(setup["secret"], time.Now().Add(30*time.Second))
+	w = verify(fmt.Sprintf(`{"challenge": %q, "code": %q}`, challenge, next))
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) == 0 {
+		t.Fatalf("Expected a session after the second factor, got %v", w.Code)
+	}
+	if w := verify(fmt.Sprintf(`{"challenge": %q, "code": %q}`, challenge, next)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a used challenge to be rejected, got %v", w.Code)
+	}
+
+	// Login with a recovery code, which works only once
+	recoveryCode := recovery["recovery_codes"][0]
+	if w := verify(fmt.Sprintf(`{"challenge": %q, "recovery_code": %q}`, login(), recoveryCode)); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK with a recovery code, got %v", w.Code)
+	}
+	if w := verify(fmt.Sprintf(`{"challenge": %q, "recovery_code": %q}`, login(), recoveryCode)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a used recovery code to be rejected, got %v", w.Code)
+	}
+
+	// Too many wrong codes end the login attempt
+	challenge = login()
+	for range maxLoginChallengeAttempts {
+		verify(fmt.Sprintf(`{"challenge": %q, "code": "000000"}`, challenge))
+	}
+	if w := verify(fmt.Sprintf(`{"challenge": %q, "recovery_code": %q}`, challenge, recovery["recovery_codes"][1])); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the challenge to be locked, got %v", w.Code)
+	}
+
+	var status map[string]interface{}
+	json.NewDecoder(enroll("GET", "").Body).Decode(&status)
+	if status["enabled"] != true || status["recovery_codes_remaining"] != float64(len(recovery["recovery_codes"])-1) {
+		t.Errorf("Unexpected status %v", status)
+	}
+
+	// Disabling takes the second factor as well as the password, and returns
+	// to password-only login
+	if w := enroll("DELETE", `{"password": "password123"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden disabling without a code, got %v", w.Code)
+	}
+	if w := enroll("DELETE", fmt.Sprintf(`{"password": "wrong", "recovery_code": %q}`, recovery["recovery_codes"][1])); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden disabling with a wrong password, got %v", w.Code)
+	}
+
+	// Wrong codes count towards the account lockout, as at login
+	testHandlers.Store.ResetFailedLogins(userID)
+	for range maxFailedLogins - 1 {
+		enroll("DELETE", `{"password": "password123", "code": "000000"}`)
+	}
+	if w := enroll("DELETE", `{"password": "password123", "code": "000000"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status TooManyRequests once locked, got %v", w.Code)
+	}
+	if w := enroll("DELETE", fmt.Sprintf(`{"password": "password123", "recovery_code": %q}`, recovery["recovery_codes"][1])); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a locked account to refuse the right code, got %v", w.Code)
+	}
+	testHandlers.Store.ResetFailedLogins(userID)
+	if w := enroll("DELETE", fmt.Sprintf(`{"password": "password123", "recovery_code": %q}`, recovery["recovery_codes"][1])); w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK disabling, got %v", w.Code)
+	}
+	w = httptest.NewRecorder()
+	testHandlers.LoginHandler(w, httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username": "totpuser", "password": "password123"}`)))
+	if len(w.Result().Cookies()) == 0 {
+		t.Error("Expected a session after disabling two-factor authentication")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	testHandlers.SignupHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/signup", strings.NewReader(`{"username": "lockuser", "password": "password123"}`)))
+
+	login := func(password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		testHandlers.LoginHandler(w, httptest.NewRequest("POST", "/api/login", strings.NewReader(fmt.Sprintf(`{"username": "lockuser", "password": %q}`, password))))
+		return w
+	}
+
+	// A successful login resets the count
+	for range maxFailedLogins - 1 {
+		login("wrong")
+	}
+	if w := login("password123"); w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", w.Code)
+	}
+
+	for i := range maxFailedLogins {
+		if w := login("wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status Unauthorized for failure %d, got %v", i+1, w.Code)
+		}
+	}
+	if w := login("password123"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a locked account to refuse the right password, got %v", w.Code)
+	}
+
+	// A locked account answers exactly like a username that doesn't exist
+	unknown := httptest.NewRecorder()
+	testHandlers.LoginHandler(unknown, httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username": "nosuchuser", "password": "wrong"}`)))
+	locked := login("wrong")
+	if locked.Code != unknown.Code || locked.Body.String() != unknown.Body.String() || locked.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected a locked account to look like an unknown one, got %v %q and %v %q",
+			locked.Code, locked.Body.String(), unknown.Code, unknown.Body.String())
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := oidctest.NewServer("tracky", "secret")
+	defer idp.Close()
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       idp.URL,
+		ClientID:     "tracky",
+		ClientSecret: "secret",
+		RedirectURL:  "http://tracky.test/api/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testHandlers.OIDC = provider
+	defer func() { testHandlers.OIDC = nil }()
+
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	// Runs the login through the provider and returns the callback's response
+	ssoLogin := func(tamper func(callback string) string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		testHandlers.OIDCLoginHandler(w, httptest.NewRequest("GET", "/api/oidc/login", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("Expected a redirect to the provider, got %v", w.Code)
+		}
+		loginCookie := w.Result().Cookies()[0]
+
+		resp, err := noRedirects.Get(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		callback := resp.Header.Get("Location")
+		if tamper != nil {
+			callback = tamper(callback)
+		}
+
+		req := httptest.NewRequest("GET", callback, nil)
+		req.AddCookie(loginCookie)
+		w = httptest.NewRecorder()
+		testHandlers.OIDCCallbackHandler(w, req)
+		return w
+	}
+
+	// The first login creates a user named after the identity, with a notebook
+	idp.Subject = "oidc-sub-1"
+	idp.PreferredUsername = "ssouser"
+	w := ssoLogin(nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/" {
+		t.Fatalf("Expected a redirect to the app, got %v: %s", w.Code, w.Body.String())
+	}
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == auth.SessionCookieName {
+			session = c
+		}
+	}
+	if session == nil || session.Value == "" {
+		t.Fatal("Expected a session cookie")
+	}
+	userID, err := testHandlers.Store.GetUserID("ssouser")
+	if err != nil {
+		t.Fatalf("Expected the user to be created: %v", err)
+	}
+	if notebooks, _ := testHandlers.Store.GetNotebooks(userID); len(notebooks) != 1 {
+		t.Errorf("Expected a default notebook, got %d notebooks", len(notebooks))
+	}
+
+	// The same identity signs in to the same user; a new one with a taken
+	// name gets a numbered username
+	ssoLogin(nil)
+	if notebooks, _ := testHandlers.Store.GetNotebooks(userID); len(notebooks) != 1 {
+		t.Errorf("Expected the existing user to be reused, got %d notebooks", len(notebooks))
+	}
+	idp.Subject = "oidc-sub-2"
+	if w := ssoLogin(nil); w.Code != http.StatusFound {
+		t.Fatalf("Expected status Found, got %v", w.Code)
+	}
+	if _, err := testHandlers.Store.GetUserID("ssouser-2"); err != nil {
+		t.Errorf("Expected a numbered username: %v", err)
+	}
+
+	// SSO users have no password to log in with
+	w = httptest.NewRecorder()
+	testHandlers.LoginHandler(w, httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username": "ssouser", "password": ""}`)))
+	if w.Code == http.StatusOK {
+		t.Error("Expected a password login to fail for an SSO user")
+	}
+
+	// They set their first password without a current one, and need it to
+	// delete their account
+	account := func(method, body string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		testHandlers.AccountHandler(w, requestWithUserID(httptest.NewRequest(method, "/api/account", strings.NewReader(body)), userID))
+		return w.Code
+	}
+	if code := account("DELETE", `{"password": ""}`); code != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden deleting without a password, got %v", code)
+	}
+	if code := account("PUT", `{"new_password": "first-password"}`); code != http.StatusOK {
+		t.Fatalf("Expected status OK setting a first password, got %v", code)
+	}
+	if code := account("PUT", `{"new_password": "second-password"}`); code != http.StatusForbidden {
+		t.Errorf("Expected the current password to be required once set, got %v", code)
+	}
+	w = httptest.NewRecorder()
+	testHandlers.LoginHandler(w, httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username": "ssouser", "password": "first-password"}`)))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the new password to work, got %v", w.Code)
+	}
+
+	// SSO users with two-factor authentication enter their code before
+	// getting a session
+	secondID, _ := testHandlers.Store.GetUserID("ssouser-2")
+	totp := func(method, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		testHandlers.TOTPHandler(w, requestWithUserID(httptest.NewRequest(method, "/api/account/totp", strings.NewReader(body)), secondID))
+		return w
+	}
+	var setup map[string]string
+	json.NewDecoder(totp("POST", "").Body).Decode(&setup)
+	code, _ := auth.GenerateTOTP(setup["secret"], time.Now())
+	w = totp("PUT", fmt.Sprintf(`{"code": %q}`, code))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK enrolling, got %v", w.Code)
+	}
+	var recovery map[string][]string
+	json.NewDecoder(w.Body).Decode(&recovery)
+	idp.Subject = "oidc-sub-2"
+	w = ssoLogin(nil)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == auth.SessionCookieName && c.Value != "" {
+			t.Fatal("Expected no session before the second factor")
+		}
+	}
+	location := w.Header().Get("Location")
+	challenge, ok := strings.CutPrefix(location, "/#two_factor=")
+	if w.Code != http.StatusFound || !ok {
+		t.Fatalf("Expected a redirect to the second factor, got %v to %q", w.Code, location)
+	}
+	next, _ := auth.GenerateTOTP(setup["secret"], time.Now().Add(30*time.Second))
+	w = httptest.NewRecorder()
+	testHandlers.LoginTOTPHandler(w, httptest.NewRequest("POST", "/api/login/totp", strings.NewReader(fmt.Sprintf(`{"challenge": %q, "code": %q}`, challenge, next))))
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) == 0 {
+		t.Errorf("Expected a session after the second factor, got %v", w.Code)
+	}
+
+	// Without a password, the second factor alone turns it off
+	if w := totp("DELETE", `{}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden disabling without a code, got %v", w.Code)
+	}
+	if w := totp("DELETE", fmt.Sprintf(`{"recovery_code": %q}`, recovery["recovery_codes"][0])); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK disabling with a recovery code, got %v", w.Code)
+	}
+
+	// A callback whose state doesn't match the login is rejected
+	w = ssoLogin(func(callback string) string { return strings.Replace(callback, "state=", "state=x", 1) })
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request for a mismatched state, got %v", w.Code)
+	}
+}
+
+func TestNotebookSharing(t *testing.T) {
+	users := make(map[string]int)
+	for _, name := range []string{"shareowner", "shareeditor", "shareviewer", "shareoutsider"} {
+		testHandlers.Store.CreateUser(name, "hash")
+		users[name], _ = testHandlers.Store.GetUserID(name)
+	}
+	owner, editor, viewer, outsider := users["shareowner"], users["shareeditor"], users["shareviewer"], users["shareoutsider"]
+	notebookID, _ := testHandlers.Store.CreateNotebook(owner, "Project log")
+	noteID, _ := testHandlers.Store.CreateNote(owner, int(notebookID), "Kickoff")
+	imageID, _ := testHandlers.Store.CreateNoteImage(int(noteID), "shared.gif")
+	testHandlers.Blobs.Put(context.Background(), "shared.gif", strings.NewReader("GIF89a"), 6, "image/gif")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/notebooks", testHandlers.NotebooksHandler)
+	mux.HandleFunc("/api/notebooks/{id}/members", testHandlers.NotebookMembersHandler)
+	mux.HandleFunc("/api/notebooks/{id}/members/{user_id}", testHandlers.NotebookMemberHandler)
+	mux.HandleFunc("/api/notes", testHandlers.NotesHandler)
+	mux.HandleFunc("/api/trash", testHandlers.TrashHandler)
+	mux.HandleFunc("/api/notes/{id}/revisions/{revision_id}/restore", testHandlers.RestoreRevisionHandler)
+	mux.HandleFunc("/uploads/", testHandlers.ServeImageHandler)
+	do := func(userID int, method, url, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := requestWithUserID(httptest.NewRequest(method, url, strings.NewReader(body)), userID)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	membersURL := fmt.Sprintf("/api/notebooks/%d/members", notebookID)
+	notesURL := fmt.Sprintf("/api/notes?notebook_id=%d", notebookID)
+	noteURL := fmt.Sprintf("/api/notes?id=%d", noteID)
+	imageURL := fmt.Sprintf("/uploads/%d", imageID)
+	revisions, _ := testHandlers.Store.GetNoteRevisions(int(noteID), owner)
+	restoreURL := fmt.Sprintf("/api/notes/%d/revisions/%d/restore", noteID, revisions[0].ID)
+
+	// Only the owner can share, and only as editor or viewer
+	if w := do(owner, "POST", membersURL, `{"username": "shareeditor", "role": "owner"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request sharing as owner, got %v", w.Code)
+	}
+	if w := do(owner, "POST", membersURL, `{"username": "nobody", "role": "viewer"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found for an unknown user, got %v", w.Code)
+	}
+	for _, invite := range []string{`{"username": "shareeditor", "role": "editor"}`, `{"username": "shareviewer", "role": "viewer"}`} {
+		if w := do(owner, "POST", membersURL, invite); w.Code != http.StatusOK {
+			t.Fatalf("Expected status OK sharing, got %v: %s", w.Code, w.Body.String())
+		}
+	}
+	if w := do(editor, "POST", membersURL, `{"username": "shareoutsider", "role": "viewer"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden for an editor sharing, got %v", w.Code)
+	}
+
+	var members []models.NotebookMember
+	json.NewDecoder(do(viewer, "GET", membersURL, "").Body).Decode(&members)
+	if len(members) != 3 || members[0].Username != "shareowner" || members[0].Role != models.RoleOwner {
+		t.Errorf("Expected the owner and two members, got %+v", members)
+	}
+	var notebooks []models.Notebook
+	json.NewDecoder(do(editor, "GET", "/api/notebooks", "").Body).Decode(&notebooks)
+	if len(notebooks) != 1 || notebooks[0].ID != int(notebookID) || notebooks[0].Role != models.RoleEditor || notebooks[0].UserID != owner {
+		t.Errorf("Expected the shared notebook in the editor's list, got %+v", notebooks)
+	}
+
+	// Every member reads; owners and editors write; outsiders see nothing
+	tests := []struct {
+		name   string
+		userID int
+		method string
+		url    string
+		body   string
+		want   int
+	}{
+		{"viewer reads notes", viewer, "GET", notesURL, "", http.StatusOK},
+		{"viewer reads image", viewer, "GET", imageURL, "", http.StatusOK},
+		{"viewer adds note", viewer, "POST", notesURL, `{"content": "Nope"}`, http.StatusForbidden},
+		{"viewer edits note", viewer, "PUT", noteURL, `{"content": "Nope"}`, http.StatusForbidden},
+		{"viewer deletes note", viewer, "DELETE", noteURL, "", http.StatusForbidden},
+		{"viewer restores revision", viewer, "POST", restoreURL, "", http.StatusForbidden},
+		{"editor restores unknown revision", editor, "POST", fmt.Sprintf("/api/notes/%d/revisions/0/restore", noteID), "", http.StatusNotFound},
+		{"editor adds note", editor, "POST", notesURL, `{"content": "Progress"}`, http.StatusCreated},
+		{"editor edits owner's note", editor, "PUT", noteURL, `{"content": "Kickoff, edited"}`, http.StatusOK},
+		{"outsider reads notes", outsider, "GET", notesURL, "", http.StatusNotFound},
+		{"outsider reads image", outsider, "GET", imageURL, "", http.StatusNotFound},
+		{"outsider adds note", outsider, "POST", notesURL, `{"content": "Nope"}`, http.StatusNotFound},
+		{"outsider edits note", outsider, "PUT", noteURL, `{"content": "Nope"}`, http.StatusNotFound},
+		{"outsider restores revision", outsider, "POST", restoreURL, "", http.StatusNotFound},
+		{"outsider lists members", outsider, "GET", membersURL, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := do(tt.userID, tt.method, tt.url, tt.body); w.Code != tt.want {
+			t.Errorf("%s: expected status %v, got %v", tt.name, tt.want, w.Code)
+		}
+	}
+
+	var notes []models.Note
+	json.NewDecoder(do(viewer, "GET", notesURL, "").Body).Decode(&notes)
+	if len(notes) != 2 || notes[0].UserID != editor || notes[1].Content != "Kickoff, edited" {
+		t.Errorf("Expected both members' notes, got %+v", notes)
+	}
+
+	// A note trashed by an editor is in the trash of everyone who can write
+	// to the notebook, but only the owner can restore the notebook itself
+	trashHas := func(userID int, itemType string, id int) bool {
+		t.Helper()
+		var items []models.TrashItem
+		json.NewDecoder(do(userID, "GET", "/api/trash", "").Body).Decode(&items)
+		for _, item := range items {
+			if item.Type == itemType && item.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	restoreNote := fmt.Sprintf(`{"type": "note", "id": %d}`, noteID)
+	if w := do(editor, "DELETE", noteURL, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK for the editor deleting a note, got %v", w.Code)
+	}
+	if !trashHas(editor, "note", int(noteID)) || !trashHas(owner, "note", int(noteID)) || trashHas(viewer, "note", int(noteID)) {
+		t.Error("Expected the trashed note in the owner's and editor's trash only")
+	}
+	if w := do(viewer, "POST", "/api/trash", restoreNote); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found for a viewer restoring, got %v", w.Code)
+	}
+	if w := do(owner, "DELETE", fmt.Sprintf("/api/notebooks?id=%d", notebookID), ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK deleting the notebook, got %v", w.Code)
+	}
+	if w := do(editor, "POST", "/api/trash", restoreNote); w.Code != http.StatusConflict {
+		t.Errorf("Expected status Conflict for the editor restoring into a trashed notebook, got %v", w.Code)
+	}
+	if !trashHas(owner, "notebook", int(notebookID)) {
+		t.Error("Expected the notebook to stay in the trash")
+	}
+	if w := do(owner, "POST", "/api/trash", restoreNote); w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK for the owner restoring, got %v", w.Code)
+	}
+	json.NewDecoder(do(viewer, "GET", notesURL, "").Body).Decode(&notes)
+	if len(notes) != 2 {
+		t.Errorf("Expected the restored note and notebook to be readable, got %+v", notes)
+	}
+
+	// Members can leave; the owner can't
+	if w := do(viewer, "DELETE", fmt.Sprintf("%s/%d", membersURL, viewer), ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK leaving, got %v", w.Code)
+	}
+	if w := do(viewer, "GET", notesURL, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found after leaving, got %v", w.Code)
+	}
+	if w := do(owner, "DELETE", fmt.Sprintf("%s/%d", membersURL, owner), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request for the owner leaving, got %v", w.Code)
+	}
+
+	// Deleting the owner's account deletes the notebook for everyone
+	if _, err := testHandlers.Store.DeleteUser(owner); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testHandlers.Store.GetNotebookRole(int(notebookID), editor); err == nil {
+		t.Error("Expected the editor to lose the deleted owner's notebook")
+	}
+}
+
+func TestShareLinks(t *testing.T) {
+	testHandlers.Store.CreateUser("linkuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("linkuser")
+	notebookID, _ := testHandlers.Store.CreateNotebook(userID, "Recipes")
+	noteID, _ := testHandlers.Store.CreateNote(userID, int(notebookID), "<b>Bread</b> & butter")
+	otherNoteID, _ := testHandlers.Store.CreateNote(userID, int(notebookID), "Soup")
+	imageID, _ := testHandlers.Store.CreateNoteImage(int(noteID), "bread.gif")
+	otherImageID, _ := testHandlers.Store.CreateNoteImage(int(otherNoteID), "soup.gif")
+	for _, name := range []string{"bread.gif", "soup.gif"} {
+		testHandlers.Blobs.Put(context.Background(), name, strings.NewReader("GIF89a"), 6, "image/gif")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/shares", testHandlers.ShareLinksHandler)
+	mux.HandleFunc("/api/shares/{id}", testHandlers.ShareLinkHandler)
+	mux.HandleFunc("/s/{token}", testHandlers.PublicShareHandler)
+	mux.HandleFunc("/s/{token}/images/{id}", testHandlers.PublicShareImageHandler)
+	do := func(userID int, method, url, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if userID != 0 {
+			req = requestWithUserID(req, userID)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	share := func(body string) models.ShareLink {
+		t.Helper()
+		w := do(userID, "POST", "/api/shares", body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status Created, got %v: %s", w.Code, w.Body.String())
+		}
+		var link models.ShareLink
+		json.NewDecoder(w.Body).Decode(&link)
+		return link
+	}
+
+	if w := do(userID, "POST", "/api/shares", fmt.Sprintf(`{"notebook_id": %d, "note_id": %d}`, notebookID, noteID)); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request for two targets, got %v", w.Code)
+	}
+	if w := do(userID+1000, "POST", "/api/shares", fmt.Sprintf(`{"notebook_id": %d}`, notebookID)); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found sharing another user's notebook, got %v", w.Code)
+	}
+
+	// A note link shows that note and its images to anyone, escaped
+	noteLink := share(fmt.Sprintf(`{"note_id": %d}`, noteID))
+	if noteLink.Token == "" || noteLink.URL != "/s/"+noteLink.Token {
+		t.Fatalf("Expected a token and URL, got %+v", noteLink)
+	}
+	w := do(0, "GET", noteLink.URL, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", w.Code)
+	}
+	page := w.Body.String()
+	if !strings.Contains(page, "&lt;b&gt;Bread&lt;/b&gt; &amp; butter") || strings.Contains(page, "Soup") || !strings.Contains(page, "Recipes") {
+		t.Errorf("Expected only the shared note, escaped, got %s", page)
+	}
+	if w.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Error("Expected the token to be kept out of Referer headers")
+	}
+	if w := do(0, "GET", fmt.Sprintf("%s/images/%d", noteLink.URL, imageID), ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK for the note's image, got %v", w.Code)
+	}
+	if w := do(0, "GET", fmt.Sprintf("%s/images/%d", noteLink.URL, otherImageID), ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found for an image outside the link, got %v", w.Code)
+	}
+
+	// A notebook link shows every note
+	notebookLink := share(fmt.Sprintf(`{"notebook_id": %d, "expires_in_days": 7}`, notebookID))
+	if notebookLink.ExpiresAt == nil {
+		t.Error("Expected an expiry")
+	}
+	if page := do(0, "GET", notebookLink.URL, "").Body.String(); !strings.Contains(page, "Bread") || !strings.Contains(page, "Soup") {
+		t.Errorf("Expected every note in the notebook, got %s", page)
+	}
+
+	var links []models.ShareLink
+	json.NewDecoder(do(userID, "GET", "/api/shares", "").Body).Decode(&links)
+	if len(links) != 2 || links[0].Token != "" {
+		t.Errorf("Expected two links without tokens, got %+v", links)
+	}
+
+	// Expired, revoked and trashed links stop working
+	expired := time.Now().Add(-time.Hour)
+	testHandlers.Store.CreateShareLink(userID, models.ShareLink{NotebookID: int(notebookID), ExpiresAt: &expired}, auth.HashToken("expired-token"))
+	if w := do(0, "GET", "/s/expired-token", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found for an expired link, got %v", w.Code)
+	}
+	if w := do(userID, "DELETE", fmt.Sprintf("/api/shares/%d", notebookLink.ID), ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status OK revoking, got %v", w.Code)
+	}
+	if w := do(0, "GET", notebookLink.URL, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found for a revoked link, got %v", w.Code)
+	}
+	testHandlers.Store.DeleteNote(int(noteID), userID)
+	if w := do(0, "GET", noteLink.URL, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status Not Found for a trashed note, got %v", w.Code)
+	}
+}
+
+func TestMarkdownExportImport(t *testing.T) {
+	testHandlers.Store.CreateUser("markdownuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("markdownuser")
+	notebookID, _ := testHandlers.Store.CreateNotebook(userID, "Garden")
+	planted := time.Date(2023, 4, 1, 8, 30, 0, 0, time.Local)
+	noteID, _ := testHandlers.Store.CreateNoteAt(userID, int(notebookID), "Planted #tomatoes\n\n---\n\nRow two", planted)
+	testHandlers.Store.CreateNoteAt(userID, int(notebookID), "Watered", planted.Add(24*time.Hour))
+	testHandlers.Store.CreateNoteImage(int(noteID), "tomatoes.gif")
+	testHandlers.Blobs.Put(context.Background(), "tomatoes.gif", strings.NewReader("GIF89a"), 6, "image/gif")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/notebooks/import", testHandlers.NotebookImportHandler)
+	mux.HandleFunc("/api/notebooks/{id}/export", testHandlers.NotebookExportHandler)
+	export := func(userID int, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/notebooks/%d/export%s", notebookID, query), nil)
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := export(userID, "?format=json"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest for an unsupported format, got %v", w.Code)
+	}
+	if w := export(userID+1000, "?format=markdown"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound for another user's notebook, got %v", w.Code)
+	}
+	w := export(userID, "?format=markdown")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK exporting, got %v: %s", w.Code, w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=Garden.zip` {
+		t.Errorf("Unexpected Content-Disposition %q", cd)
+	}
+	archive := w.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("Export isn't a zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	noteFile := fmt.Sprintf("2023-04-01-083000-%d.md", noteID)
+	if !slices.Contains(names, noteFile) || !slices.Contains(names, "images/tomatoes.gif") || len(names) != 3 {
+		t.Fatalf("Unexpected files in export: %v", names)
+	}
+
+	// Importing the export recreates the notes with their original times
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "Garden copy.zip")
+	part.Write(archive)
+	mw.Close()
+	req := httptest.NewRequest("POST", "/api/notebooks/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req = requestWithUserID(req, userID)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created importing, got %v: %s", w.Code, w.Body.String())
+	}
+	var imported struct {
+		ID    int `json:"id"`
+		Notes int `json:"notes"`
+	}
+	json.NewDecoder(w.Body).Decode(&imported)
+	if imported.Notes != 2 {
+		t.Errorf("Expected 2 imported notes, got %d", imported.Notes)
+	}
+
+	notebooks, _ := testHandlers.Store.GetNotebooks(userID)
+	if !slices.ContainsFunc(notebooks, func(nb models.Notebook) bool { return nb.ID == imported.ID && nb.Name == "Garden copy" }) {
+		t.Errorf("Expected an imported notebook named after the file, got %+v", notebooks)
+	}
+	notes, _ := testHandlers.Store.GetNotes(userID, imported.ID)
+	testHandlers.attachNoteDetails(notes)
+	if len(notes) != 2 {
+		t.Fatalf("Expected 2 notes in the imported notebook, got %d", len(notes))
+	}
+	first := notes[1] // Newest first
+	if !first.CreatedAt.Equal(planted) || first.Content != "Planted #tomatoes\n\n---\n\nRow two" {
+		t.Errorf("Imported note doesn't match: %+v", first)
+	}
+	if !slices.Equal(first.Tags, []string{"tomatoes"}) {
+		t.Errorf("Expected tag tomatoes, got %v", first.Tags)
+	}
+	if len(first.Images) != 1 {
+		t.Fatalf("Expected 1 imported image, got %d", len(first.Images))
+	}
+	blob, _, err := testHandlers.Blobs.Get(context.Background(), first.Images[0].Filename)
+	if err != nil {
+		t.Fatalf("Expected imported image in blob store: %v", err)
+	}
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	if string(data) != "GIF89a" {
+		t.Errorf("Imported image doesn't match export")
+	}
+}
+
+func TestImports(t *testing.T) {
+	testHandlers.Store.CreateUser("importuser", "hash")
+	userID, _ := testHandlers.Store.GetUserID("importuser")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/imports", testHandlers.ImportsHandler)
+	mux.HandleFunc("/api/imports/{id}", testHandlers.ImportHandler)
+	start := func(format, filename string, data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("format", format)
+		part, _ := mw.CreateFormFile("file", filename)
+		part.Write(data)
+		mw.Close()
+		req := httptest.NewRequest("POST", "/api/imports", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req = requestWithUserID(req, userID)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	wait := func(w *httptest.ResponseRecorder) models.ImportJob {
+		t.Helper()
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected status Accepted, got %v: %s", w.Code, w.Body.String())
+		}
+		var job models.ImportJob
+		json.NewDecoder(w.Body).Decode(&job)
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			req := requestWithUserID(httptest.NewRequest("GET", fmt.Sprintf("/api/imports/%d", job.ID), nil), userID)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			json.NewDecoder(w.Body).Decode(&job)
+			if job.Status != models.ImportRunning {
+				return job
+			}
+		}
+		t.Fatalf("Import %d didn't finish", job.ID)
+		return job
+	}
+
+	if w := start("onenote", "notes.one", []byte("x")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest for an unsupported format, got %v", w.Code)
+	}
+
+	enex := `<?xml version="1.0" encoding="UTF-8"?>
+<en-export>
+  <note><title>Groceries</title><content><![CDATA[<en-note><div>Milk</div></en-note>]]></content><created>20220101T090000Z</created><tag>shopping</tag>
+    <resource><data encoding="base64">R0lGODlh</data><mime>image/gif</mime></resource>
+    <resource><data encoding="base64">JVBERg==</data><mime>application/pdf</mime></resource>
+  </note>
+</en-export>`
+	job := wait(start("enex", "Evernote.enex", []byte(enex)))
+	if job.Status != models.ImportDone || job.Total != 1 || job.Imported != 1 || len(job.NotebookIDs) != 1 {
+		t.Fatalf("Unexpected ENEX import %+v", job)
+	}
+	if len(job.Skipped) != 1 || job.Skipped[0].Reason != "Unsupported attachment type application/pdf" {
+		t.Errorf("Expected the PDF to be skipped, got %+v", job.Skipped)
+	}
+	notes, _ := testHandlers.Store.GetNotes(userID, job.NotebookIDs[0])
+	testHandlers.attachNoteDetails(notes)
+	if len(notes) != 1 || notes[0].Content != "# Groceries\n\nMilk\n\n#shopping" || len(notes[0].Images) != 1 {
+		t.Fatalf("Unexpected imported notes %+v", notes)
+	}
+	if !notes[0].CreatedAt.Equal(time.Date(2022, 1, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the original creation time, got %v", notes[0].CreatedAt)
+	}
+
+	var vault bytes.Buffer
+	zw := zip.NewWriter(&vault)
+	for name, content := range map[string]string{
+		"Index.md":       "Start at [[Work/Plan]]",
+		"Work/Plan.md":   "Do things",
+		"Work/Budget.md": "Spend less",
+	} {
+		f, _ := zw.Create(name)
+		f.Write([]byte(content))
+	}
+	zw.Close()
+	job = wait(start("obsidian", "Brain.zip", vault.Bytes()))
+	if job.Status != models.ImportDone || job.Imported != 3 || len(job.NotebookIDs) != 2 {
+		t.Fatalf("Unexpected Obsidian import %+v", job)
+	}
+	notebooks, _ := testHandlers.Store.GetNotebooks(userID)
+	var names []string
+	for _, nb := range notebooks {
+		if slices.Contains(job.NotebookIDs, nb.ID) {
+			names = append(names, nb.Name)
+		}
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"Brain", "Work"}) {
+		t.Errorf("Expected notebooks Brain and Work, got %v", names)
+	}
+	notes, _ = testHandlers.Store.GetNotes(userID, job.NotebookIDs[0])
+	if len(notes) != 1 || notes[0].Content != "# Index\n\nStart at [[Work/Plan]]" {
+		t.Errorf("Expected the wiki-link to be kept, got %+v", notes)
+	}
+
+	job = wait(start("obsidian", "broken.zip", []byte("not a zip")))
+	if job.Status != models.ImportFailed || job.Error != "invalid zip file" {
+		t.Errorf("Expected a failed import, got %+v", job)
+	}
+
+	req := requestWithUserID(httptest.NewRequest("GET", fmt.Sprintf("/api/imports/%d", job.ID), nil), userID+1000)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound for another user's import, got %v", w.Code)
+	}
+}
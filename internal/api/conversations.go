@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"tracky/internal/auth"
+	"tracky/internal/models"
+)
+
+// ConversationsHandler lists a notebook's saved analysis conversations, most
+// recently active first, or starts a new one.
+// Routes: GET /api/conversations?notebook_id=, POST /api/conversations
+func (h *Handlers) ConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		notebookID, err := strconv.Atoi(r.URL.Query().Get("notebook_id"))
+		if err != nil {
+			http.Error(w, "Invalid notebook ID", http.StatusBadRequest)
+			return
+		}
+		conversations, err := h.Store.GetConversations(userID, notebookID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if conversations == nil {
+			conversations = []models.Conversation{}
+		}
+		json.NewEncoder(w).Encode(conversations)
+
+	case http.MethodPost:
+		var c models.Conversation
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		title := strings.TrimSpace(c.Title)
+		if title == "" {
+			title = "New conversation"
+		}
+		id, err := h.Store.CreateConversation(userID, []int{c.NotebookID}, title)
+		if err != nil {
+			http.Error(w, "Notebook not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int64{"id": id})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ConversationHandler resumes (GET, with messages), renames (PUT with
+// {"title": ...}) or permanently deletes a conversation.
+// Route: /api/conversations/{id}
+func (h *Handlers) ConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		conversation, err := h.Store.GetConversation(conversationID, userID)
+		if err != nil {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		if conversation.Messages == nil {
+			conversation.Messages = []models.ChatMessage{}
+		}
+		json.NewEncoder(w).Encode(conversation)
+
+	case http.MethodPut:
+		var c models.Conversation
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		title := strings.TrimSpace(c.Title)
+		if title == "" {
+			http.Error(w, "Title is required", http.StatusBadRequest)
+			return
+		}
+		if err := h.Store.RenameConversation(conversationID, userID, title); err != nil {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		if err := h.Store.DeleteConversation(conversationID, userID); err != nil {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tracky/internal/auth"
+	"tracky/internal/models"
+)
+
+// digestNotebookName is the notebook generated digests are saved to
+const digestNotebookName = "Digests"
+
+const defaultDigestPrompt = "Summarize what I wrote about during this period: the main themes, notable events, and anything I said I'd follow up on."
+
+// A failed digest is retried after digestRetryDelay, doubling each time, and
+// given up on until its next period after maxDigestAttempts runs
+const (
+	digestRetryDelay  = time.Hour
+	maxDigestAttempts = 4
+)
+
+// digestRanges maps each cadence to the relative range its digest covers
+var digestRanges = map[string]string{
+	models.DigestWeekly:  "last_week",
+	models.DigestMonthly: "last_month",
+}
+
+// DigestsHandler lists the user's digest schedules or adds one with
+// {"cadence": "weekly"|"monthly", "prompt": ..., "notebook_ids": [...]}, or
+// "all_notebooks": true instead of notebook_ids.
+// Routes: GET, POST /api/digests
+func (h *Handlers) DigestsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		digests, err := h.Store.GetDigests(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if digests == nil {
+			digests = []models.Digest{}
+		}
+		json.NewEncoder(w).Encode(digests)
+
+	case http.MethodPost:
+		d, ok := h.decodeDigest(w, r, userID)
+		if !ok {
+			return
+		}
+		id, err := h.Store.CreateDigest(userID, d)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int64{"id": id})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DigestHandler replaces (PUT, same body as creating) or deletes a digest
+// schedule. Route: /api/digests/{id}
+func (h *Handlers) DigestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	digestID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid digest ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		d, ok := h.decodeDigest(w, r, userID)
+		if !ok {
+			return
+		}
+		d.ID = digestID
+		err = h.Store.UpdateDigest(userID, d)
+
+	case http.MethodDelete:
+		err = h.Store.DeleteDigest(digestID, userID)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Digest not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// decodeDigest reads and validates digest settings, writing an error
+// response and returning false if they're invalid
+func (h *Handlers) decodeDigest(w http.ResponseWriter, r *http.Request, userID int) (models.Digest, bool) {
+	var d models.Digest
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return d, false
+	}
+	if _, ok := digestRanges[d.Cadence]; !ok {
+		http.Error(w, "Cadence must be weekly or monthly", http.StatusBadRequest)
+		return d, false
+	}
+	if d.AllNotebooks == (len(d.NotebookIDs) > 0) {
+		http.Error(w, "Set either notebook_ids or all_notebooks", http.StatusBadRequest)
+		return d, false
+	}
+
+	notebooks, err := h.Store.GetNotebooks(userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return d, false
+	}
+	owned := make(map[int]bool)
+	for _, nb := range notebooks {
+		owned[nb.ID] = nb.Role == models.RoleOwner
+	}
+	for _, id := range d.NotebookIDs {
+		if !owned[id] {
+			http.Error(w, fmt.Sprintf("Unknown notebook %d", id), http.StatusBadRequest)
+			return d, false
+		}
+	}
+	// Digests would otherwise summarize earlier digests
+	digestNotebookID, err := h.Store.GetNotebookByName(userID, digestNotebookName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return d, false
+	}
+	if digestNotebookID != 0 && slices.Contains(d.NotebookIDs, digestNotebookID) {
+		http.Error(w, fmt.Sprintf("Digests can't cover the %s notebook", digestNotebookName), http.StatusBadRequest)
+		return d, false
+	}
+
+	d.Prompt = strings.TrimSpace(d.Prompt)
+	if d.Prompt == "" {
+		d.Prompt = defaultDigestPrompt
+	}
+	return d, true
+}
+
+// RunDueDigests writes a digest note for every schedule that hasn't run
+// since its current period began, covering the previous week or month.
+// Each digest is claimed before it runs so that only one server writes it,
+// and a failed run is retried with backoff rather than on every call.
+func (h *Handlers) RunDueDigests(ctx context.Context, now time.Time) error {
+	for cadence, rangeName := range digestRanges {
+		start, end, err := parseTimeRange(rangeName, now)
+		if err != nil {
+			return err
+		}
+		periodStart := end.Add(time.Nanosecond)
+
+		digests, err := h.Store.GetDueDigests(cadence, periodStart, now)
+		if err != nil {
+			return err
+		}
+		for _, d := range digests {
+			claimed, err := h.Store.ClaimDigestRun(d.ID, periodStart, now)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				continue
+			}
+			if err := h.runDigest(ctx, d, analysisScope{start: start, end: end, location: now.Location()}); err != nil {
+				log.Printf("Digest %d failed: %v", d.ID, err)
+				if d.Failures+1 < maxDigestAttempts {
+					retryAt := now.Add(digestRetryDelay << d.Failures)
+					if err := h.Store.ReleaseDigestRun(d.ID, d.LastRunAt, retryAt); err != nil {
+						log.Printf("Failed to release digest %d: %v", d.ID, err)
+					}
+					continue
+				}
+				log.Printf("Giving up on digest %d until its next period", d.ID)
+			}
+			if d.Failures > 0 {
+				if err := h.Store.ResetDigestFailures(d.ID); err != nil {
+					log.Printf("Failed to reset digest %d: %v", d.ID, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// runDigest asks the model to summarize the digest's notes within scope's
+// time range and saves the answer to the user's Digests notebook, creating
+// it if needed. Nothing is saved if there were no notes, or if the digest's
+// notebooks have all been purged.
+func (h *Handlers) runDigest(ctx context.Context, d models.Digest, scope analysisScope) error {
+	digestNotebookID, err := h.Store.GetNotebookByName(d.UserID, digestNotebookName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if !d.AllNotebooks {
+		scope.notebookIDs = d.NotebookIDs
+	}
+	notebookNames := make(map[int]string)
+	notebooks, err := h.Store.GetNotebooks(d.UserID)
+	if err != nil {
+		return err
+	}
+	for _, nb := range notebooks {
+		notebookNames[nb.ID] = nb.Name
+		// Like the notebooks a digest may name, only ones the user owns
+		if d.AllNotebooks && nb.ID != digestNotebookID && nb.Role == models.RoleOwner {
+			scope.notebookIDs = append(scope.notebookIDs, nb.ID)
+		}
+	}
+	if len(scope.notebookIDs) == 0 {
+		log.Printf("Digest %d has no notebooks left to cover", d.ID)
+		return nil
+	}
+
+	notes, err := h.scopeNotes(d.UserID, scope)
+	if err != nil || len(notes) == 0 {
+		return err
+	}
+	if len(notes) > analysisNoteLimit {
+		if notes, err = h.relevantNotes(ctx, d.UserID, scope, d.Prompt, notes); err != nil {
+			return err
+		}
+	}
+	if len(scope.notebookIDs) == 1 {
+		notebookNames = nil
+	}
+
+	answer, err := h.LLM.Complete(ctx, analysisRequest(notes, scope, notebookNames, d.Prompt, nil))
+	if err != nil {
+		return err
+	}
+
+	if digestNotebookID == 0 {
+		id, err := h.Store.CreateNotebook(d.UserID, digestNotebookName)
+		if err != nil {
+			return err
+		}
+		digestNotebookID = int(id)
+	}
+
+	const dateFormat = "Mon, 02 Jan 2006"
+	title := "Weekly digest"
+	if d.Cadence == models.DigestMonthly {
+		title = "Monthly digest"
+	}
+	content := fmt.Sprintf("%s: %s – %s\n\n%s", title, scope.start.Format(dateFormat), scope.end.Format(dateFormat), answer)
+	if _, err := h.Store.CreateNote(d.UserID, digestNotebookID, content); err != nil {
+		return err
+	}
+	h.notifyIndexer()
+	return nil
+}
+
+// RunDigestScheduler calls RunDueDigests every interval until ctx is
+// cancelled
+func (h *Handlers) RunDigestScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := h.RunDueDigests(ctx, time.Now()); err != nil {
+			log.Printf("Running digests failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"tracky/internal/models"
+)
+
+// embeddingBatchSize is how many notes are sent to the embedding model at once
+const embeddingBatchSize = 32
+
+// embedRetryDelay is how long a note that couldn't be embedded is skipped
+// before the indexer tries it again, unless it's edited meanwhile
+const embedRetryDelay = time.Hour
+
+// embedFailure is a note the indexer couldn't embed
+type embedFailure struct {
+	content string // An edit gets the note retried straight away
+	retryAt time.Time
+}
+
+// IndexNotes embeds every note that lacks an up-to-date embedding. When a
+// batch fails, its notes are retried one at a time, and those that still
+// fail are skipped for embedRetryDelay so that one note the model rejects,
+// such as one over its token limit, doesn't hold up the rest. A complete
+// pass forgets failures of notes that no longer need embedding.
+func (h *Handlers) IndexNotes(ctx context.Context) error {
+	h.indexMu.Lock()
+	defer h.indexMu.Unlock()
+
+	model := h.Embedder.EmbeddingModel()
+	pending := make(map[int]bool)
+	afterID := 0
+	for {
+		notes, err := h.Store.GetNotesWithoutEmbedding(model, afterID, embeddingBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(notes) == 0 {
+			h.forgetEmbedFailures(pending)
+			return nil
+		}
+		afterID = notes[len(notes)-1].ID
+
+		var batch []models.Note
+		for _, n := range notes {
+			pending[n.ID] = true
+			if f, ok := h.embedFailures[n.ID]; ok && f.content == n.Content && time.Now().Before(f.retryAt) {
+				continue
+			}
+			batch = append(batch, n)
+		}
+		if err := h.embedNotes(ctx, model, batch); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failed := 0
+			for _, n := range batch {
+				if err := h.embedNotes(ctx, model, []models.Note{n}); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					log.Printf("Failed to embed note %d, skipping it for %v: %v", n.ID, embedRetryDelay, err)
+					h.embedFailures[n.ID] = embedFailure{content: n.Content, retryAt: time.Now().Add(embedRetryDelay)}
+					failed++
+				}
+			}
+			if failed == len(batch) {
+				// Likely the model is unavailable: try the rest next time
+				return err
+			}
+		}
+		if len(notes) < embeddingBatchSize {
+			h.forgetEmbedFailures(pending)
+			return nil
+		}
+	}
+}
+
+// forgetEmbedFailures drops the failures of notes that weren't pending in a
+// complete indexing pass, because they were deleted, purged or since embedded
+func (h *Handlers) forgetEmbedFailures(pending map[int]bool) {
+	for id := range h.embedFailures {
+		if !pending[id] {
+			delete(h.embedFailures, id)
+		}
+	}
+}
+
+// embedNotes embeds and saves a batch of notes
+func (h *Handlers) embedNotes(ctx context.Context, model string, notes []models.Note) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	texts := make([]string, len(notes))
+	for i, n := range notes {
+		texts[i] = n.Content
+	}
+	vectors, err := h.Embedder.Embed(ctx, texts)
+	if err != nil {
+		return err
+	}
+	for i, n := range notes {
+		if err := h.Store.SaveNoteEmbedding(n.ID, n.Content, model, vectors[i]); err != nil {
+			return err
+		}
+		delete(h.embedFailures, n.ID)
+	}
+	return nil
+}
+
+// RunEmbeddingIndexer calls IndexNotes whenever notes are created or edited,
+// and every interval to retry failures, until ctx is cancelled
+func (h *Handlers) RunEmbeddingIndexer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := h.IndexNotes(ctx); err != nil {
+			log.Printf("Embedding notes failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.indexNow:
+		}
+	}
+}
+
+// notifyIndexer wakes the embedding indexer without waiting for it
+func (h *Handlers) notifyIndexer() {
+	select {
+	case h.indexNow <- struct{}{}:
+	default:
+	}
+}
@@ -1,21 +1,29 @@
 package api
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
 	"io"
+	"mime"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"tracky/internal/auth"
+	"tracky/internal/blobstore"
+	"tracky/internal/importer"
+	"tracky/internal/llm"
 	"tracky/internal/models"
+	"tracky/internal/oidc"
 	"tracky/internal/store"
 
 	"golang.org/x/crypto/bcrypt"
@@ -25,6 +33,12 @@ import (
 const maxImageDimension = 1920 // Max width or height
 const jpegQuality = 85         // JPEG compression quality (1-100)
 
+// imageURLExpiry is how long presigned image URLs stay valid
+const imageURLExpiry = 15 * time.Minute
+
+const defaultNotesPageSize = 50
+const maxNotesPageSize = 500
+
 // compressImage resizes and compresses an image, returning the processed image data
 func compressImage(file io.Reader, ext string) (image.Image, string, error) {
 	var img image.Image
@@ -72,12 +86,27 @@ func compressImage(file io.Reader, ext string) (image.Image, string, error) {
 
 // Handlers holds dependencies for API handlers
 type Handlers struct {
-	Store store.Store
+	Store          store.Store
+	Blobs          blobstore.BlobStore // Where uploaded images are kept
+	LLM            llm.Provider        // Answers analysis questions; nil disables analysis
+	Embedder       llm.Embedder        // Embeds notes for semantic search; nil disables it
+	OIDC           *oidc.Provider      // Single sign-on; nil disables it
+	TrashRetention time.Duration       // How long deleted items stay restorable
+
+	indexNow      chan struct{}        // Wakes the embedding indexer after notes change
+	indexMu       sync.Mutex           // Held while indexing; guards embedFailures
+	embedFailures map[int]embedFailure // By note ID
 }
 
 // NewHandlers creates a new Handlers instance
 func NewHandlers(s store.Store) *Handlers {
-	return &Handlers{Store: s}
+	return &Handlers{
+		Store:          s,
+		Blobs:          blobstore.NewLocal("uploads"),
+		TrashRetention: defaultTrashRetention,
+		indexNow:       make(chan struct{}, 1),
+		embedFailures:  make(map[int]embedFailure),
+	}
 }
 
 func (h *Handlers) SignupHandler(w http.ResponseWriter, r *http.Request) {
@@ -123,30 +152,76 @@ func (h *Handlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Unknown usernames and locked accounts answer like a wrong password,
+	// after as long a bcrypt comparison, so that neither can be told apart
+	// from an existing account. The per-IP rate limit slows guessing down.
 	id, hash, err := h.Store.GetUserByUsername(u.Username)
 	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(u.Password))
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
-
+	lockedUntil, err := h.Store.GetLockedUntil(id)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(u.Password)); err != nil {
+		if lockedUntil.IsZero() {
+			if _, err := h.Store.RecordFailedLogin(id, maxFailedLogins, accountLockout); err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	// Locked accounts refuse even the right password until the lock expires
+	if !lockedUntil.IsZero() {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Ensure user has at least one notebook (for existing users)
-	notebooks, _ := h.Store.GetNotebooks(id)
-	if len(notebooks) == 0 {
-		h.Store.CreateDefaultNotebook(id)
+	// Accounts with two-factor authentication finish at LoginTOTPHandler
+	_, totpEnabled, err := h.Store.GetTOTP(id)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if totpEnabled {
+		h.startLoginChallenge(w, id)
+		return
 	}
 
-	// Set signed auth cookie
-	auth.SetAuthCookie(w, id)
+	h.Store.ResetFailedLogins(id)
+	h.ensureNotebook(id)
+	if err := h.startSession(w, r, id); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// ensureNotebook gives a user who has just logged in a notebook if they have
+// none (for existing users)
+func (h *Handlers) ensureNotebook(userID int) {
+	notebooks, _ := h.Store.GetNotebooks(userID)
+	if len(notebooks) == 0 {
+		h.Store.CreateDefaultNotebook(userID)
+	}
+}
+
+// LogoutHandler revokes the current session
 func (h *Handlers) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	if sessionID, ok := auth.GetSessionIDFromContext(r.Context()); ok {
+		h.Store.DeleteSession(sessionID, userID)
+	}
 	auth.ClearAuthCookie(w)
 	w.WriteHeader(http.StatusOK)
 }
@@ -211,38 +286,49 @@ func (h *Handlers) NotesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Any member can read a notebook's notes; owners and editors can change them
 	switch r.Method {
 	case http.MethodGet:
-		notes, err := h.Store.GetNotes(userID, notebookID)
+		role, err := h.Store.GetNotebookRole(notebookID, userID)
+		if !checkRole(w, role, err, false, "Notebook not found") {
+			return
+		}
+		// Paginate when asked to; otherwise return the whole notebook
+		if r.URL.Query().Has("limit") || r.URL.Query().Has("cursor") {
+			h.notesPage(w, r, userID, notebookID)
+			return
+		}
+		var notes []models.Note
+		if tag := r.URL.Query().Get("tag"); tag != "" {
+			notes, err = h.Store.GetNotesPage(userID, notebookID, models.NoteListOptions{Tag: tag})
+		} else {
+			notes, err = h.Store.GetNotes(userID, notebookID)
+		}
 		if err != nil {
 			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
 		}
-		// Get images for all notes
-		if len(notes) > 0 {
-			noteIDs := make([]int, len(notes))
-			for i, n := range notes {
-				noteIDs[i] = n.ID
-			}
-			imageMap, _ := h.Store.GetNoteImagesByNoteIDs(noteIDs)
-			for i := range notes {
-				notes[i].Images = imageMap[notes[i].ID]
-			}
-		}
+		h.attachNoteDetails(notes)
 		json.NewEncoder(w).Encode(notes)
 
 	case http.MethodPost:
+		role, err := h.Store.GetNotebookRole(notebookID, userID)
+		if !checkRole(w, role, err, true, "Notebook not found") {
+			return
+		}
 		var n models.Note
 		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
-		err := h.Store.CreateNote(userID, notebookID, n.Content)
+		id, err := h.Store.CreateNote(userID, notebookID, n.Content)
 		if err != nil {
 			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
 		}
+		h.notifyIndexer()
 		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int64{"id": id})
 
 	case http.MethodPut:
 		noteID, err := strconv.Atoi(r.URL.Query().Get("id"))
@@ -250,6 +336,10 @@ func (h *Handlers) NotesHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid note ID", http.StatusBadRequest)
 			return
 		}
+		role, err := h.Store.GetNoteRole(noteID, userID)
+		if !checkRole(w, role, err, true, "Note not found") {
+			return
+		}
 		var n models.Note
 		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -260,6 +350,7 @@ func (h *Handlers) NotesHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Note not found", http.StatusNotFound)
 			return
 		}
+		h.notifyIndexer()
 		w.WriteHeader(http.StatusOK)
 
 	case http.MethodDelete:
@@ -268,11 +359,11 @@ func (h *Handlers) NotesHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid note ID", http.StatusBadRequest)
 			return
 		}
-		// Delete associated images from filesystem
-		images, _ := h.Store.GetNoteImages(noteID)
-		for _, img := range images {
-			os.Remove(filepath.Join("uploads", img.Filename))
+		role, err := h.Store.GetNoteRole(noteID, userID)
+		if !checkRole(w, role, err, true, "Note not found") {
+			return
 		}
+		// Moves the note to the trash; its images are removed when it's purged
 		err = h.Store.DeleteNote(noteID, userID)
 		if err != nil {
 			http.Error(w, "Note not found", http.StatusNotFound)
@@ -285,6 +376,117 @@ func (h *Handlers) NotesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// notesPage writes one page of a notebook's notes, newest first.
+// Query params: limit (default 50), cursor (next_cursor from the previous
+// page) and tag.
+func (h *Handlers) notesPage(w http.ResponseWriter, r *http.Request, userID, notebookID int) {
+	limit := defaultNotesPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxNotesPageSize)
+	}
+
+	// Fetch one extra note to learn whether there is another page
+	opts := models.NoteListOptions{Limit: limit + 1, Tag: r.URL.Query().Get("tag")}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		opts.After = &c
+	}
+
+	notes, err := h.Store.GetNotesPage(userID, notebookID, opts)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	page := models.NotePage{Notes: notes}
+	if len(notes) > limit {
+		page.Notes = notes[:limit]
+		last := page.Notes[limit-1]
+		page.NextCursor = encodeCursor(models.NoteCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	if page.Notes == nil {
+		page.Notes = []models.Note{}
+	}
+	h.attachNoteDetails(page.Notes)
+	json.NewEncoder(w).Encode(page)
+}
+
+// attachNoteDetails fills in the images and tags of each note
+func (h *Handlers) attachNoteDetails(notes []models.Note) {
+	if len(notes) == 0 {
+		return
+	}
+	noteIDs := make([]int, len(notes))
+	for i, n := range notes {
+		noteIDs[i] = n.ID
+	}
+	imageMap, _ := h.Store.GetNoteImagesByNoteIDs(noteIDs)
+	tagMap, _ := h.Store.GetTagsByNoteIDs(noteIDs)
+	for i := range notes {
+		notes[i].Images = imageMap[notes[i].ID]
+		notes[i].Tags = tagMap[notes[i].ID]
+	}
+}
+
+// encodeCursor serializes a cursor as an opaque URL-safe token
+func encodeCursor(c models.NoteCursor) string {
+	raw := c.CreatedAt.Format(time.RFC3339Nano) + "|" + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(token string) (models.NoteCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return models.NoteCursor{}, err
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return models.NoteCursor{}, fmt.Errorf("malformed cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return models.NoteCursor{}, err
+	}
+	noteID, err := strconv.Atoi(id)
+	if err != nil {
+		return models.NoteCursor{}, err
+	}
+	return models.NoteCursor{CreatedAt: createdAt, ID: noteID}, nil
+}
+
+// TagsHandler lists the user's tags with note counts
+func (h *Handlers) TagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tags, err := h.Store.GetTags(userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if tags == nil {
+		tags = []models.Tag{}
+	}
+	json.NewEncoder(w).Encode(tags)
+}
+
 func (h *Handlers) ImagesHandler(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserIDFromContext(r.Context())
 	if !ok {
@@ -305,6 +507,10 @@ func (h *Handlers) ImagesHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid note ID", http.StatusBadRequest)
 			return
 		}
+		role, err := h.Store.GetNoteRole(noteID, userID)
+		if !checkRole(w, role, err, true, "Note not found") {
+			return
+		}
 
 		file, header, err := r.FormFile("image")
 		if err != nil {
@@ -315,17 +521,11 @@ func (h *Handlers) ImagesHandler(w http.ResponseWriter, r *http.Request) {
 
 		// Validate file extension
 		ext := strings.ToLower(filepath.Ext(header.Filename))
-		if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" && ext != ".webp" {
+		if !importer.ImageExtensions[ext] {
 			http.Error(w, "Invalid file type", http.StatusBadRequest)
 			return
 		}
 
-		// Create uploads directory if it doesn't exist
-		if err := os.MkdirAll("uploads", 0755); err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			return
-		}
-
 		// Try to compress the image
 		img, newExt, err := compressImage(file, ext)
 		if err != nil {
@@ -340,35 +540,32 @@ func (h *Handlers) ImagesHandler(w http.ResponseWriter, r *http.Request) {
 
 		// Generate unique filename
 		filename := fmt.Sprintf("%d_%d_%d%s", userID, noteID, time.Now().UnixNano(), ext)
-		fpath := filepath.Join("uploads", filename)
-
-		// Save file
-		dst, err := os.Create(fpath)
-		if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			return
-		}
-		defer dst.Close()
 
+		var body io.Reader
+		var size int64
 		if img != nil {
 			// Save compressed image as JPEG
-			if err := jpeg.Encode(dst, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
 				http.Error(w, "Server error", http.StatusInternalServerError)
 				return
 			}
+			body, size = &buf, int64(buf.Len())
 		} else {
 			// For unsupported formats (gif, webp), save as-is
-			file.Seek(0, 0) // Reset file position
-			if _, err := io.Copy(dst, file); err != nil {
-				http.Error(w, "Server error", http.StatusInternalServerError)
-				return
-			}
+			file.Seek(0, io.SeekStart) // Reset file position
+			body, size = file, header.Size
+		}
+
+		if err := h.Blobs.Put(r.Context(), filename, body, size, mime.TypeByExtension(ext)); err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
 		}
 
 		// Save to database
 		imageID, err := h.Store.CreateNoteImage(noteID, filename)
 		if err != nil {
-			os.Remove(fpath)
+			h.Blobs.Delete(r.Context(), filename)
 			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
 		}
@@ -385,23 +582,19 @@ func (h *Handlers) ImagesHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		filename, err := h.Store.DeleteNoteImage(imageID)
-		if err != nil {
+		// Moves the image to the trash; the file is removed when it's purged
+		if _, err := h.Store.DeleteNoteImage(imageID, userID); err != nil {
 			http.Error(w, "Image not found", http.StatusNotFound)
 			return
 		}
-
-		// Delete file from filesystem
-		os.Remove(filepath.Join("uploads", filename))
 		w.WriteHeader(http.StatusOK)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
-	_ = userID // Used for authorization context
 }
 
-// ServeImageHandler serves images with ownership check
+// ServeImageHandler serves images to members of their notebook
 func (h *Handlers) ServeImageHandler(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserIDFromContext(r.Context())
 	if !ok {
@@ -417,69 +610,43 @@ func (h *Handlers) ServeImageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check ownership and get filename
+	// Check membership and get filename
 	filename, err := h.Store.GetNoteImageWithOwner(imageID, userID)
 	if err != nil {
 		http.Error(w, "Image not found", http.StatusNotFound)
 		return
 	}
-
-	// Serve the file
-	http.ServeFile(w, r, filepath.Join("uploads", filename))
+	h.serveImage(w, r, filename)
 }
 
-// AnalysisHandler handles note analysis requests using Gemini
-func (h *Handlers) AnalysisHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// serveImage writes an image from blob storage, or redirects to it
+func (h *Handlers) serveImage(w http.ResponseWriter, r *http.Request, filename string) {
+	// Stores that can presign URLs serve the image directly
+	if signedURL, err := h.Blobs.SignedURL(r.Context(), filename, imageURLExpiry); err == nil {
+		http.Redirect(w, r, signedURL, http.StatusFound)
 		return
-	}
-
-	userID, ok := auth.GetUserIDFromContext(r.Context())
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	var req struct {
-		NotebookID int                  `json:"notebook_id"`
-		Question   string               `json:"question"`
-		History    []models.ChatMessage `json:"history"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	} else if !errors.Is(err, blobstore.ErrUnsupported) {
+		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
 
-	if req.Question == "" {
-		http.Error(w, "Question is required", http.StatusBadRequest)
+	blob, info, err := h.Blobs.Get(r.Context(), filename)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		http.Error(w, "Image not found", http.StatusNotFound)
 		return
 	}
-
-	// Fetch all notes from the notebook
-	notes, err := h.Store.GetNotes(userID, req.NotebookID)
 	if err != nil {
-		http.Error(w, "Failed to fetch notes", http.StatusInternalServerError)
+		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
+	defer blob.Close()
 
-	if len(notes) == 0 {
-		json.NewEncoder(w).Encode(map[string]string{
-			"answer": "There are no notes in this notebook to analyze.",
-		})
-		return
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
 	}
-
-	// Call Gemini API
-	// Call Gemini API
-	answer, err := AnalyzeNotes(notes, req.Question, req.History)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Analysis failed: %v", err), http.StatusInternalServerError)
+	if rs, ok := blob.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, filename, info.ModTime, rs)
 		return
 	}
-
-	json.NewEncoder(w).Encode(map[string]string{
-		"answer": answer,
-	})
+	io.Copy(w, blob)
 }
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"tracky/internal/auth"
+	"tracky/internal/importer"
+	"tracky/internal/models"
+)
+
+// importJobTimeout is how long a running import can go without progress
+// before it's reported as failed, as when the server restarted during it
+const importJobTimeout = 10 * time.Minute
+
+// ImportsHandler lists the user's imports (GET) or starts one (POST). The
+// export is uploaded as the multipart field "file", with "format" set to
+// "enex" for an Evernote export or "obsidian" for a zipped vault, and an
+// optional notebook "name", which defaults to the file's. The import runs in
+// the background; poll /api/imports/{id} for its progress.
+// Route: /api/imports
+func (h *Handlers) ImportsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		jobs, err := h.Store.GetImportJobs(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if jobs == nil {
+			jobs = []models.ImportJob{}
+		}
+		for i := range jobs {
+			checkImportJob(&jobs[i])
+		}
+		json.NewEncoder(w).Encode(jobs)
+
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, "File too large", http.StatusBadRequest)
+			return
+		}
+		format := r.FormValue("format")
+		if format != models.ImportENEX && format != models.ImportObsidian {
+			http.Error(w, "Format must be enex or obsidian", http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "No file provided", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))
+		}
+		if name == "" {
+			name = "Imported notebook"
+		}
+
+		// The upload is kept until the job has read it
+		tmp, err := os.CreateTemp("", "tracky-import-*")
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := io.Copy(tmp, file); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		tmp.Close()
+
+		id, err := h.Store.CreateImportJob(userID, format)
+		if err != nil {
+			os.Remove(tmp.Name())
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		job, err := h.Store.GetImportJob(int(id), userID)
+		if err != nil {
+			os.Remove(tmp.Name())
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		go h.runImport(context.Background(), job, tmp.Name(), name)
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ImportHandler reports an import's progress and, once it's done, what it
+// skipped.
+// Route: GET /api/imports/{id}
+func (h *Handlers) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	jobID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid import ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.Store.GetImportJob(jobID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Import not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	checkImportJob(&job)
+	json.NewEncoder(w).Encode(job)
+}
+
+// checkImportJob marks a running job that has stopped making progress as
+// failed
+func checkImportJob(job *models.ImportJob) {
+	if job.Status == models.ImportRunning && time.Since(job.UpdatedAt) > importJobTimeout {
+		job.Status = models.ImportFailed
+		job.Error = "The import was interrupted"
+	}
+}
+
+// runImport reads the export at filename and creates its notes in new
+// notebooks as they're read, saving the job's progress after each note.
+// Notes that fail are skipped rather than stopping the import.
+func (h *Handlers) runImport(ctx context.Context, job models.ImportJob, filename, name string) {
+	defer os.Remove(filename)
+
+	sink := &importSink{h: h, ctx: ctx, job: &job, mainNotebook: name, notebooks: make(map[string]int)}
+	if err := readImport(job.Format, filename, sink); err != nil {
+		job.Status, job.Error = models.ImportFailed, err.Error()
+		if err := h.Store.UpdateImportJob(job); err != nil {
+			log.Printf("Failed to update import %d: %v", job.ID, err)
+		}
+		h.notifyIndexer()
+		return
+	}
+
+	job.Status = models.ImportDone
+	if err := h.Store.UpdateImportJob(job); err != nil {
+		log.Printf("Failed to update import %d: %v", job.ID, err)
+	}
+	h.notifyIndexer()
+}
+
+// readImport reads the notes in an uploaded export into sink
+func readImport(format, filename string, sink importer.Sink) error {
+	if format == models.ImportENEX {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return importer.ReadENEX(f, sink)
+	}
+
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return errors.New("invalid zip file")
+	}
+	defer zr.Close()
+	return importer.ReadObsidian(&zr.Reader, sink)
+}
+
+// importSink saves an import job's notes as they're read, creating each
+// notebook the first time it's needed
+type importSink struct {
+	h            *Handlers
+	ctx          context.Context
+	job          *models.ImportJob
+	mainNotebook string         // For notes without a notebook
+	notebooks    map[string]int // IDs by name
+}
+
+func (s *importSink) Total(notes int) {
+	s.job.Total = notes
+	s.update()
+}
+
+func (s *importSink) Skip(item models.SkippedItem) {
+	s.job.Skipped = append(s.job.Skipped, item)
+}
+
+func (s *importSink) Note(note importer.Note) error {
+	if err := s.saveNote(note); err != nil {
+		log.Printf("Import %d: failed to import %s: %v", s.job.ID, note.Source, err)
+		s.Skip(models.SkippedItem{Item: note.Source, Reason: "Couldn't be saved"})
+	} else {
+		s.job.Imported++
+	}
+	s.job.Processed++
+	s.update()
+	return s.ctx.Err()
+}
+
+// update saves the job's progress
+func (s *importSink) update() {
+	if err := s.h.Store.UpdateImportJob(*s.job); err != nil {
+		log.Printf("Failed to update import %d: %v", s.job.ID, err)
+	}
+}
+
+// saveNote saves one imported note and its images, reading the images one
+// at a time
+func (s *importSink) saveNote(note importer.Note) error {
+	notebook := s.mainNotebook
+	if note.Notebook != "" {
+		notebook = note.Notebook
+	}
+	notebookID, ok := s.notebooks[notebook]
+	if !ok {
+		id, err := s.h.Store.CreateNotebook(s.job.UserID, notebook)
+		if err != nil {
+			return err
+		}
+		notebookID = int(id)
+		s.notebooks[notebook] = notebookID
+		s.job.NotebookIDs = append(s.job.NotebookIDs, notebookID)
+	}
+
+	createdAt := note.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	noteID, err := s.h.Store.CreateNoteAt(s.job.UserID, notebookID, importedContent(note.Content, note.Tags), createdAt)
+	if err != nil {
+		return err
+	}
+	for _, img := range note.Images {
+		item := fmt.Sprintf("%s: %s", note.Source, img.Name)
+		data, err := img.Read()
+		if err != nil {
+			s.Skip(models.SkippedItem{Item: item, Reason: "Image is too large or unreadable"})
+			continue
+		}
+		if err := s.h.importImage(s.ctx, s.job.UserID, int(noteID), img.Name, data); err != nil {
+			s.Skip(models.SkippedItem{Item: item, Reason: "Image couldn't be saved"})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxFailedLogins is how many wrong passwords or second-factor codes in a
+// row lock an account
+const maxFailedLogins = 10
+
+// accountLockout is how long a locked account refuses to sign in
+const accountLockout = 15 * time.Minute
+
+// dummyPasswordHash is checked against for unknown usernames, so that they
+// take as long to reject as a wrong password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("tracky"), bcrypt.DefaultCost)
+
+// rejectLockedAccount writes 429 Too Many Requests and returns true if the
+// account is locked after too many failed logins. It's only for the second
+// factor and for signed-in users confirming a change: password logins don't
+// reveal whether an account is locked.
+func (h *Handlers) rejectLockedAccount(w http.ResponseWriter, userID int) bool {
+	lockedUntil, err := h.Store.GetLockedUntil(userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return true
+	}
+	if lockedUntil.IsZero() {
+		return false
+	}
+	writeLocked(w, lockedUntil)
+	return true
+}
+
+// failLogin counts a failed login and writes the error response with
+// status, which says so instead if this failure locked the account
+func (h *Handlers) failLogin(w http.ResponseWriter, userID int, status int, message string) {
+	lockedUntil, err := h.Store.RecordFailedLogin(userID, maxFailedLogins, accountLockout)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		writeLocked(w, lockedUntil)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+func writeLocked(w http.ResponseWriter, lockedUntil time.Time) {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	http.Error(w, "Too many failed logins; try again later", http.StatusTooManyRequests)
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tracky/internal/auth"
+	"tracky/internal/importer"
+	"tracky/internal/markdown"
+	"tracky/internal/models"
+)
+
+// Limits on notebook imports
+const (
+	maxImportSize     = 100 << 20 // The uploaded zip
+	maxImportNoteSize = 1 << 20   // Each Markdown file
+	maxImportImage    = 10 << 20  // Each image, as for uploads
+)
+
+// NotebookExportHandler downloads a notebook as a zip with one Markdown file
+// per note, with its creation time, tags and images in the front matter, and
+// the images under images/. Only format=markdown is supported.
+// Route: GET /api/notebooks/{id}/export
+func (h *Handlers) NotebookExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	notebookID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid notebook ID", http.StatusBadRequest)
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "markdown" {
+		http.Error(w, "Unsupported format, use format=markdown", http.StatusBadRequest)
+		return
+	}
+
+	role, err := h.Store.GetNotebookRole(notebookID, userID)
+	if !checkRole(w, role, err, false, "Notebook not found") {
+		return
+	}
+	notebooks, err := h.Store.GetNotebooks(userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var name string
+	for _, nb := range notebooks {
+		if nb.ID == notebookID {
+			name = nb.Name
+		}
+	}
+	notes, err := h.Store.GetNotes(userID, notebookID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.attachNoteDetails(notes)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+
+	zw := zip.NewWriter(w)
+	for _, note := range notes {
+		if err := h.exportMarkdownNote(r.Context(), zw, note); err != nil {
+			log.Printf("Failed to export note %d: %v", note.ID, err)
+			return
+		}
+	}
+	zw.Close()
+}
+
+// exportMarkdownNote adds a note and its images to a Markdown export
+func (h *Handlers) exportMarkdownNote(ctx context.Context, zw *zip.Writer, note models.Note) error {
+	md := markdown.Note{CreatedAt: note.CreatedAt, Tags: note.Tags, Content: note.Content}
+	for _, img := range note.Images {
+		md.Images = append(md.Images, "images/"+img.Filename)
+	}
+
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     fmt.Sprintf("%s-%d.md", note.CreatedAt.Format("2006-01-02-150405"), note.ID),
+		Method:   zip.Deflate,
+		Modified: note.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(markdown.Format(md)); err != nil {
+		return err
+	}
+
+	for _, img := range note.Images {
+		if err := h.exportImage(ctx, zw, img.Filename); err != nil {
+			log.Printf("Failed to export image %s: %v", img.Filename, err)
+		}
+	}
+	return nil
+}
+
+// NotebookImportHandler creates a notebook from a Markdown export, keeping the
+// notes' original creation times. The zip is uploaded as the multipart field
+// "file"; the notebook is named after the optional "name" field or the file.
+// Route: POST /api/notebooks/import
+func (h *Handlers) NotebookImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "File too large", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "No file provided", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	zr, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		http.Error(w, "Invalid zip file", http.StatusBadRequest)
+		return
+	}
+
+	// Every note is checked before any is saved, then read again to save
+	// it, so that only one is in memory at a time
+	mdFiles := markdownFiles(zr)
+	if len(mdFiles) == 0 {
+		http.Error(w, "Invalid import: no Markdown files found", http.StatusBadRequest)
+		return
+	}
+	for _, f := range mdFiles {
+		if _, err := readMarkdownNote(f); err != nil {
+			http.Error(w, "Invalid import: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))
+	}
+	if name == "" {
+		name = "Imported notebook"
+	}
+	notebookID, err := h.Store.CreateNotebook(userID, name)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	var imported int64
+	for _, f := range mdFiles {
+		note, err := readMarkdownNote(f)
+		if err != nil {
+			h.discardImport(r.Context(), int(notebookID), userID)
+			http.Error(w, "Invalid import: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		noteID, err := h.Store.CreateNoteAt(userID, int(notebookID), importedContent(note.Content, note.Tags), note.CreatedAt)
+		if err != nil {
+			h.discardImport(r.Context(), int(notebookID), userID)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		for _, ref := range note.Images {
+			img, ok := files[path.Join(path.Dir(f.Name), ref)]
+			if !ok {
+				log.Printf("Import: %s refers to missing image %s", f.Name, ref)
+				continue
+			}
+			data, err := importer.ReadZipFile(img, maxImportImage)
+			if err == nil {
+				err = h.importImage(r.Context(), userID, int(noteID), img.Name, data)
+			}
+			if err != nil {
+				log.Printf("Import: failed to import image %s: %v", img.Name, err)
+			}
+		}
+		imported++
+	}
+	h.notifyIndexer()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int64{"id": notebookID, "notes": imported})
+}
+
+// discardImport permanently deletes the notebook of an import that failed
+// partway, rather than leaving it in the trash
+func (h *Handlers) discardImport(ctx context.Context, notebookID, userID int) {
+	if err := h.Store.DeleteNotebook(notebookID, userID); err != nil {
+		log.Printf("Import: failed to delete notebook %d: %v", notebookID, err)
+		return
+	}
+	filenames, err := h.Store.PurgeNotebook(notebookID, userID)
+	if err != nil {
+		log.Printf("Import: failed to purge notebook %d: %v", notebookID, err)
+		return
+	}
+	for _, filename := range filenames {
+		if err := h.Blobs.Delete(ctx, filename); err != nil {
+			log.Printf("Import: failed to remove image %s: %v", filename, err)
+		}
+	}
+}
+
+// markdownFiles returns the Markdown files in an import in name order, which
+// is creation order for exports
+func markdownFiles(zr *zip.Reader) []*zip.File {
+	var files []*zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !strings.EqualFold(path.Ext(f.Name), ".md") || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		files = append(files, f)
+	}
+	slices.SortFunc(files, func(a, b *zip.File) int { return strings.Compare(a.Name, b.Name) })
+	return files
+}
+
+// readMarkdownNote parses a Markdown file from an import. Files without a
+// creation time are dated now.
+func readMarkdownNote(f *zip.File) (markdown.Note, error) {
+	data, err := importer.ReadZipFile(f, maxImportNoteSize)
+	if err != nil {
+		return markdown.Note{}, fmt.Errorf("can't read %s: %v", f.Name, err)
+	}
+	note, err := markdown.Parse(data)
+	if err != nil {
+		return markdown.Note{}, fmt.Errorf("invalid front matter in %s: %v", f.Name, err)
+	}
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = time.Now()
+	}
+	return note, nil
+}
+
+// importedContent is a note's content, with any tags from the front matter
+// that aren't already hashtags in it added on a last line, since tags are
+// taken from the content
+func importedContent(content string, tags []string) string {
+	lower := strings.ToLower(content)
+	var missing []string
+	for _, tag := range tags {
+		tag = strings.TrimPrefix(strings.ReplaceAll(tag, " ", "-"), "#")
+		if tag != "" && !strings.Contains(lower, "#"+strings.ToLower(tag)) {
+			missing = append(missing, "#"+tag)
+		}
+	}
+	if len(missing) == 0 {
+		return content
+	}
+	return strings.TrimRight(content, "\n") + "\n\n" + strings.Join(missing, " ")
+}
+
+// importImage stores an image from an import and attaches it to a note,
+// compressed like an uploaded image
+func (h *Handlers) importImage(ctx context.Context, userID, noteID int, name string, data []byte) error {
+	ext := strings.ToLower(path.Ext(name))
+	if !importer.ImageExtensions[ext] {
+		return fmt.Errorf("unsupported file type %s", ext)
+	}
+
+	img, newExt, err := compressImage(bytes.NewReader(data), ext)
+	if err != nil {
+		return err
+	}
+	if img != nil {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return err
+		}
+		data, ext = buf.Bytes(), newExt
+	}
+
+	filename := fmt.Sprintf("%d_%d_%d%s", userID, noteID, time.Now().UnixNano(), ext)
+	if err := h.Blobs.Put(ctx, filename, bytes.NewReader(data), int64(len(data)), mime.TypeByExtension(ext)); err != nil {
+		return err
+	}
+	if _, err := h.Store.CreateNoteImage(noteID, filename); err != nil {
+		h.Blobs.Delete(ctx, filename)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"tracky/internal/auth"
+	"tracky/internal/models"
+)
+
+// NotebookMembersHandler lists a notebook's members (GET, for any member) or
+// shares it with a user by username (POST {username, role}, owner only).
+// Sharing with an existing member changes their role.
+// Route: /api/notebooks/{id}/members
+func (h *Handlers) NotebookMembersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	notebookID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid notebook ID", http.StatusBadRequest)
+		return
+	}
+	role, err := h.Store.GetNotebookRole(notebookID, userID)
+	if !checkRole(w, role, err, false, "Notebook not found") {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		members, err := h.Store.GetNotebookMembers(notebookID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(members)
+
+	case http.MethodPost:
+		if role != models.RoleOwner {
+			http.Error(w, "Only the owner can share this notebook", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Username string `json:"username"`
+			Role     string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !validMemberRole(req.Role) {
+			http.Error(w, "Role must be editor or viewer", http.StatusBadRequest)
+			return
+		}
+		memberID, err := h.Store.GetUserID(req.Username)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if memberID == userID {
+			http.Error(w, "You already own this notebook", http.StatusBadRequest)
+			return
+		}
+		if err := h.Store.SetNotebookMember(notebookID, memberID, req.Role); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int{"user_id": memberID})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// NotebookMemberHandler changes a member's role (PUT {role}, owner only) or
+// removes them from the notebook (DELETE, by the owner or the member leaving).
+// Route: /api/notebooks/{id}/members/{user_id}
+func (h *Handlers) NotebookMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	notebookID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid notebook ID", http.StatusBadRequest)
+		return
+	}
+	memberID, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	role, err := h.Store.GetNotebookRole(notebookID, userID)
+	if !checkRole(w, role, err, false, "Notebook not found") {
+		return
+	}
+	if _, err := h.Store.GetNotebookRole(notebookID, memberID); err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if role != models.RoleOwner {
+			http.Error(w, "Only the owner can change roles", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !validMemberRole(req.Role) {
+			http.Error(w, "Role must be editor or viewer", http.StatusBadRequest)
+			return
+		}
+		if memberID == userID {
+			http.Error(w, "The owner's role can't be changed", http.StatusBadRequest)
+			return
+		}
+		if err := h.Store.SetNotebookMember(notebookID, memberID, req.Role); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		if role != models.RoleOwner && memberID != userID {
+			http.Error(w, "Only the owner can remove other members", http.StatusForbidden)
+			return
+		}
+		err := h.Store.DeleteNotebookMember(notebookID, memberID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "The owner can't leave their notebook", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validMemberRole reports whether a notebook can be shared with the role.
+// Each notebook has exactly one owner, who created it.
+func validMemberRole(role string) bool {
+	return role == models.RoleEditor || role == models.RoleViewer
+}
+
+// checkRole reports whether a notebook role, as returned by GetNotebookRole
+// or GetNoteRole, allows reading or (if write) changing notes. Otherwise it
+// writes the error: notFound for non-members, so that other users' notebooks
+// stay invisible, and Forbidden for viewers.
+func checkRole(w http.ResponseWriter, role string, err error, write bool, notFound string) bool {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, notFound, http.StatusNotFound)
+		return false
+	case err != nil:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	case write && role == models.RoleViewer:
+		http.Error(w, "You have read-only access to this notebook", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"tracky/internal/oidc"
+)
+
+// oidcLoginCookie holds a single sign-on login's state between the redirect
+// to the identity provider and the callback
+const oidcLoginCookie = "oidc_login"
+
+// oidcLoginExpiry is how long the user has to sign in at the provider, in
+// seconds
+const oidcLoginExpiry = 10 * 60
+
+// OIDCLoginHandler starts single sign-on by redirecting to the identity
+// provider.
+// Route: GET /api/oidc/login
+func (h *Handlers) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	state, err := oidc.NewLoginState()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Lax so that the cookie comes back on the provider's redirect
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    state.State + "." + state.Nonce + "." + state.Verifier,
+		Path:     "/api/oidc/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   oidcLoginExpiry,
+	})
+	http.Redirect(w, r, h.OIDC.AuthCodeURL(state), http.StatusFound)
+}
+
+// OIDCCallbackHandler finishes single sign-on: it verifies the provider's
+// response, signs in the user linked to the identity (creating one with a
+// default notebook on first login) and redirects to the app. Users with
+// two-factor authentication are redirected to enter their code first.
+// Route: GET /api/oidc/callback
+func (h *Handlers) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcLoginCookie)
+	http.SetCookie(w, &http.Cookie{Name: oidcLoginCookie, Path: "/api/oidc/", HttpOnly: true, MaxAge: -1})
+	if err != nil {
+		http.Error(w, "Login expired, please sign in again", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(cookie.Value, ".")
+	q := r.URL.Query()
+	if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(q.Get("state"))) != 1 {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "Single sign-on failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := h.OIDC.Exchange(r.Context(), q.Get("code"), oidc.LoginState{State: parts[0], Nonce: parts[1], Verifier: parts[2]})
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		http.Error(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := h.Store.GetUserByIdentity(claims.Issuer, claims.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		userID, err = h.provisionUser(claims)
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Accounts with two-factor authentication finish at LoginTOTPHandler, with
+	// the challenge in the fragment so that it isn't sent to the server
+	_, totpEnabled, err := h.Store.GetTOTP(userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if totpEnabled {
+		token, err := h.createLoginChallenge(userID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/#two_factor="+token, http.StatusFound)
+		return
+	}
+
+	if err := h.startSession(w, r, userID); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// provisionUser creates a user for a new single sign-on identity, named after
+// their username or email at the provider, with a default notebook like
+// SignupHandler
+func (h *Handlers) provisionUser(claims *oidc.Claims) (int, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = claims.Email
+	}
+	if base == "" {
+		base = claims.Subject
+	}
+
+	// Add a number if the name is taken by another account
+	username := base
+	for i := 2; ; i++ {
+		if _, err := h.Store.GetUserID(username); errors.Is(err, sql.ErrNoRows) {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		username = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	id, err := h.Store.CreateIdentityUser(username, claims.Issuer, claims.Subject)
+	if err != nil {
+		return 0, err
+	}
+	h.Store.CreateDefaultNotebook(int(id))
+	return int(id), nil
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"tracky/internal/auth"
+	"tracky/internal/diff"
+	"tracky/internal/models"
+)
+
+// revisionResponse is a revision with the line changes since the one before it
+type revisionResponse struct {
+	models.NoteRevision
+	Diff []diff.Line `json:"diff"`
+}
+
+// RevisionsHandler returns a note's history, newest first.
+// Route: GET /api/notes/{id}/revisions
+func (h *Handlers) RevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	noteID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	revisions, err := h.Store.GetNoteRevisions(noteID, userID)
+	if err != nil {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+
+	history := make([]revisionResponse, len(revisions))
+	previous := ""
+	for i, rev := range revisions {
+		// Reverse so the newest revision comes first
+		history[len(revisions)-1-i] = revisionResponse{
+			NoteRevision: rev,
+			Diff:         diff.Lines(previous, rev.Content),
+		}
+		previous = rev.Content
+	}
+	json.NewEncoder(w).Encode(history)
+}
+
+// RestoreRevisionHandler sets a note's content back to an earlier revision,
+// which is always recorded as a new revision, even if the content is the same.
+// Route: POST /api/notes/{id}/revisions/{revision_id}/restore
+func (h *Handlers) RestoreRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	noteID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+	revisionID, err := strconv.Atoi(r.PathValue("revision_id"))
+	if err != nil {
+		http.Error(w, "Invalid revision ID", http.StatusBadRequest)
+		return
+	}
+
+	role, err := h.Store.GetNoteRole(noteID, userID)
+	if !checkRole(w, role, err, true, "Note not found") {
+		return
+	}
+	err = h.Store.RestoreNoteRevision(revisionID, noteID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.notifyIndexer()
+	w.WriteHeader(http.StatusOK)
+}
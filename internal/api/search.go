@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"tracky/internal/auth"
+	"tracky/internal/models"
+)
+
+const maxSearchLimit = 100
+
+// SearchHandler runs a full-text search across all of the user's notebooks.
+// Query params: q (required), notebook_id, tag, limit and mode (optional).
+// mode=semantic ranks notes by meaning using embeddings instead of keywords.
+func (h *Handlers) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+
+	filters := models.SearchFilters{Tag: r.URL.Query().Get("tag")}
+	if v := r.URL.Query().Get("notebook_id"); v != "" {
+		notebookID, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid notebook ID", http.StatusBadRequest)
+			return
+		}
+		filters.NotebookID = notebookID
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		filters.Limit = min(limit, maxSearchLimit)
+	}
+
+	var results []models.SearchResult
+	var err error
+	switch r.URL.Query().Get("mode") {
+	case "", "keyword":
+		results, err = h.Store.Search(userID, query, filters)
+	case "semantic":
+		if h.Embedder == nil {
+			http.Error(w, "Semantic search is not configured", http.StatusServiceUnavailable)
+			return
+		}
+		results, err = h.semanticSearch(r.Context(), userID, query, filters)
+	default:
+		http.Error(w, "Invalid mode", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []models.SearchResult{}
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+// semanticSearch embeds the query and finds the notes closest to it
+func (h *Handlers) semanticSearch(ctx context.Context, userID int, query string, filters models.SearchFilters) ([]models.SearchResult, error) {
+	vectors, err := h.Embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	return h.Store.SemanticSearch(userID, h.Embedder.EmbeddingModel(), vectors[0], filters)
+}
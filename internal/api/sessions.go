@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tracky/internal/auth"
+	"tracky/internal/middleware"
+	"tracky/internal/models"
+)
+
+// maxUserAgentLength caps how much of the User-Agent header is stored
+const maxUserAgentLength = 256
+
+// startSession signs the user in on this device: it stores a new session and
+// sets its cookie
+func (h *Handlers) startSession(w http.ResponseWriter, r *http.Request, userID int) error {
+	token, hash, err := auth.NewSessionToken()
+	if err != nil {
+		return err
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	if _, err := h.Store.CreateSession(userID, hash, userAgent, middleware.ClientIP(r), time.Now().Add(auth.SessionLifetime)); err != nil {
+		return err
+	}
+	auth.SetAuthCookie(w, token)
+	return nil
+}
+
+// SessionsHandler lists the user's signed-in sessions (GET) or logs them out
+// everywhere, including this session (DELETE).
+// Route: /api/sessions
+func (h *Handlers) SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := h.Store.GetSessions(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if sessions == nil {
+			sessions = []models.Session{}
+		}
+		currentID, _ := auth.GetSessionIDFromContext(r.Context())
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == currentID
+		}
+		json.NewEncoder(w).Encode(sessions)
+
+	case http.MethodDelete:
+		if err := h.Store.DeleteUserSessions(userID); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		auth.ClearAuthCookie(w)
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// SessionHandler revokes one session (DELETE).
+// Route: /api/sessions/{id}
+func (h *Handlers) SessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	sessionID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Store.DeleteSession(sessionID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if currentID, _ := auth.GetSessionIDFromContext(r.Context()); currentID == sessionID {
+		auth.ClearAuthCookie(w)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// RunSessionCleanup deletes expired sessions every interval until ctx is
+// cancelled
+func (h *Handlers) RunSessionCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := h.Store.DeleteExpiredSessions(time.Now()); err != nil {
+			log.Printf("Session cleanup failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tracky/internal/auth"
+	"tracky/internal/models"
+)
+
+// ShareLinksHandler lists the user's public share links (GET) or creates one
+// (POST with {"notebook_id": N} or {"note_id": N}, and optionally
+// "expires_in_days"). Owners and editors can share; the token and URL are
+// only returned on creation.
+// Route: /api/shares
+func (h *Handlers) ShareLinksHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		links, err := h.Store.GetShareLinks(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if links == nil {
+			links = []models.ShareLink{}
+		}
+		json.NewEncoder(w).Encode(links)
+
+	case http.MethodPost:
+		var req struct {
+			NotebookID    int `json:"notebook_id"`
+			NoteID        int `json:"note_id"`
+			ExpiresInDays int `json:"expires_in_days"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if (req.NotebookID == 0) == (req.NoteID == 0) {
+			http.Error(w, "Give either notebook_id or note_id", http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresInDays < 0 {
+			http.Error(w, "Invalid expiry", http.StatusBadRequest)
+			return
+		}
+
+		if req.NotebookID != 0 {
+			role, err := h.Store.GetNotebookRole(req.NotebookID, userID)
+			if !checkRole(w, role, err, true, "Notebook not found") {
+				return
+			}
+		} else {
+			role, err := h.Store.GetNoteRole(req.NoteID, userID)
+			if !checkRole(w, role, err, true, "Note not found") {
+				return
+			}
+		}
+
+		link := models.ShareLink{NotebookID: req.NotebookID, NoteID: req.NoteID, CreatedAt: time.Now()}
+		if req.ExpiresInDays > 0 {
+			expiresAt := link.CreatedAt.AddDate(0, 0, req.ExpiresInDays)
+			link.ExpiresAt = &expiresAt
+		}
+		var hash string
+		var err error
+		link.Token, hash, err = auth.NewShareToken()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		id, err := h.Store.CreateShareLink(userID, link, hash)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		link.ID = int(id)
+		link.URL = "/s/" + link.Token
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(link)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ShareLinkHandler revokes a share link (DELETE).
+// Route: /api/shares/{id}
+func (h *Handlers) ShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	linkID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid link ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Store.DeleteShareLink(linkID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// sharedPage is the data for sharedPageTemplate
+type sharedPage struct {
+	Title string
+	Token string
+	Notes []models.Note
+}
+
+var sharedPageTemplate = template.Must(template.New("shared").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>{{.Title}} - Tracky</title>
+    <style>
+        body { margin: 0 auto; max-width: 720px; padding: 24px; background: #121212; color: #e0e0e0; font-family: Inter, system-ui, sans-serif; }
+        h1 { font-size: 1.5rem; }
+        .note { background: #1e1e1e; border-radius: 8px; padding: 16px; margin-bottom: 16px; }
+        .meta { color: #a0a0a0; font-size: 0.85rem; margin-bottom: 8px; }
+        .content { white-space: pre-wrap; word-wrap: break-word; }
+        .images img { max-width: 100%; border-radius: 4px; margin-top: 8px; }
+    </style>
+</head>
+<body>
+    <h1>{{.Title}}</h1>
+    {{range .Notes}}
+    <div class="note">
+        <div class="meta">{{.CreatedAt.Format "Mon, 02 Jan 2006 15:04"}}</div>
+        <div class="content">{{.Content}}</div>
+        {{if .Images}}<div class="images">{{range .Images}}
+            <img src="/s/{{$.Token}}/images/{{.ID}}" alt="Note image">{{end}}
+        </div>{{end}}
+    </div>
+    {{else}}
+    <p>There are no notes here yet.</p>
+    {{end}}
+</body>
+</html>
+`))
+
+// PublicShareHandler renders the notes behind a share link as a read-only
+// page for anyone with the link.
+// Route: GET /s/{token}
+func (h *Handlers) PublicShareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.PathValue("token")
+	title, notes, err := h.sharedNotes(token)
+	if !writeShareError(w, err) {
+		return
+	}
+
+	setShareHeaders(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	sharedPageTemplate.Execute(w, sharedPage{Title: title, Token: token, Notes: notes})
+}
+
+// PublicShareImageHandler serves an image on one of a share link's notes.
+// Route: GET /s/{token}/images/{id}
+func (h *Handlers) PublicShareImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	imageID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid image ID", http.StatusBadRequest)
+		return
+	}
+	link, err := h.Store.GetShareLinkByHash(auth.HashToken(r.PathValue("token")))
+	if !writeShareError(w, err) {
+		return
+	}
+	filename, err := h.Store.GetShareLinkImage(link, imageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	setShareHeaders(w)
+	h.serveImage(w, r, filename)
+}
+
+// sharedNotes returns the title and notes, with their images, behind a share
+// link. Links stop working once their creator can no longer read the notes.
+func (h *Handlers) sharedNotes(token string) (string, []models.Note, error) {
+	link, err := h.Store.GetShareLinkByHash(auth.HashToken(token))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var notes []models.Note
+	notebookID := link.NotebookID
+	if link.NoteID != 0 {
+		note, err := h.Store.GetNote(link.NoteID, link.UserID)
+		if err != nil {
+			return "", nil, err
+		}
+		notes = []models.Note{note}
+		notebookID = note.NotebookID
+	} else if notes, err = h.Store.GetNotes(link.UserID, notebookID); err != nil {
+		return "", nil, err
+	}
+
+	notebooks, err := h.Store.GetNotebooks(link.UserID)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, nb := range notebooks {
+		if nb.ID == notebookID {
+			h.attachNoteDetails(notes)
+			return nb.Name, notes, nil
+		}
+	}
+	return "", nil, sql.ErrNoRows
+}
+
+// writeShareError writes the error response for a failed share link lookup
+// and returns false, or returns true if there was no error
+func writeShareError(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "This link doesn't exist or has expired", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// setShareHeaders keeps shared pages out of search engines and caches, and
+// stops the token leaking to other sites through the Referer header
+func setShareHeaders(w http.ResponseWriter) {
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Cache-Control", "private, no-cache")
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// parseTimeRange resolves a relative range such as "last_week" to its start
+// and end in now's location. Weeks start on Monday. Supported ranges: today,
+// yesterday, this_week, last_week, this_month, last_month, this_year and
+// last_N_days (which includes today).
+func parseTimeRange(name string, now time.Time) (start, end time.Time, err error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	weekStart := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	switch name {
+	case "today":
+		return today, endOfDay(today), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), endOfDay(today.AddDate(0, 0, -1)), nil
+	case "this_week":
+		return weekStart, endOfDay(today), nil
+	case "last_week":
+		return weekStart.AddDate(0, 0, -7), weekStart.Add(-time.Nanosecond), nil
+	case "this_month":
+		return monthStart, endOfDay(today), nil
+	case "last_month":
+		return monthStart.AddDate(0, -1, 0), monthStart.Add(-time.Nanosecond), nil
+	case "this_year":
+		return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location()), endOfDay(today), nil
+	}
+
+	var days int
+	if _, err := fmt.Sscanf(name, "last_%d_days", &days); err == nil && days > 0 && name == fmt.Sprintf("last_%d_days", days) {
+		return today.AddDate(0, 0, 1-days), endOfDay(today), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown range %q", name)
+}
+
+// parseRangeBound parses an RFC 3339 timestamp or a YYYY-MM-DD date in loc.
+// A date used as the end of a range covers the whole day.
+func parseRangeBound(value string, loc *time.Location, isEnd bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	if isEnd {
+		return endOfDay(day), nil
+	}
+	return day, nil
+}
+
+func endOfDay(day time.Time) time.Time {
+	return day.AddDate(0, 0, 1).Add(-time.Nanosecond)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tracky/internal/auth"
+	"tracky/internal/models"
+)
+
+// TokensHandler lists the user's API tokens (GET) or creates one (POST with
+// {"name": ..., "scope": "read"|"write", "expires_in_days": N}). The token
+// itself is only returned on creation. Scope defaults to read and tokens
+// without expires_in_days never expire.
+// Route: /api/tokens
+func (h *Handlers) TokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := h.Store.GetAPITokens(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if tokens == nil {
+			tokens = []models.APIToken{}
+		}
+		json.NewEncoder(w).Encode(tokens)
+
+	case http.MethodPost:
+		var req struct {
+			Name          string `json:"name"`
+			Scope         string `json:"scope"`
+			ExpiresInDays int    `json:"expires_in_days"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		if req.Scope == "" {
+			req.Scope = models.ScopeRead
+		}
+		if req.Scope != models.ScopeRead && req.Scope != models.ScopeWrite {
+			http.Error(w, "Scope must be read or write", http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresInDays < 0 {
+			http.Error(w, "Invalid expiry", http.StatusBadRequest)
+			return
+		}
+
+		token := models.APIToken{Name: req.Name, Scope: req.Scope, CreatedAt: time.Now()}
+		if req.ExpiresInDays > 0 {
+			expiresAt := token.CreatedAt.AddDate(0, 0, req.ExpiresInDays)
+			token.ExpiresAt = &expiresAt
+		}
+		var hash string
+		var err error
+		token.Token, hash, err = auth.NewAPIToken()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		id, err := h.Store.CreateAPIToken(userID, token.Name, hash, token.Scope, token.ExpiresAt)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		token.ID = int(id)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(token)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// TokenHandler revokes an API token (DELETE).
+// Route: /api/tokens/{id}
+func (h *Handlers) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Store.DeleteAPIToken(tokenID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// sessionUserID returns the signed-in user for endpoints that API tokens may
+// not use, so that a leaked token can't mint or revoke others, sign out the
+// user's sessions, or change or export the account. It writes an error
+// response and returns false otherwise.
+func sessionUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+	if _, ok := auth.GetAPITokenIDFromContext(r.Context()); ok {
+		http.Error(w, "Not allowed with an API token", http.StatusForbidden)
+		return 0, false
+	}
+	return userID, true
+}
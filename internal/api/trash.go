@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"tracky/internal/auth"
+	"tracky/internal/models"
+	"tracky/internal/store"
+)
+
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// TrashHandler lists deleted items (GET) and restores one (POST with
+// {"type": "notebook"|"note"|"image", "id": ...})
+func (h *Handlers) TrashHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		items, err := h.Store.GetTrash(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if items == nil {
+			items = []models.TrashItem{}
+		}
+		for i := range items {
+			items[i].PurgeAt = items[i].DeletedAt.Add(h.TrashRetention)
+		}
+		json.NewEncoder(w).Encode(items)
+
+	case http.MethodPost:
+		var req struct {
+			Type string `json:"type"`
+			ID   int    `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Type != "notebook" && req.Type != "note" && req.Type != "image" {
+			http.Error(w, "Invalid item type", http.StatusBadRequest)
+			return
+		}
+		err := h.Store.RestoreTrashItem(userID, req.Type, req.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, store.ErrNotebookTrashed) {
+			http.Error(w, "The notebook is in the trash; only its owner can restore it", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// PurgeTrash permanently deletes items that have been in the trash longer
+// than the retention period, along with their image files
+func (h *Handlers) PurgeTrash() error {
+	filenames, err := h.Store.PurgeTrash(time.Now().Add(-h.TrashRetention))
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		if err := h.Blobs.Delete(context.Background(), filename); err != nil {
+			log.Printf("Failed to remove purged image %s: %v", filename, err)
+		}
+	}
+	if len(filenames) > 0 {
+		log.Printf("Purged %d images from the trash", len(filenames))
+	}
+	return nil
+}
+
+// RunTrashPurger calls PurgeTrash every interval until ctx is cancelled
+func (h *Handlers) RunTrashPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := h.PurgeTrash(); err != nil {
+			log.Printf("Trash purge failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"tracky/internal/auth"
+)
+
+// totpIssuer names the account in authenticator apps
+const totpIssuer = "Tracky"
+
+// loginChallengeExpiry is how long a user has to enter their second factor
+// after their password
+const loginChallengeExpiry = 5 * time.Minute
+
+// maxLoginChallengeAttempts is how many wrong codes end a login attempt
+const maxLoginChallengeAttempts = 5
+
+// startLoginChallenge answers a correct password for an account with
+// two-factor authentication: instead of a session, the client gets a
+// challenge to complete at /api/login/totp
+func (h *Handlers) startLoginChallenge(w http.ResponseWriter, userID int) {
+	token, err := h.createLoginChallenge(userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"two_factor_required": true,
+		"challenge":           token,
+	})
+}
+
+// createLoginChallenge returns the token of a new login challenge for the
+// user
+func (h *Handlers) createLoginChallenge(userID int) (string, error) {
+	token, hash, err := auth.NewSessionToken()
+	if err != nil {
+		return "", err
+	}
+	if err := h.Store.CreateLoginChallenge(userID, hash, time.Now().Add(loginChallengeExpiry)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// LoginTOTPHandler finishes a two-factor login with {"challenge": ...} and
+// either "code" from the authenticator app or a one-time "recovery_code",
+// and signs the user in.
+// Route: POST /api/login/totp
+func (h *Handlers) LoginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Challenge    string `json:"challenge"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challengeHash := auth.HashToken(req.Challenge)
+	userID, attempts, err := h.Store.GetLoginChallenge(challengeHash)
+	if err != nil || attempts >= maxLoginChallengeAttempts {
+		h.Store.DeleteLoginChallenge(challengeHash)
+		http.Error(w, "Login expired, please sign in again", http.StatusUnauthorized)
+		return
+	}
+	if h.rejectLockedAccount(w, userID) {
+		return
+	}
+
+	verified, err := h.checkSecondFactor(userID, req.Code, req.RecoveryCode)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !verified {
+		h.Store.FailLoginChallenge(challengeHash)
+		h.failLogin(w, userID, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	h.Store.DeleteLoginChallenge(challengeHash)
+	h.Store.ResetFailedLogins(userID)
+	h.ensureNotebook(userID)
+	if err := h.startSession(w, r, userID); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkSecondFactor reports whether code is the user's current authenticator
+// code or recoveryCode one of their recovery codes, and uses it up so that it
+// can't be replayed
+func (h *Handlers) checkSecondFactor(userID int, code, recoveryCode string) (bool, error) {
+	switch {
+	case code != "":
+		secret, enabled, err := h.Store.GetTOTP(userID)
+		if err != nil {
+			return false, err
+		}
+		if step, ok := auth.ValidateTOTP(secret, code, time.Now()); ok && enabled {
+			return h.Store.UseTOTPStep(userID, step)
+		}
+	case recoveryCode != "":
+		return h.Store.UseRecoveryCode(userID, auth.HashRecoveryCode(recoveryCode))
+	}
+	return false, nil
+}
+
+// TOTPHandler manages two-factor authentication for the signed-in account:
+// GET shows whether it's enabled, POST starts enrollment and returns the
+// secret and otpauth:// URI to show as a QR code, PUT with {"code": ...}
+// confirms enrollment and returns one-time recovery codes, and DELETE with
+// {"password": ...} and either "code" or "recovery_code" turns it off. Accounts
+// without a password, which sign in with single sign-on, give only the code.
+// Route: /api/account/totp
+func (h *Handlers) TOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	secret, enabled, err := h.Store.GetTOTP(userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		remaining, err := h.Store.CountRecoveryCodes(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled":                  enabled,
+			"recovery_codes_remaining": remaining,
+		})
+
+	case http.MethodPost:
+		if enabled {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		username, _, err := h.Store.GetUserByID(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		secret, err := auth.NewTOTPSecret()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.Store.SetTOTPSecret(userID, secret); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"secret": secret,
+			"uri":    auth.TOTPURI(totpIssuer, username, secret),
+		})
+
+	case http.MethodPut:
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if enabled {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		if secret == "" {
+			http.Error(w, "Start enrollment first", http.StatusBadRequest)
+			return
+		}
+		step, ok := auth.ValidateTOTP(secret, strings.TrimSpace(req.Code), time.Now())
+		if !ok {
+			http.Error(w, "Invalid code", http.StatusBadRequest)
+			return
+		}
+		codes, hashes, err := auth.NewRecoveryCodes()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.Store.EnableTOTP(userID, step, hashes); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+
+	case http.MethodDelete:
+		var req struct {
+			Password     string `json:"password"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		_, hash, err := h.Store.GetUserByID(userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if h.rejectLockedAccount(w, userID) {
+			return
+		}
+		if hash != "" && !h.checkPassword(w, userID, hash, req.Password) {
+			return
+		}
+		// A stolen session and password aren't enough to remove the second
+		// factor, and wrong codes count towards the account lockout
+		if enabled {
+			verified, err := h.checkSecondFactor(userID, strings.TrimSpace(req.Code), req.RecoveryCode)
+			if err != nil {
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			if !verified {
+				h.failLogin(w, userID, http.StatusForbidden, "Invalid code")
+				return
+			}
+			h.Store.ResetFailedLogins(userID)
+		}
+		if err := h.Store.DisableTOTP(userID); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
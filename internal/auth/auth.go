@@ -2,90 +2,62 @@ package auth
 
 import (
 	"context"
-	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
-	"fmt"
+	"encoding/hex"
 	"net/http"
-	"os"
-	"strconv"
-	"strings"
 	"time"
 )
 
-// Context key for user ID
+// Context keys for the signed-in user and their session or API token
 type contextKey string
 
 const UserIDKey contextKey = "userID"
+const SessionIDKey contextKey = "sessionID"
+const APITokenIDKey contextKey = "apiTokenID"
 
-// Secret key for signing cookies (should be set via environment variable in production)
-var cookieSecret = []byte(getSecretKey())
+// SessionCookieName is the cookie holding the session token
+const SessionCookieName = "auth_token"
 
-func getSecretKey() string {
-	key := os.Getenv("COOKIE_SECRET")
-	if key == "" {
-		// Default key for development (DO NOT use in production)
-		key = "tracky-dev-secret-key-change-in-prod"
-	}
-	return key
-}
-
-// CreateSignedCookie creates a signed cookie value containing the user ID and expiration
-func CreateSignedCookie(userID int) string {
-	// Cookie format: userID.expiration.signature
-	expiration := time.Now().Add(7 * 24 * time.Hour).Unix() // 7 days
-	data := fmt.Sprintf("%d.%d", userID, expiration)
-	signature := signData(data)
-	return base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%s.%s", data, signature)))
-}
-
-// ValidateSignedCookie validates the cookie and returns the user ID if valid
-func ValidateSignedCookie(cookieValue string) (int, error) {
-	decoded, err := base64.URLEncoding.DecodeString(cookieValue)
-	if err != nil {
-		return 0, fmt.Errorf("invalid cookie encoding")
-	}
-
-	parts := strings.Split(string(decoded), ".")
-	if len(parts) != 3 {
-		return 0, fmt.Errorf("invalid cookie format")
-	}
+// SessionLifetime is how long a session lasts after signing in
+const SessionLifetime = 7 * 24 * time.Hour
 
-	userIDStr, expirationStr, signature := parts[0], parts[1], parts[2]
-
-	// Verify signature
-	data := fmt.Sprintf("%s.%s", userIDStr, expirationStr)
-	if !verifySignature(data, signature) {
-		return 0, fmt.Errorf("invalid signature")
-	}
+// APITokenPrefix marks API tokens so they're recognizable in scripts and
+// secret scanners
+const APITokenPrefix = "tracky_"
 
-	// Check expiration
-	expiration, err := strconv.ParseInt(expirationStr, 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid expiration")
-	}
-	if time.Now().Unix() > expiration {
-		return 0, fmt.Errorf("cookie expired")
-	}
+// NewSessionToken returns a random session token for the cookie and the hash
+// of it to store. Only the hash is kept, so a leaked database can't be used
+// to sign in.
+func NewSessionToken() (token, hash string, err error) {
+	return newToken("")
+}
 
-	// Parse user ID
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		return 0, fmt.Errorf("invalid user ID")
-	}
+// NewAPIToken returns a random API token and the hash of it to store
+func NewAPIToken() (token, hash string, err error) {
+	return newToken(APITokenPrefix)
+}
 
-	return userID, nil
+// NewShareToken returns a random token for a public share link and the hash
+// of it to store
+func NewShareToken() (token, hash string, err error) {
+	return newToken("")
 }
 
-func signData(data string) string {
-	h := hmac.New(sha256.New, cookieSecret)
-	h.Write([]byte(data))
-	return base64.URLEncoding.EncodeToString(h.Sum(nil))
+func newToken(prefix string) (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = prefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
 }
 
-func verifySignature(data, signature string) bool {
-	expectedSig := signData(data)
-	return hmac.Equal([]byte(expectedSig), []byte(signature))
+// HashToken returns the stored form of a session, API or share token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 // GetUserIDFromContext retrieves the user ID from the request context
@@ -94,24 +66,36 @@ func GetUserIDFromContext(ctx context.Context) (int, bool) {
 	return userID, ok
 }
 
-// SetAuthCookie sets the signed auth cookie on the response
-func SetAuthCookie(w http.ResponseWriter, userID int) {
-	cookieValue := CreateSignedCookie(userID)
+// GetSessionIDFromContext retrieves the ID of the request's session
+func GetSessionIDFromContext(ctx context.Context) (int, bool) {
+	sessionID, ok := ctx.Value(SessionIDKey).(int)
+	return sessionID, ok
+}
+
+// GetAPITokenIDFromContext retrieves the ID of the API token that
+// authenticated the request; ok is false for browser sessions
+func GetAPITokenIDFromContext(ctx context.Context) (int, bool) {
+	tokenID, ok := ctx.Value(APITokenIDKey).(int)
+	return tokenID, ok
+}
+
+// SetAuthCookie sets the session cookie on the response
+func SetAuthCookie(w http.ResponseWriter, token string) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     "auth_token",
-		Value:    cookieValue,
+		Name:     SessionCookieName,
+		Value:    token,
 		Path:     "/",
 		HttpOnly: true,
 		Secure:   false, // Set to true in production with HTTPS
 		SameSite: http.SameSiteLaxMode,
-		MaxAge:   7 * 24 * 60 * 60, // 7 days
+		MaxAge:   int(SessionLifetime.Seconds()),
 	})
 }
 
 // ClearAuthCookie clears the auth cookie
 func ClearAuthCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     "auth_token",
+		Name:     SessionCookieName,
 		Value:    "",
 		Path:     "/",
 		HttpOnly: true,
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B vectors for SHA-1, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		step, ok := ValidateTOTP(secret, tt.code, now)
+		if !ok {
+			t.Errorf("Expected %s to be valid at %d", tt.code, tt.unix)
+		}
+		if step != tt.unix/30 {
+			t.Errorf("Expected step %d, got %d", tt.unix/30, step)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	if _, ok := ValidateTOTP(secret, "005924", now.Add(30*time.Second)); !ok {
+		t.Error("Expected the previous step's code to be accepted")
+	}
+	if _, ok := ValidateTOTP(secret, "005924", now.Add(2*time.Minute)); ok {
+		t.Error("Expected an old code to be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "123456", now); ok {
+		t.Error("Expected a wrong code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Tracky", "alice", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/Tracky:alice?") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=Tracky") {
+		t.Errorf("Unexpected URI %q", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("Expected %d codes, got %d", recoveryCodeCount, len(codes))
+	}
+	if HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))) != hashes[0] {
+		t.Error("Expected recovery codes to match regardless of case and separators")
+	}
+}
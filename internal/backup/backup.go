@@ -0,0 +1,354 @@
+// Package backup writes a consistent snapshot of the database and uploaded
+// images to a single zip archive, and restores one into an empty database of
+// either dialect.
+//
+// The archive holds manifest.json, one JSON Lines file per table under db/
+// with a JSON array of values per row, and the image blobs under blobs/. The
+// manifest records each file's size and SHA-256 checksum, which are verified
+// before anything is restored.
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"time"
+
+	"tracky/internal/blobstore"
+	"tracky/internal/store/sqlstore"
+)
+
+// formatVersion is the version of the archive layout
+const formatVersion = 1
+
+const manifestName = "manifest.json"
+
+// Manifest describes the contents of a backup
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	Dialect       string    `json:"dialect"`        // Of the database backed up
+	SchemaVersion int       `json:"schema_version"` // The migration it was at
+	Tables        []Table   `json:"tables"`
+	Blobs         []Blob    `json:"blobs"`
+}
+
+// Table is one table's rows in a backup
+type Table struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Rows    int      `json:"rows"`
+	File
+}
+
+// Blob is an uploaded file in a backup
+type Blob struct {
+	Key string `json:"key"`
+	File
+}
+
+// File is a file in the archive
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Create writes a backup of the database and the images its notes refer to.
+// Images that disappear between the database snapshot and being copied, as
+// when the trash is purged meanwhile, are left out.
+func Create(ctx context.Context, store *sqlstore.SQLStore, blobs blobstore.BlobStore, w io.Writer) (*Manifest, error) {
+	m := &Manifest{FormatVersion: formatVersion, CreatedAt: time.Now(), Dialect: string(store.DBType())}
+	zw := zip.NewWriter(w)
+
+	tw := &tableWriter{zw: zw, manifest: m}
+	version, keys, err := store.Dump(ctx, tw)
+	if err != nil {
+		return nil, err
+	}
+	tw.finish()
+	m.SchemaVersion = version
+
+	for _, key := range keys {
+		blob, err := backupBlob(ctx, zw, blobs, key)
+		if errors.Is(err, blobstore.ErrNotFound) {
+			log.Printf("Skipping missing image %s", key)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("backing up image %s: %w", key, err)
+		}
+		m.Blobs = append(m.Blobs, blob)
+	}
+
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: manifestName, Method: zip.Deflate, Modified: m.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+	return m, zw.Close()
+}
+
+// backupBlob copies one blob into the archive
+func backupBlob(ctx context.Context, zw *zip.Writer, blobs blobstore.BlobStore, key string) (Blob, error) {
+	r, info, err := blobs.Get(ctx, key)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer r.Close()
+
+	b := Blob{Key: key, File: File{Path: "blobs/" + key}}
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: b.Path, Method: zip.Store, Modified: info.ModTime}) // Images are already compressed
+	if err != nil {
+		return b, err
+	}
+	h := sha256.New()
+	if b.Size, err = io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return b, err
+	}
+	b.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return b, nil
+}
+
+// tableWriter writes each table of a dump to its own file in the archive
+type tableWriter struct {
+	zw       *zip.Writer
+	manifest *Manifest
+	table    *Table
+	enc      *json.Encoder
+	hash     hash.Hash
+	size     countingWriter
+}
+
+func (tw *tableWriter) WriteTable(name string, columns []string) error {
+	tw.finish()
+	tw.table = &Table{Name: name, Columns: columns, File: File{Path: "db/" + name + ".jsonl"}}
+	f, err := tw.zw.CreateHeader(&zip.FileHeader{Name: tw.table.Path, Method: zip.Deflate, Modified: tw.manifest.CreatedAt})
+	if err != nil {
+		return err
+	}
+	tw.hash, tw.size = sha256.New(), 0
+	tw.enc = json.NewEncoder(io.MultiWriter(f, tw.hash, &tw.size))
+	return nil
+}
+
+func (tw *tableWriter) WriteRow(values []interface{}) error {
+	row := make([]interface{}, len(values))
+	for i, v := range values {
+		row[i] = encodeValue(v)
+	}
+	tw.table.Rows++
+	return tw.enc.Encode(row)
+}
+
+// finish records the current table in the manifest
+func (tw *tableWriter) finish() {
+	if tw.table == nil {
+		return
+	}
+	tw.table.Size = int64(tw.size)
+	tw.table.SHA256 = hex.EncodeToString(tw.hash.Sum(nil))
+	tw.manifest.Tables = append(tw.manifest.Tables, *tw.table)
+	tw.table = nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// Times and bytes, which JSON has no type for, are written as objects so
+// that they can be told apart from strings when restoring
+type (
+	timeValue struct {
+		Time time.Time `json:"time"`
+	}
+	bytesValue struct {
+		Base64 string `json:"base64"`
+	}
+)
+
+func encodeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return timeValue{Time: v}
+	case []byte:
+		return bytesValue{Base64: base64.StdEncoding.EncodeToString(v)}
+	}
+	return v
+}
+
+// Restore verifies a backup and loads it into an empty database and the
+// blob store. Nothing is restored if any file is missing or doesn't match
+// its checksum.
+func Restore(ctx context.Context, store *sqlstore.SQLStore, blobs blobstore.BlobStore, zr *zip.Reader) (*Manifest, error) {
+	m, err := Verify(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &tableReader{zr: zr, tables: m.Tables}
+	defer tr.close()
+	if err := store.Restore(ctx, m.SchemaVersion, tr); err != nil {
+		return nil, err
+	}
+
+	for _, b := range m.Blobs {
+		if err := restoreBlob(ctx, zr, blobs, b); err != nil {
+			return nil, fmt.Errorf("restoring image %s: %w", b.Key, err)
+		}
+	}
+	return m, nil
+}
+
+func restoreBlob(ctx context.Context, zr *zip.Reader, blobs blobstore.BlobStore, b Blob) error {
+	r, err := zr.Open(b.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return blobs.Put(ctx, b.Key, r, b.Size, mime.TypeByExtension(path.Ext(b.Key)))
+}
+
+// Verify reads a backup's manifest and checks that every file it lists is in
+// the archive with the recorded size and checksum
+func Verify(zr *zip.Reader) (*Manifest, error) {
+	f, err := zr.Open(manifestName)
+	if err != nil {
+		return nil, errors.New("not a backup: no manifest")
+	}
+	defer f.Close()
+	var m Manifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.FormatVersion != formatVersion {
+		return nil, fmt.Errorf("unsupported backup format %d", m.FormatVersion)
+	}
+
+	files := make([]File, 0, len(m.Tables)+len(m.Blobs))
+	for _, t := range m.Tables {
+		files = append(files, t.File)
+	}
+	for _, b := range m.Blobs {
+		files = append(files, b.File)
+	}
+	for _, file := range files {
+		if err := verifyFile(zr, file); err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Path, err)
+		}
+	}
+	return &m, nil
+}
+
+func verifyFile(zr *zip.Reader, file File) error {
+	r, err := zr.Open(file.Path)
+	if err != nil {
+		return errors.New("missing from the archive")
+	}
+	defer r.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	if size != file.Size || hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+		return errors.New("checksum mismatch")
+	}
+	return nil
+}
+
+// tableReader reads a backup's tables in the order of the manifest
+type tableReader struct {
+	zr     *zip.Reader
+	tables []Table
+	next   int
+	file   io.ReadCloser
+	dec    *json.Decoder
+}
+
+func (tr *tableReader) NextTable() (string, []string, error) {
+	tr.close()
+	if tr.next == len(tr.tables) {
+		return "", nil, io.EOF
+	}
+	t := tr.tables[tr.next]
+	tr.next++
+
+	f, err := tr.zr.Open(t.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	tr.file = f
+	tr.dec = json.NewDecoder(f)
+	tr.dec.UseNumber()
+	return t.Name, t.Columns, nil
+}
+
+func (tr *tableReader) NextRow() ([]interface{}, error) {
+	var raw []json.RawMessage
+	if err := tr.dec.Decode(&raw); err != nil {
+		return nil, err // io.EOF at the end of the file
+	}
+	row := make([]interface{}, len(raw))
+	for i, r := range raw {
+		v, err := decodeValue(r)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+func (tr *tableReader) close() {
+	if tr.file != nil {
+		tr.file.Close()
+		tr.file = nil
+	}
+}
+
+// decodeValue is the inverse of encodeValue
+func decodeValue(raw json.RawMessage) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		if s, ok := v["time"].(string); ok {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+		if s, ok := v["base64"].(string); ok {
+			return base64.StdEncoding.DecodeString(s)
+		}
+		return nil, fmt.Errorf("invalid value %s", raw)
+	}
+	return v, nil
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tracky/internal/blobstore"
+	"tracky/internal/models"
+	"tracky/internal/store/sqlstore"
+)
+
+func newStore(t *testing.T) (*sqlstore.SQLStore, blobstore.BlobStore) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := sqlstore.New("sqlite3", filepath.Join(dir, "tracky.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, blobstore.NewLocal(filepath.Join(dir, "uploads"))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	store, blobs := newStore(t)
+	store.CreateUser("backupuser", "hash")
+	userID, _ := store.GetUserID("backupuser")
+	notebookID, _ := store.CreateNotebook(userID, "Journal")
+	created := time.Date(2022, 6, 7, 8, 9, 10, 0, time.Local)
+	noteID, _ := store.CreateNoteAt(userID, int(notebookID), "Rainy day #weather", created)
+	store.CreateNoteImage(int(noteID), "rain.gif")
+	blobs.Put(ctx, "rain.gif", strings.NewReader("GIF89a"), 6, "image/gif")
+	store.CreateNoteImage(int(noteID), "gone.gif") // Its blob is missing
+
+	var buf bytes.Buffer
+	m, err := Create(ctx, store, blobs, &buf)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if m.Dialect != "sqlite3" || len(m.Blobs) != 1 || m.Blobs[0].Key != "rain.gif" {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+	archive := buf.Bytes()
+	zr, _ := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+
+	restored, restoredBlobs := newStore(t)
+	if _, err := Restore(ctx, restored, restoredBlobs, zr); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	userID, err = restored.GetUserID("backupuser")
+	if err != nil {
+		t.Fatalf("Expected the user to be restored: %v", err)
+	}
+	notes, _ := restored.GetNotes(userID, int(notebookID))
+	if len(notes) != 1 || notes[0].Content != "Rainy day #weather" || !notes[0].CreatedAt.Equal(created) {
+		t.Fatalf("Unexpected restored notes %+v", notes)
+	}
+	results, _ := restored.Search(userID, "rainy", models.SearchFilters{})
+	if len(results) != 1 {
+		t.Errorf("Expected the search index to be rebuilt, got %d results", len(results))
+	}
+	tags, _ := restored.GetTagsByNoteIDs([]int{int(noteID)})
+	if len(tags[int(noteID)]) != 1 {
+		t.Errorf("Expected the note's tag to be restored, got %v", tags)
+	}
+	r, _, err := restoredBlobs.Get(ctx, "rain.gif")
+	if err != nil {
+		t.Fatalf("Expected the image to be restored: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "GIF89a" {
+		t.Errorf("Restored image doesn't match")
+	}
+
+	// New rows don't collide with restored IDs
+	if id, err := restored.CreateNote(userID, int(notebookID), "After the restore"); err != nil || id == noteID {
+		t.Errorf("Expected a new note ID, got %d: %v", id, err)
+	}
+
+	if _, err := Restore(ctx, restored, restoredBlobs, zr); err == nil || !strings.Contains(err.Error(), "isn't empty") {
+		t.Errorf("Expected restoring into a used database to fail, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	store, blobs := newStore(t)
+	store.CreateUser("someone", "hash")
+	var buf bytes.Buffer
+	if _, err := Create(context.Background(), store, blobs, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite the archive with one table's file changed
+	zr, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	var tampered bytes.Buffer
+	zw := zip.NewWriter(&tampered)
+	for _, f := range zr.File {
+		r, _ := f.Open()
+		data, _ := io.ReadAll(r)
+		r.Close()
+		if f.Name == "db/users.jsonl" {
+			data = bytes.Replace(data, []byte("someone"), []byte("mallory"), 1)
+		}
+		w, _ := zw.Create(f.Name)
+		w.Write(data)
+	}
+	zw.Close()
+
+	zr, _ = zip.NewReader(bytes.NewReader(tampered.Bytes()), int64(tampered.Len()))
+	if _, err := Verify(zr); err == nil || !strings.Contains(err.Error(), "db/users.jsonl: checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+// Package blobstore stores uploaded files such as note images, on the local
+// filesystem or in an S3-compatible object store.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when a key doesn't exist
+var ErrNotFound = errors.New("blob not found")
+
+// ErrUnsupported is returned by SignedURL when the backend can't serve
+// blobs directly to clients
+var ErrUnsupported = errors.New("operation not supported by blob store")
+
+// Info describes a stored blob
+type Info struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore is a flat key-value store for files. Keys are slash-separated
+// and must not contain "..".
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns the blob's contents, which the caller must close. The
+	// reader also implements io.Seeker when the backend supports it.
+	Get(ctx context.Context, key string) (io.ReadCloser, *Info, error)
+	Delete(ctx context.Context, key string) error // Deleting a missing key is not an error
+	Stat(ctx context.Context, key string) (*Info, error)
+	// SignedURL returns a time-limited URL a client can fetch the blob from
+	// directly, or ErrUnsupported
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
@@ -0,0 +1,41 @@
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	got := Lines("a\nb\nc", "a\nx\nc\nd")
+	want := []Line{
+		{Equal, "a"},
+		{Delete, "b"},
+		{Insert, "x"},
+		{Equal, "c"},
+		{Insert, "d"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Lines() = %v, want %v", got, want)
+	}
+
+	if got := Lines("", "new"); !slices.Equal(got, []Line{{Insert, "new"}}) {
+		t.Errorf("Lines from empty = %v", got)
+	}
+
+	// Too large a change for the table is shown as a replacement, keeping
+	// the lines the texts start and end with
+	var before, after []string
+	for i := range 2000 {
+		before = append(before, fmt.Sprint("old ", i))
+		after = append(after, fmt.Sprint("new ", i))
+	}
+	a := "first\n" + strings.Join(before, "\n") + "\nlast"
+	b := "first\n" + strings.Join(after, "\n") + "\nlast"
+	got = Lines(a, b)
+	if len(got) != 4002 || got[0] != (Line{Equal, "first"}) || got[1] != (Line{Delete, "old 0"}) ||
+		got[2001] != (Line{Insert, "new 0"}) || got[4001] != (Line{Equal, "last"}) {
+		t.Errorf("Unexpected diff of a large change: %d lines", len(got))
+	}
+}
@@ -0,0 +1,271 @@
+package importer
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"tracky/internal/models"
+)
+
+// enexTimeLayout is the format of times in ENEX files, which are UTC
+const enexTimeLayout = "20060102T150405Z"
+
+// enexNote is a <note> in an ENEX file
+type enexNote struct {
+	Title     string   `xml:"title"`
+	Content   string   `xml:"content"` // ENML, a subset of XHTML
+	Created   string   `xml:"created"`
+	Tags      []string `xml:"tag"`
+	Resources []struct {
+		Data     string `xml:"data"` // Base64
+		Mime     string `xml:"mime"`
+		FileName string `xml:"resource-attributes>file-name"`
+	} `xml:"resource"`
+}
+
+// ReadENEX reads the notes in an Evernote export into sink, one at a time.
+// Each note's title becomes a heading at the top of its content. Attached
+// images are decoded; other attachments are skipped. The file is read twice:
+// first to count its notes.
+func ReadENEX(r io.ReadSeeker, sink Sink) error {
+	total, err := countENEXNotes(r)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sink.Total(total)
+
+	return readENEXNotes(r, func(dec *xml.Decoder, start *xml.StartElement) error {
+		var en enexNote
+		if err := dec.DecodeElement(&en, start); err != nil {
+			return fmt.Errorf("invalid ENEX file: %v", err)
+		}
+		note, skipped := en.note()
+		for _, item := range skipped {
+			sink.Skip(item)
+		}
+		if note == nil {
+			return nil
+		}
+		return sink.Note(*note)
+	})
+}
+
+// countENEXNotes counts the notes in an ENEX file without decoding them
+func countENEXNotes(r io.Reader) (int, error) {
+	var n int
+	err := readENEXNotes(r, func(dec *xml.Decoder, start *xml.StartElement) error {
+		n++
+		return dec.Skip()
+	})
+	return n, err
+}
+
+// readENEXNotes calls fn at the start of each <note> in an ENEX file, which
+// must consume the element
+func readENEXNotes(r io.Reader, fn func(dec *xml.Decoder, start *xml.StartElement) error) error {
+	dec := xml.NewDecoder(r)
+	sawRoot := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid ENEX file: %v", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !sawRoot {
+			if start.Name.Local != "en-export" {
+				return errors.New("not an ENEX file")
+			}
+			sawRoot = true
+			continue
+		}
+		if start.Name.Local != "note" {
+			continue
+		}
+		if err := fn(dec, &start); err != nil {
+			return err
+		}
+	}
+	if !sawRoot {
+		return errors.New("not an ENEX file")
+	}
+	return nil
+}
+
+// note converts an ENEX note, returning nil if it can't be imported, and
+// what was skipped
+func (en enexNote) note() (*Note, []models.SkippedItem) {
+	title := strings.TrimSpace(en.Title)
+	if title == "" {
+		title = "Untitled"
+	}
+	if len(en.Content) > maxNoteSize {
+		return nil, []models.SkippedItem{{Item: title, Reason: "Note is too large"}}
+	}
+	text, err := enmlToText(en.Content)
+	if err != nil {
+		return nil, []models.SkippedItem{{Item: title, Reason: "Unreadable content"}}
+	}
+
+	note := &Note{Source: title, Content: "# " + title, Tags: en.Tags}
+	if text != "" {
+		note.Content += "\n\n" + text
+	}
+	if created, err := time.Parse(enexTimeLayout, en.Created); err == nil {
+		note.CreatedAt = created
+	}
+
+	var skipped []models.SkippedItem
+	for i, res := range en.Resources {
+		name := strings.TrimSpace(res.FileName)
+		if name == "" {
+			name = fmt.Sprintf("attachment %d", i+1)
+		}
+		item := title + ": " + name
+		ext, ok := imageTypes[strings.ToLower(strings.TrimSpace(res.Mime))]
+		if !ok {
+			skipped = append(skipped, models.SkippedItem{Item: item, Reason: "Unsupported attachment type " + res.Mime})
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(res.Data), ""))
+		if err != nil {
+			skipped = append(skipped, models.SkippedItem{Item: item, Reason: "Invalid attachment data"})
+			continue
+		}
+		if len(data) > maxImageSize {
+			skipped = append(skipped, models.SkippedItem{Item: item, Reason: "Image is too large"})
+			continue
+		}
+		if !ImageExtensions[strings.ToLower(path.Ext(name))] {
+			name += ext
+		}
+		note.Images = append(note.Images, Image{Name: name, Read: func() ([]byte, error) { return data, nil }})
+	}
+	return note, skipped
+}
+
+// blockElements are the ENML elements that start and end on their own line
+var blockElements = map[string]bool{
+	"en-note": true, "div": true, "p": true, "ul": true, "ol": true, "li": true,
+	"table": true, "tr": true, "blockquote": true, "pre": true, "hr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+var (
+	spaces     = regexp.MustCompile(`[ \t\r\n]+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// enmlToText converts a note's ENML to plain text with light Markdown:
+// headings, list items, checkboxes and link targets. Embedded media is
+// left out, since it's attached to the note instead.
+func enmlToText(enml string) (string, error) {
+	dec := xml.NewDecoder(strings.NewReader(enml))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var b strings.Builder
+	newline := func() {
+		if s := b.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	var pre int
+	var href string
+	var linkStart int
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if blockElements[name] {
+				newline()
+			}
+			switch name {
+			case "br":
+				b.WriteString("\n")
+			case "hr":
+				b.WriteString("---")
+			case "li":
+				b.WriteString("- ")
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				b.WriteString(strings.Repeat("#", int(name[1]-'0')) + " ")
+			case "en-todo":
+				if attr(t, "checked") == "true" {
+					b.WriteString("[x] ")
+				} else {
+					b.WriteString("[ ] ")
+				}
+			case "pre":
+				pre++
+			case "a":
+				href, linkStart = attr(t, "href"), b.Len()
+			}
+
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			switch name {
+			case "pre":
+				pre--
+			case "a":
+				if text := b.String()[linkStart:]; href != "" && strings.TrimSpace(text) != href {
+					b.WriteString(" (" + href + ")")
+				}
+				href = ""
+			}
+			if blockElements[name] {
+				newline()
+			}
+
+		case xml.CharData:
+			text := string(t)
+			if pre == 0 {
+				text = spaces.ReplaceAllString(text, " ")
+				if s := b.String(); s == "" || strings.HasSuffix(s, "\n") {
+					text = strings.TrimLeft(text, " ")
+				}
+			}
+			b.WriteString(text)
+		}
+	}
+
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	text := blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text), nil
+}
+
+// attr returns an element's attribute, or "" if it doesn't have it
+func attr(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+// Package importer reads notes exported from other apps: Evernote's ENEX
+// files and zipped Obsidian vaults.
+package importer
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"time"
+
+	"tracky/internal/models"
+)
+
+// Limits on what's imported, as for notes and uploads created in Tracky
+const (
+	maxNoteSize  = 1 << 20
+	maxImageSize = 10 << 20
+)
+
+// imageTypes maps the image types notes can have to their file extensions
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// ImageExtensions are the file extensions of images notes can have, for
+// uploads as well as imports
+var ImageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
+
+// Note is a note read from an export
+type Note struct {
+	Source    string // Where it came from in the export, for reports
+	Notebook  string // "" for the import's main notebook
+	Content   string
+	CreatedAt time.Time // Zero if the export doesn't say
+	Tags      []string  // Tags kept outside the content
+	Images    []Image
+}
+
+// Image is an image attached to or embedded in a note. Its data is only read
+// when Read is called, so that a note's images needn't all be in memory at
+// once.
+type Image struct {
+	Name string // Including its extension
+	Read func() ([]byte, error)
+}
+
+// Sink receives an export's notes as they're read, so that only one note is
+// in memory at a time
+type Sink interface {
+	Total(notes int)              // Called before the first note with how many the export has
+	Note(note Note) error         // Returning an error stops the import
+	Skip(item models.SkippedItem) // Something in the export that can't be imported
+}
+
+// ReadZipFile reads a file from a zip, failing if it's larger than limit
+func ReadZipFile(f *zip.File, limit int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
+
+var errTooLarge = errors.New("too large")
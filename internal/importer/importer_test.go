@@ -0,0 +1,154 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"tracky/internal/models"
+)
+
+const testENEX = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export4.dtd">
+<en-export export-date="20240101T000000Z" application="Evernote">
+  <note>
+    <title>Trip plan</title>
+    <content><![CDATA[<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><div>Pack &amp; go&nbsp;soon</div><div><br/></div><ul><li><en-todo checked="true"/>Tickets</li><li><en-todo/>Hotel</li></ul><div><a href="https://example.com">Map</a></div><en-media hash="abc" type="image/png"/></en-note>]]></content>
+    <created>20230405T061500Z</created>
+    <tag>travel</tag>
+    <resource>
+      <data encoding="base64">R0lG
+ODlh</data>
+      <mime>image/gif</mime>
+      <resource-attributes><file-name>map</file-name></resource-attributes>
+    </resource>
+    <resource>
+      <data encoding="base64">JVBERg==</data>
+      <mime>application/pdf</mime>
+      <resource-attributes><file-name>tickets.pdf</file-name></resource-attributes>
+    </resource>
+  </note>
+  <note>
+    <title></title>
+    <content><![CDATA[<en-note/>]]></content>
+  </note>
+</en-export>`
+
+// collector is a Sink that keeps what it's given
+type collector struct {
+	total   int
+	notes   []Note
+	skipped []models.SkippedItem
+}
+
+func (c *collector) Total(notes int)              { c.total = notes }
+func (c *collector) Note(note Note) error         { c.notes = append(c.notes, note); return nil }
+func (c *collector) Skip(item models.SkippedItem) { c.skipped = append(c.skipped, item) }
+
+// imageData reads an image, failing the test if it can't
+func imageData(t *testing.T, img Image) string {
+	t.Helper()
+	data, err := img.Read()
+	if err != nil {
+		t.Fatalf("Reading %s: %v", img.Name, err)
+	}
+	return string(data)
+}
+
+func TestReadENEX(t *testing.T) {
+	var c collector
+	if err := ReadENEX(strings.NewReader(testENEX), &c); err != nil {
+		t.Fatal(err)
+	}
+	notes, skipped := c.notes, c.skipped
+	if len(notes) != 2 || c.total != 2 {
+		t.Fatalf("Expected 2 notes, got %d of %d", len(notes), c.total)
+	}
+
+	trip := notes[0]
+	want := "# Trip plan\n\nPack & go soon\n\n- [x] Tickets\n- [ ] Hotel\nMap (https://example.com)"
+	if trip.Content != want {
+		t.Errorf("Got content %q, want %q", trip.Content, want)
+	}
+	if !trip.CreatedAt.Equal(time.Date(2023, 4, 5, 6, 15, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected creation time %v", trip.CreatedAt)
+	}
+	if !slices.Equal(trip.Tags, []string{"travel"}) {
+		t.Errorf("Unexpected tags %v", trip.Tags)
+	}
+	if len(trip.Images) != 1 || trip.Images[0].Name != "map.gif" || imageData(t, trip.Images[0]) != "GIF89a" {
+		t.Errorf("Unexpected images %+v", trip.Images)
+	}
+	if len(skipped) != 1 || skipped[0].Item != "Trip plan: tickets.pdf" {
+		t.Errorf("Expected the PDF to be skipped, got %+v", skipped)
+	}
+
+	if notes[1].Content != "# Untitled" || !notes[1].CreatedAt.IsZero() {
+		t.Errorf("Unexpected empty note %+v", notes[1])
+	}
+
+	if err := ReadENEX(strings.NewReader("<html></html>"), &collector{}); err == nil {
+		t.Error("Expected an error for a file that isn't ENEX")
+	}
+}
+
+func TestReadObsidian(t *testing.T) {
+	modified := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct{ name, content string }{
+		{"Vault/Home.md", "See [[Projects/Launch|the launch]] ![[logo.png|100]]"},
+		{"Vault/Projects/Launch.md", "---\ncreated: 2023-01-02\ntags: [work]\n---\nShip ![diagram](../attachments/flow%20chart.gif) ![[missing.png]]"},
+		{"Vault/attachments/logo.png", "PNG"},
+		{"Vault/attachments/flow chart.gif", "GIF"},
+		{"Vault/attachments/unused.jpg", "JPG"},
+		{"Vault/Board.canvas", "{}"},
+		{"Vault/.obsidian/app.json", "{}"},
+	} {
+		w, _ := zw.CreateHeader(&zip.FileHeader{Name: f.name, Modified: modified})
+		w.Write([]byte(f.content))
+	}
+	zw.Close()
+	zr, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+
+	var c collector
+	if err := ReadObsidian(zr, &c); err != nil {
+		t.Fatal(err)
+	}
+	notes, skipped := c.notes, c.skipped
+	if len(notes) != 2 || c.total != 2 {
+		t.Fatalf("Expected 2 notes, got %d of %d", len(notes), c.total)
+	}
+
+	home, launch := notes[0], notes[1]
+	if home.Notebook != "" || home.Content != "# Home\n\nSee [[Projects/Launch|the launch]] ![[logo.png|100]]" || !home.CreatedAt.Equal(modified) {
+		t.Errorf("Unexpected note %+v", home)
+	}
+	if len(home.Images) != 1 || home.Images[0].Name != "logo.png" || imageData(t, home.Images[0]) != "PNG" {
+		t.Errorf("Expected logo.png attached, got %+v", home.Images)
+	}
+
+	if launch.Notebook != "Projects" || !slices.Equal(launch.Tags, []string{"work"}) {
+		t.Errorf("Unexpected note %+v", launch)
+	}
+	if !launch.CreatedAt.Equal(time.Date(2023, 1, 2, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected the front matter's creation time, got %v", launch.CreatedAt)
+	}
+	if len(launch.Images) != 1 || launch.Images[0].Name != "flow chart.gif" {
+		t.Errorf("Expected flow chart.gif attached, got %+v", launch.Images)
+	}
+
+	var items []string
+	for _, s := range skipped {
+		items = append(items, s.Item)
+	}
+	want := []string{"Projects/Launch.md: missing.png", "Board.canvas", "attachments/unused.jpg"}
+	if !slices.Equal(items, want) {
+		t.Errorf("Skipped %v, want %v", items, want)
+	}
+}
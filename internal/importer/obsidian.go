@@ -0,0 +1,170 @@
+package importer
+
+import (
+	"archive/zip"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"tracky/internal/markdown"
+	"tracky/internal/models"
+)
+
+// Image embeds in Obsidian notes: ![[name.png]] or ![[name.png|300]], and
+// Markdown's ![alt](path/name.png)
+var (
+	wikiEmbed     = regexp.MustCompile(`!\[\[([^\]|#^]+)[^\]]*\]\]`)
+	markdownEmbed = regexp.MustCompile(`!\[[^\]]*\]\(<?([^)<>]+?)>?(?:\s+"[^"]*")?\)`)
+)
+
+// ReadObsidian reads the notes in a zipped Obsidian vault into sink, one at a
+// time. Folders become notebooks named after their path in the vault; notes
+// at its top level go in the import's main notebook. Each note's name
+// becomes a heading at the top of its content, so that wiki-links, which are
+// kept as they are, still say what they link to. Embedded images are
+// attached to their notes.
+func ReadObsidian(zr *zip.Reader, sink Sink) error {
+	root := vaultRoot(zr.File)
+	files := make(map[string]*zip.File)  // By path in the vault
+	byName := make(map[string]*zip.File) // By lowercased file name, as wiki-links find them
+	var notePaths, otherPaths []string
+	for _, f := range zr.File {
+		name := strings.TrimPrefix(f.Name, root)
+		if f.FileInfo().IsDir() || hiddenPath(name) {
+			continue
+		}
+		files[name] = f
+		if _, ok := byName[strings.ToLower(path.Base(name))]; !ok {
+			byName[strings.ToLower(path.Base(name))] = f
+		}
+		if strings.EqualFold(path.Ext(name), ".md") {
+			notePaths = append(notePaths, name)
+		} else {
+			otherPaths = append(otherPaths, name)
+		}
+	}
+	slices.Sort(notePaths)
+	slices.Sort(otherPaths)
+	sink.Total(len(notePaths))
+
+	embedded := make(map[*zip.File]bool)
+	for _, name := range notePaths {
+		f := files[name]
+		data, err := ReadZipFile(f, maxNoteSize)
+		if err != nil {
+			sink.Skip(models.SkippedItem{Item: name, Reason: "Note is too large or unreadable"})
+			continue
+		}
+		md, err := markdown.Parse(data)
+		if err != nil {
+			sink.Skip(models.SkippedItem{Item: name, Reason: "Invalid front matter"})
+			continue
+		}
+
+		title := strings.TrimSuffix(path.Base(name), path.Ext(name))
+		note := Note{Source: name, Content: "# " + title, CreatedAt: md.CreatedAt, Tags: md.Tags}
+		if md.Content != "" {
+			note.Content += "\n\n" + md.Content
+		}
+		if note.CreatedAt.IsZero() {
+			note.CreatedAt = f.Modified
+		}
+		dir := path.Dir(name)
+		if dir != "." {
+			note.Notebook = dir
+		}
+
+		seen := make(map[*zip.File]bool)
+		for _, ref := range embeddedImages(md.Content) {
+			img := files[path.Join(dir, ref)]
+			if img == nil {
+				img = files[path.Clean(ref)]
+			}
+			if img == nil {
+				img = byName[strings.ToLower(path.Base(ref))]
+			}
+			if img == nil {
+				sink.Skip(models.SkippedItem{Item: name + ": " + ref, Reason: "Embedded image not found"})
+				continue
+			}
+			if seen[img] {
+				continue
+			}
+			seen[img] = true
+			embedded[img] = true
+			note.Images = append(note.Images, Image{
+				Name: path.Base(img.Name),
+				Read: func() ([]byte, error) { return ReadZipFile(img, maxImageSize) },
+			})
+		}
+		if err := sink.Note(note); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range otherPaths {
+		switch {
+		case embedded[files[name]]:
+		case ImageExtensions[strings.ToLower(path.Ext(name))]:
+			sink.Skip(models.SkippedItem{Item: name, Reason: "Image isn't embedded in any note"})
+		default:
+			sink.Skip(models.SkippedItem{Item: name, Reason: "Unsupported file type"})
+		}
+	}
+	return nil
+}
+
+// embeddedImages returns the paths of the images a note embeds, in order
+func embeddedImages(content string) []string {
+	var refs []string
+	for _, m := range wikiEmbed.FindAllStringSubmatch(content, -1) {
+		refs = append(refs, strings.TrimSpace(m[1]))
+	}
+	for _, m := range markdownEmbed.FindAllStringSubmatch(content, -1) {
+		ref := strings.TrimSpace(m[1])
+		if strings.Contains(ref, "://") {
+			continue // Not in the vault
+		}
+		if unescaped, err := url.PathUnescape(ref); err == nil {
+			ref = unescaped
+		}
+		refs = append(refs, ref)
+	}
+	return slices.DeleteFunc(refs, func(ref string) bool {
+		return !ImageExtensions[strings.ToLower(path.Ext(ref))]
+	})
+}
+
+// vaultRoot returns the folder, with a trailing slash, that holds everything
+// in the zip, as when a vault's folder was zipped, or "" if there isn't one
+func vaultRoot(files []*zip.File) string {
+	var root string
+	for _, f := range files {
+		if hiddenPath(f.Name) {
+			continue
+		}
+		first, _, ok := strings.Cut(f.Name, "/")
+		if !ok {
+			return "" // A file at the top level
+		}
+		if root == "" {
+			root = first + "/"
+		} else if root != first+"/" {
+			return ""
+		}
+	}
+	return root
+}
+
+// hiddenPath reports whether a path is in or is a hidden file or folder,
+// such as the vault's .obsidian settings and .trash, or macOS zip metadata
+func hiddenPath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Fake is a deterministic provider for tests and offline development. It
+// answers by echoing the question and records the requests it receives.
+type Fake struct {
+	// Reply, if set, computes the answer instead of the default echo
+	Reply func(req Request) (string, error)
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// Stream emits the answer Complete would give one word at a time
+func (f *Fake) Stream(ctx context.Context, req Request, emit func(chunk string) error) error {
+	answer, err := f.Complete(ctx, req)
+	if err != nil {
+		return err
+	}
+	for _, word := range strings.SplitAfter(answer, " ") {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := emit(word); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fake) Complete(ctx context.Context, req Request) (string, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if f.Reply != nil {
+		return f.Reply(req)
+	}
+	var question string
+	if len(req.Messages) > 0 {
+		question = req.Messages[len(req.Messages)-1].Content
+	}
+	return fmt.Sprintf("Fake answer to %q", question), nil
+}
+
+// Requests returns the requests received so far
+func (f *Fake) Requests() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request(nil), f.requests...)
+}
+
+// fakeDimensions is the length of the Fake provider's vectors
+const fakeDimensions = 64
+
+// Embed hashes each word into a bag-of-words vector, so texts sharing words
+// are similar
+func (f *Fake) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, fakeDimensions)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, word := range words {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			v[h.Sum32()%fakeDimensions]++
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+func (f *Fake) EmbeddingModel() string { return "fake" }
@@ -0,0 +1,111 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/genai"
+)
+
+const (
+	defaultGeminiModel          = "gemini-2.5-flash"
+	defaultGeminiEmbeddingModel = "gemini-embedding-001"
+)
+
+// Gemini uses Google's Gemini API
+type Gemini struct {
+	client         *genai.Client
+	model          string
+	embeddingModel string
+}
+
+// NewGemini creates a Gemini provider. The client is reused across requests.
+func NewGemini(ctx context.Context, apiKey, model, embeddingModel string) (*Gemini, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("Gemini API key not set")
+	}
+	if model == "" {
+		model = defaultGeminiModel
+	}
+	if embeddingModel == "" {
+		embeddingModel = defaultGeminiEmbeddingModel
+	}
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  apiKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+	return &Gemini{client: client, model: model, embeddingModel: embeddingModel}, nil
+}
+
+func (g *Gemini) Complete(ctx context.Context, req Request) (string, error) {
+	contents, config := geminiContents(req)
+	resp, err := g.client.Models.GenerateContent(ctx, g.model, contents, config)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate content: %w", err)
+	}
+	text := resp.Text()
+	if text == "" {
+		return "", fmt.Errorf("empty response from Gemini")
+	}
+	return text, nil
+}
+
+func (g *Gemini) Stream(ctx context.Context, req Request, emit func(chunk string) error) error {
+	contents, config := geminiContents(req)
+	for resp, err := range g.client.Models.GenerateContentStream(ctx, g.model, contents, config) {
+		if err != nil {
+			return fmt.Errorf("failed to generate content: %w", err)
+		}
+		if text := resp.Text(); text != "" {
+			if err := emit(text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *Gemini) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var contents []*genai.Content
+	for _, text := range texts {
+		contents = append(contents, genai.NewContentFromText(text, genai.RoleUser))
+	}
+	resp, err := g.client.Models.EmbedContent(ctx, g.embeddingModel, contents, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed content: %w", err)
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Embeddings))
+	}
+	vectors := make([][]float32, len(texts))
+	for i, e := range resp.Embeddings {
+		vectors[i] = e.Values
+	}
+	return vectors, nil
+}
+
+func (g *Gemini) EmbeddingModel() string { return g.embeddingModel }
+
+// geminiContents turns a request into Gemini's contents and config
+func geminiContents(req Request) ([]*genai.Content, *genai.GenerateContentConfig) {
+	var contents []*genai.Content
+	for _, msg := range req.Messages {
+		role := genai.Role(genai.RoleUser)
+		if msg.Role == RoleModel {
+			role = genai.RoleModel
+		}
+		contents = append(contents, genai.NewContentFromText(msg.Content, role))
+	}
+
+	var config *genai.GenerateContentConfig
+	if req.System != "" {
+		config = &genai.GenerateContentConfig{
+			SystemInstruction: genai.NewContentFromText(req.System, genai.RoleUser),
+		}
+	}
+
+	return contents, config
+}
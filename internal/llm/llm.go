@@ -0,0 +1,76 @@
+// Package llm talks to the language models used for note analysis. Providers
+// are interchangeable so analysis can run against Gemini, any
+// OpenAI-compatible API, a local Ollama server or a fake in tests.
+package llm
+
+import (
+	"context"
+	"fmt"
+)
+
+// Message roles. Conversations alternate between the user and the model.
+const (
+	RoleUser  = "user"
+	RoleModel = "model"
+)
+
+// Message is one turn of a conversation
+type Message struct {
+	Role    string
+	Content string
+}
+
+// Request is a conversation to continue. The last message is normally the
+// user's question.
+type Request struct {
+	System   string // Instructions and context given to the model up front
+	Messages []Message
+}
+
+// Provider generates a model's reply to a conversation
+type Provider interface {
+	Complete(ctx context.Context, req Request) (string, error)
+	// Stream calls emit with each piece of the reply as the model generates
+	// it. It stops early if ctx is cancelled or emit returns an error.
+	Stream(ctx context.Context, req Request, emit func(chunk string) error) error
+}
+
+// Embedder turns text into vectors whose cosine similarity reflects how
+// related the texts are
+type Embedder interface {
+	// Embed returns one vector per text, in order
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// EmbeddingModel names the model; vectors from different models can't
+	// be compared
+	EmbeddingModel() string
+}
+
+// Config selects and configures a provider
+type Config struct {
+	Provider       string // "gemini" (default), "openai", "ollama" or "fake"
+	Model          string // Provider-specific default if empty
+	EmbeddingModel string // Provider-specific default if empty
+	BaseURL        string // API root for "openai" and "ollama"
+	APIKey         string
+}
+
+// New creates the provider described by cfg. All providers also implement
+// Embedder.
+func New(ctx context.Context, cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case "", "gemini":
+		g, err := NewGemini(ctx, cfg.APIKey, cfg.Model, cfg.EmbeddingModel)
+		if err != nil {
+			return nil, err // Not a nil *Gemini in a non-nil Provider
+		}
+		return g, nil
+	case "openai":
+		return NewOpenAI(cfg.BaseURL, cfg.APIKey, cfg.Model, cfg.EmbeddingModel), nil
+	case "ollama":
+		return NewOllama(cfg.BaseURL, cfg.Model, cfg.EmbeddingModel), nil
+	case "fake":
+		return &Fake{}, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.Provider)
+	}
+}
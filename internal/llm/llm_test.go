@@ -0,0 +1,213 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testRequest = Request{
+	System: "You know about notes.",
+	Messages: []Message{
+		{Role: RoleUser, Content: "Hi"},
+		{Role: RoleModel, Content: "Hello!"},
+		{Role: RoleUser, Content: "What did I write?"},
+	},
+}
+
+// checkMessages asserts that a chat request carried testRequest
+func checkMessages(t *testing.T, messages []openAIMessage) {
+	t.Helper()
+	want := []openAIMessage{
+		{"system", "You know about notes."},
+		{"user", "Hi"},
+		{"assistant", "Hello!"},
+		{"user", "What did I write?"},
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("Expected %d messages, got %v", len(want), messages)
+	}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Errorf("Message %d: expected %v, got %v", i, want[i], messages[i])
+		}
+	}
+}
+
+func TestOpenAI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Expected bearer token, got %q", got)
+		}
+		var body struct {
+			Model    string          `json:"model"`
+			Messages []openAIMessage `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Model != "local-model" {
+			t.Errorf("Expected model local-model, got %q", body.Model)
+		}
+		checkMessages(t, body.Messages)
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"You wrote a lot."}}]}`))
+	}))
+	defer srv.Close()
+
+	p := NewOpenAI(srv.URL+"/v1/", "secret", "local-model", "")
+	answer, err := p.Complete(context.Background(), testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "You wrote a lot." {
+		t.Errorf("Unexpected answer %q", answer)
+	}
+}
+
+func TestOllama(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		var body struct {
+			Messages []openAIMessage `json:"messages"`
+			Stream   bool            `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Stream {
+			t.Error("Expected a non-streaming request")
+		}
+		checkMessages(t, body.Messages)
+		w.Write([]byte(`{"message":{"role":"assistant","content":"Mostly lists."},"done":true}`))
+	}))
+	defer srv.Close()
+
+	answer, err := NewOllama(srv.URL, "", "").Complete(context.Background(), testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "Mostly lists." {
+		t.Errorf("Unexpected answer %q", answer)
+	}
+}
+
+func TestProviderErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	if _, err := NewOllama(srv.URL, "missing", "").Complete(context.Background(), testRequest); err == nil {
+		t.Error("Expected an error for a failed request")
+	}
+	if _, err := New(context.Background(), Config{Provider: "unknown"}); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+}
+
+func collect(t *testing.T, p Provider) string {
+	t.Helper()
+	var chunks []string
+	err := p.Stream(context.Background(), testRequest, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Errorf("Expected several chunks, got %q", chunks)
+	}
+	return strings.Join(chunks, "")
+}
+
+func TestStreaming(t *testing.T) {
+	openai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{\"content\":\"You wrote \"}}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{\"content\":\"a lot.\"}}]}\n\n" +
+			"data: [DONE]\n\n"))
+	}))
+	defer openai.Close()
+	if got := collect(t, NewOpenAI(openai.URL, "", "", "")); got != "You wrote a lot." {
+		t.Errorf("Unexpected OpenAI stream %q", got)
+	}
+
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message":{"role":"assistant","content":"Mostly "},"done":false}` + "\n" +
+			`{"message":{"role":"assistant","content":"lists."},"done":false}` + "\n" +
+			`{"message":{"role":"assistant","content":""},"done":true}` + "\n"))
+	}))
+	defer ollama.Close()
+	if got := collect(t, NewOllama(ollama.URL, "", "")); got != "Mostly lists." {
+		t.Errorf("Unexpected Ollama stream %q", got)
+	}
+
+	if got := collect(t, &Fake{}); got != `Fake answer to "What did I write?"` {
+		t.Errorf("Unexpected fake stream %q", got)
+	}
+}
+
+func TestStreamOutlastsResponseTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Slow \"}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"answer.\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer srv.Close()
+
+	// Only waiting for the response to start is limited
+	o := NewOpenAI(srv.URL, "", "", "")
+	o.client = newHTTPClient(50 * time.Millisecond)
+	if got := collect(t, o); got != "Slow answer." {
+		t.Errorf("Expected the whole stream, got %q", got)
+	}
+}
+
+func TestEmbeddings(t *testing.T) {
+	openai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		// Out of order on purpose: results are matched by index
+		w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer openai.Close()
+
+	vectors, err := NewOpenAI(openai.URL, "", "", "").Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("Unexpected OpenAI embeddings %v", vectors)
+	}
+
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Model != "nomic-embed-text" || len(body.Input) != 1 {
+			t.Errorf("Unexpected embed request %+v", body)
+		}
+		w.Write([]byte(`{"embeddings":[[0.5,0.5]]}`))
+	}))
+	defer ollama.Close()
+
+	vectors, err = NewOllama(ollama.URL, "", "").Embed(context.Background(), []string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) != 1 || len(vectors[0]) != 2 {
+		t.Errorf("Unexpected Ollama embeddings %v", vectors)
+	}
+}
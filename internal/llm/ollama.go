@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOllamaBaseURL        = "http://localhost:11434"
+	defaultOllamaModel          = "llama3.2"
+	defaultOllamaEmbeddingModel = "nomic-embed-text"
+)
+
+// Ollama uses a local Ollama server's native chat API
+type Ollama struct {
+	baseURL        string
+	model          string
+	embeddingModel string
+	client         *http.Client
+}
+
+// NewOllama creates an Ollama provider. The models must already be pulled.
+func NewOllama(baseURL, model, embeddingModel string) *Ollama {
+	if baseURL == "" {
+		baseURL = defaultOllamaBaseURL
+	}
+	if model == "" {
+		model = defaultOllamaModel
+	}
+	if embeddingModel == "" {
+		embeddingModel = defaultOllamaEmbeddingModel
+	}
+	return &Ollama{
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		model:          model,
+		embeddingModel: embeddingModel,
+		client:         newHTTPClient(10 * time.Minute), // Local models can be slow to load
+	}
+}
+
+func (o *Ollama) Complete(ctx context.Context, req Request) (string, error) {
+	body := map[string]interface{}{
+		"model":    o.model,
+		"messages": chatMessages(req, "assistant"),
+		"stream":   false,
+	}
+	var resp struct {
+		Message openAIMessage `json:"message"`
+	}
+	if err := postJSON(ctx, o.client, o.baseURL+"/api/chat", nil, body, &resp); err != nil {
+		return "", err
+	}
+	if resp.Message.Content == "" {
+		return "", fmt.Errorf("empty response from model")
+	}
+	return resp.Message.Content, nil
+}
+
+// Stream reads the newline-delimited JSON objects of a streamed chat
+func (o *Ollama) Stream(ctx context.Context, req Request, emit func(chunk string) error) error {
+	body := map[string]interface{}{
+		"model":    o.model,
+		"messages": chatMessages(req, "assistant"),
+		"stream":   true,
+	}
+	resp, err := post(ctx, o.client, o.baseURL+"/api/chat", nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var chunk struct {
+			Message openAIMessage `json:"message"`
+			Done    bool          `json:"done"`
+			Error   string        `json:"error"`
+		}
+		if err := dec.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			if err := emit(chunk.Message.Content); err != nil {
+				return err
+			}
+		}
+		if chunk.Done {
+			return nil
+		}
+	}
+}
+
+func (o *Ollama) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body := map[string]interface{}{
+		"model": o.embeddingModel,
+		"input": texts,
+	}
+	var resp struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := postJSON(ctx, o.client, o.baseURL+"/api/embed", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Embeddings))
+	}
+	return resp.Embeddings, nil
+}
+
+func (o *Ollama) EmbeddingModel() string { return o.embeddingModel }
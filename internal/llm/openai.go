@@ -0,0 +1,216 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOpenAIBaseURL        = "https://api.openai.com/v1"
+	defaultOpenAIModel          = "gpt-4o-mini"
+	defaultOpenAIEmbeddingModel = "text-embedding-3-small"
+)
+
+// OpenAI uses the chat completions API, which is also served by vLLM,
+// llama.cpp, LM Studio and most other self-hosted model servers
+type OpenAI struct {
+	baseURL        string
+	apiKey         string
+	model          string
+	embeddingModel string
+	client         *http.Client
+}
+
+// NewOpenAI creates an OpenAI-compatible provider. baseURL is the API root,
+// e.g. "http://localhost:8000/v1"; apiKey may be empty for local servers.
+func NewOpenAI(baseURL, apiKey, model, embeddingModel string) *OpenAI {
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+	if model == "" {
+		model = defaultOpenAIModel
+	}
+	if embeddingModel == "" {
+		embeddingModel = defaultOpenAIEmbeddingModel
+	}
+	return &OpenAI{
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		apiKey:         apiKey,
+		model:          model,
+		embeddingModel: embeddingModel,
+		client:         newHTTPClient(5 * time.Minute),
+	}
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+func (o *OpenAI) Complete(ctx context.Context, req Request) (string, error) {
+	body := map[string]interface{}{
+		"model":    o.model,
+		"messages": chatMessages(req, "assistant"),
+	}
+	var resp struct {
+		Choices []struct {
+			Message openAIMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := postJSON(ctx, o.client, o.baseURL+"/chat/completions", o.headers(), body, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("empty response from model")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// Stream reads the server-sent events of a streamed chat completion
+func (o *OpenAI) Stream(ctx context.Context, req Request, emit func(chunk string) error) error {
+	body := map[string]interface{}{
+		"model":    o.model,
+		"messages": chatMessages(req, "assistant"),
+		"stream":   true,
+	}
+	resp, err := post(ctx, o.client, o.baseURL+"/chat/completions", o.headers(), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+		var event struct {
+			Choices []struct {
+				Delta openAIMessage `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("invalid stream event: %w", err)
+		}
+		if len(event.Choices) > 0 && event.Choices[0].Delta.Content != "" {
+			if err := emit(event.Choices[0].Delta.Content); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body := map[string]interface{}{
+		"model": o.embeddingModel,
+		"input": texts,
+	}
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := postJSON(ctx, o.client, o.baseURL+"/embeddings", o.headers(), body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+func (o *OpenAI) EmbeddingModel() string { return o.embeddingModel }
+
+func (o *OpenAI) headers() map[string]string {
+	if o.apiKey == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + o.apiKey}
+}
+
+// chatMessages converts a request to the system/user/assistant message list
+// used by OpenAI and Ollama, naming the model's role modelRole
+func chatMessages(req Request, modelRole string) []openAIMessage {
+	var messages []openAIMessage
+	if req.System != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: req.System})
+	}
+	for _, msg := range req.Messages {
+		role := "user"
+		if msg.Role == RoleModel {
+			role = modelRole
+		}
+		messages = append(messages, openAIMessage{Role: role, Content: msg.Content})
+	}
+	return messages
+}
+
+// newHTTPClient returns a client that waits at most responseTimeout for a
+// response to start. There's no limit on reading the body, so that a
+// streamed answer can take as long as it needs: requests are cancelled
+// through their context instead.
+func newHTTPClient(responseTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone() // Keeps its dial and TLS handshake timeouts
+	transport.ResponseHeaderTimeout = responseTimeout
+	return &http.Client{Transport: transport}
+}
+
+// postJSON sends body as JSON and decodes a successful response into out
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body, out interface{}) error {
+	resp, err := post(ctx, client, url, headers, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// post sends body as JSON and returns the response if it succeeded. The
+// caller must close its body.
+func post(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s: %s", url, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
@@ -0,0 +1,152 @@
+// Package markdown converts notes to and from Markdown files with YAML front
+// matter, the format of notebook exports and imports.
+package markdown
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Note is a note as a Markdown file
+type Note struct {
+	CreatedAt time.Time // Zero if the file doesn't say
+	Tags      []string
+	Images    []string // Paths of image files, relative to the note's file
+	Content   string
+}
+
+// Format renders a note as front matter followed by its content
+func Format(n Note) []byte {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "created_at: %s\n", n.CreatedAt.Format(time.RFC3339))
+	if len(n.Tags) > 0 {
+		fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(n.Tags, ", "))
+	}
+	if len(n.Images) > 0 {
+		b.WriteString("images:\n")
+		for _, img := range n.Images {
+			fmt.Fprintf(&b, "  - %s\n", img)
+		}
+	}
+	b.WriteString("---\n\n")
+	b.WriteString(n.Content)
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// Parse reads a Markdown file, with or without front matter. It understands
+// the simple YAML that Format writes: scalar values and lists written either
+// inline ("[a, b]") or one "- item" per line. Unknown keys are ignored.
+func Parse(data []byte) (Note, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	var n Note
+
+	frontMatter, content, ok := splitFrontMatter(text)
+	if !ok {
+		n.Content = strings.Trim(text, "\n")
+		return n, nil
+	}
+	n.Content = strings.Trim(content, "\n")
+
+	fields := parseFrontMatter(frontMatter)
+	if v := fields["created_at"]; len(v) > 0 {
+		createdAt, err := parseTime(v[0])
+		if err != nil {
+			return n, fmt.Errorf("invalid created_at %q", v[0])
+		}
+		n.CreatedAt = createdAt
+	} else {
+		// Keys other apps use, such as Obsidian templates, which may hold
+		// anything
+		for _, key := range []string{"created", "date"} {
+			if v := fields[key]; len(v) > 0 && n.CreatedAt.IsZero() {
+				n.CreatedAt, _ = parseTime(v[0])
+			}
+		}
+	}
+	n.Tags = fields["tags"]
+	n.Images = fields["images"]
+	return n, nil
+}
+
+// splitFrontMatter separates the front matter between the leading "---"
+// lines from the rest of the file
+func splitFrontMatter(text string) (frontMatter, content string, ok bool) {
+	rest, ok := strings.CutPrefix(text, "---\n")
+	if !ok {
+		return "", text, false
+	}
+	if after, ok := strings.CutPrefix(rest, "---\n"); ok {
+		return "", after, true // Empty front matter
+	}
+	frontMatter, content, ok = strings.Cut(rest, "\n---\n")
+	if !ok {
+		// Closing delimiter at the end of the file
+		if frontMatter, ok = strings.CutSuffix(rest, "\n---"); !ok {
+			return "", text, false
+		}
+	}
+	return frontMatter, content, true
+}
+
+// parseFrontMatter returns each key's values: one for a scalar, any number
+// for a list
+func parseFrontMatter(frontMatter string) map[string][]string {
+	fields := make(map[string][]string)
+	var listKey string
+	for _, line := range strings.Split(frontMatter, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok && listKey != "" {
+			fields[listKey] = append(fields[listKey], unquote(item))
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		listKey = ""
+		switch {
+		case value == "":
+			listKey = key // Items follow on their own lines
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			var items []string
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = unquote(strings.TrimSpace(item)); item != "" {
+					items = append(items, item)
+				}
+			}
+			fields[key] = items
+		default:
+			fields[key] = []string{unquote(value)}
+		}
+	}
+	return fields
+}
+
+// unquote strips matching single or double quotes around a YAML value
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// timeLayouts are the date formats accepted in front matter
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// parseTime parses a front matter date; ones without a timezone are local
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", s)
+}
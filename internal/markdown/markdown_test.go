@@ -0,0 +1,59 @@
+package markdown
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFormatAndParse(t *testing.T) {
+	note := Note{
+		CreatedAt: time.Date(2024, 3, 5, 9, 30, 0, 0, time.FixedZone("CET", 3600)),
+		Tags:      []string{"work", "ideas"},
+		Images:    []string{"images/1_2_3.jpg", "images/1_2_4.png"},
+		Content:   "# Plan\n\n---\n\nShip it #work #ideas",
+	}
+	got, err := Parse(Format(note))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(note.CreatedAt) || !slices.Equal(got.Tags, note.Tags) || !slices.Equal(got.Images, note.Images) || got.Content != note.Content {
+		t.Errorf("Round trip changed the note:\n got %+v\nwant %+v", got, note)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want Note
+	}{
+		{"no front matter", "Just text\n", Note{Content: "Just text"}},
+		{"block lists and quotes", "---\r\ncreated_at: \"2024-01-02\"\r\ntags:\r\n  - 'a'\r\n  - b\r\ntitle: ignored\r\n---\r\nBody\r\n", Note{
+			CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local),
+			Tags:      []string{"a", "b"},
+			Content:   "Body",
+		}},
+		{"obsidian date", "---\ndate: 2024-05-06 07:08\ncreated: someday\n---\nBody", Note{
+			CreatedAt: time.Date(2024, 5, 6, 7, 8, 0, 0, time.Local),
+			Content:   "Body",
+		}},
+		{"empty front matter", "---\n---\nBody", Note{Content: "Body"}},
+		{"unclosed front matter", "---\nnot front matter", Note{Content: "---\nnot front matter"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.CreatedAt.Equal(tt.want.CreatedAt) || !slices.Equal(got.Tags, tt.want.Tags) || got.Content != tt.want.Content {
+				t.Errorf("Got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Parse([]byte("---\ncreated_at: yesterday\n---\n")); err == nil {
+		t.Error("Expected an error for an invalid date")
+	}
+}
@@ -4,12 +4,28 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"tracky/internal/auth"
+	"tracky/internal/models"
 )
 
-// Auth validates the signed cookie and adds user ID to context
-func Auth(next http.Handler) http.Handler {
+// touchInterval limits how often a session's or token's last-used time is
+// written, so that every request doesn't cost a database write
+const touchInterval = time.Minute
+
+// SessionStore looks up the sessions and API tokens behind requests
+type SessionStore interface {
+	GetSessionByToken(tokenHash string) (models.Session, error)
+	TouchSession(sessionID int, lastSeenAt time.Time) error
+	GetAPITokenByHash(tokenHash string) (models.APIToken, error)
+	TouchAPIToken(tokenID int, lastUsedAt time.Time) error
+}
+
+// Auth authenticates requests by an "Authorization: Bearer" API token or the
+// session cookie and adds the user ID and the session or token ID to the
+// context. Read-only tokens may only make GET and HEAD requests.
+func Auth(sessions SessionStore, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip auth for public endpoints
 		if isPublicEndpoint(r.URL.Path) {
@@ -17,34 +33,63 @@ func Auth(next http.Handler) http.Handler {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
+		if header := r.Header.Get("Authorization"); header != "" {
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			apiToken, err := sessions.GetAPITokenByHash(auth.HashToken(token))
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if apiToken.Scope != models.ScopeWrite && r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.Error(w, "Token is read-only", http.StatusForbidden)
+				return
+			}
+			if now := time.Now(); apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > touchInterval {
+				sessions.TouchAPIToken(apiToken.ID, now)
+			}
+
+			ctx := context.WithValue(r.Context(), auth.UserIDKey, apiToken.UserID)
+			ctx = context.WithValue(ctx, auth.APITokenIDKey, apiToken.ID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		cookie, err := r.Cookie(auth.SessionCookieName)
 		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		userID, err := auth.ValidateSignedCookie(cookie.Value)
+		session, err := sessions.GetSessionByToken(auth.HashToken(cookie.Value))
 		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if now := time.Now(); now.Sub(session.LastSeenAt) > touchInterval {
+			sessions.TouchSession(session.ID, now)
+		}
 
-		// Add user ID to context
-		ctx := context.WithValue(r.Context(), auth.UserIDKey, userID)
+		// Add user and session IDs to context
+		ctx := context.WithValue(r.Context(), auth.UserIDKey, session.UserID)
+		ctx = context.WithValue(ctx, auth.SessionIDKey, session.ID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
 func isPublicEndpoint(path string) bool {
 	// Exact match paths
-	exactPaths := []string{"/", "/api/signup", "/api/login"}
+	exactPaths := []string{"/", "/api/signup", "/api/login", "/api/login/totp", "/api/oidc/login", "/api/oidc/callback"}
 	for _, p := range exactPaths {
 		if path == p {
 			return true
 		}
 	}
 	// Prefix match paths
-	prefixPaths := []string{"/static/"}
+	prefixPaths := []string{"/static/", "/s/"} // Share links check their own token
 	for _, p := range prefixPaths {
 		if strings.HasPrefix(path, p) {
 			return true
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tracky/internal/auth"
+)
+
+func TestCSRF(t *testing.T) {
+	handler := CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// Safe requests get a token cookie
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusOK || len(cookies) != 1 || cookies[0].Name != CSRFCookieName || cookies[0].HttpOnly {
+		t.Fatalf("Expected a script-readable token cookie, got %v", cookies)
+	}
+	token := cookies[0].Value
+
+	tests := []struct {
+		name     string
+		header   string
+		origin   string
+		apiToken bool
+		want     int
+	}{
+		{"matching token", token, "", false, http.StatusOK},
+		{"same origin", token, "http://example.com", false, http.StatusOK},
+		{"missing token", "", "", false, http.StatusForbidden},
+		{"wrong token", "forged", "", false, http.StatusForbidden},
+		{"cross origin", token, "https://evil.example", false, http.StatusForbidden},
+		{"API token", "", "", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/notes", nil)
+			req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: token})
+			if tt.header != "" {
+				req.Header.Set(CSRFHeaderName, tt.header)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.apiToken {
+				req = req.WithContext(context.WithValue(req.Context(), auth.APITokenIDKey, 1))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %v, got %v", tt.want, w.Code)
+			}
+		})
+	}
+}
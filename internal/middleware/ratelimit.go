@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tracky/internal/auth"
+)
+
+// Limit allows Requests per Per, in bursts of up to Requests. The zero Limit
+// is unlimited.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// rateRule limits a set of paths, which share their buckets
+type rateRule struct {
+	paths   map[string]bool
+	perIP   Limit
+	perUser Limit
+}
+
+// bucket is a token bucket: it refills continuously and each request takes
+// one token
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// RateLimiter throttles requests with in-memory token buckets per client IP
+// and per signed-in user. Limits apply per server, not across a cluster.
+type RateLimiter struct {
+	mu        sync.Mutex
+	rules     []rateRule
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time // Overridden in tests
+}
+
+// NewRateLimiter creates a rate limiter with no limits; add them with Route
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Route limits requests to the given exact paths, per client IP and per
+// signed-in user. Requests to any of the paths draw from the same buckets.
+func (rl *RateLimiter) Route(perIP, perUser Limit, paths ...string) {
+	rule := rateRule{paths: make(map[string]bool), perIP: perIP, perUser: perUser}
+	for _, p := range paths {
+		rule.paths[p] = true
+	}
+	rl.rules = append(rl.rules, rule)
+}
+
+// Middleware rejects requests over their route's limits with 429 Too Many
+// Requests and a Retry-After header. It must run after Auth to limit users.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, rule := range rl.rules {
+			if !rule.paths[r.URL.Path] {
+				continue
+			}
+			keys := map[string]Limit{fmt.Sprintf("%d|ip|%s", i, ClientIP(r)): rule.perIP}
+			if userID, ok := auth.GetUserIDFromContext(r.Context()); ok {
+				keys[fmt.Sprintf("%d|user|%d", i, userID)] = rule.perUser
+			}
+			if wait := rl.take(keys); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// take spends a token from every bucket, or none if any is empty, and
+// returns how long to wait before retrying (0 if allowed)
+func (rl *RateLimiter) take(keys map[string]Limit) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	var wait time.Duration
+	var buckets []*bucket
+	for key, limit := range keys {
+		if limit.Requests <= 0 || limit.Per <= 0 {
+			continue
+		}
+		b, ok := rl.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(limit.Requests), updated: now, limit: limit}
+			rl.buckets[key] = b
+		}
+		b.refill(now)
+		if b.tokens < 1 {
+			perToken := limit.Per / time.Duration(limit.Requests)
+			wait = max(wait, time.Duration((1-b.tokens)*float64(perToken)))
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0
+}
+
+func (b *bucket) refill(now time.Time) {
+	rate := float64(b.limit.Requests) / b.limit.Per.Seconds()
+	b.tokens = min(float64(b.limit.Requests), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+}
+
+// sweep forgets buckets that have refilled completely, at most once a minute
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	for key, b := range rl.buckets {
+		if now.Sub(b.updated) >= b.limit.Per {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// ClientIP returns the address the request came from, which RealIP sets to
+// the client's for requests through a trusted proxy
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ParseProxies parses a comma-separated list of IP addresses and CIDR ranges
+func ParseProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", p)
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// RealIP replaces the address of requests from the trusted proxies with the
+// client's from X-Forwarded-For, so that ClientIP and the rate limits see the
+// client rather than the proxy. The header is read from the right, skipping
+// the trusted proxies, since clients can put anything at its start. The
+// header is ignored on requests from any other address.
+func RealIP(trusted []*net.IPNet, next http.Handler) http.Handler {
+	isTrusted := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		return ip != nil && slices.ContainsFunc(trusted, func(n *net.IPNet) bool { return n.Contains(ip) })
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 && isTrusted(ClientIP(r)) {
+			hops := strings.Split(strings.Join(forwarded, ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if net.ParseIP(hop) == nil {
+					break // Nothing before a malformed entry can be trusted
+				}
+				r.RemoteAddr = net.JoinHostPort(hop, "0")
+				if !isTrusted(hop) {
+					break
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tracky/internal/auth"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rl := NewRateLimiter()
+	rl.now = func() time.Time { return now }
+	rl.Route(Limit{Requests: 3, Per: time.Minute}, Limit{}, "/api/login")
+	rl.Route(Limit{}, Limit{Requests: 2, Per: time.Hour}, "/api/analysis", "/api/analysis/stream")
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(path, ip string, userID int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if userID != 0 {
+			req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Per IP
+	for i := range 3 {
+		if w := do("/api/login", "10.0.0.1", 0); w.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to be allowed, got %v", i+1, w.Code)
+		}
+	}
+	w := do("/api/login", "10.0.0.1", 0)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "20" {
+		t.Errorf("Expected 429 retrying after 20s, got %v with Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := do("/api/login", "10.0.0.2", 0); w.Code != http.StatusOK {
+		t.Errorf("Expected another IP to be allowed, got %v", w.Code)
+	}
+	now = now.Add(20 * time.Second)
+	if w := do("/api/login", "10.0.0.1", 0); w.Code != http.StatusOK {
+		t.Errorf("Expected a token after refilling, got %v", w.Code)
+	}
+
+	// Per user, shared across the rule's paths and IPs
+	do("/api/analysis", "10.0.0.1", 7)
+	do("/api/analysis/stream", "10.0.0.2", 7)
+	if w := do("/api/analysis", "10.0.0.3", 7); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the user's budget to be spent, got %v", w.Code)
+	}
+	if w := do("/api/analysis", "10.0.0.1", 8); w.Code != http.StatusOK {
+		t.Errorf("Expected another user to be allowed, got %v", w.Code)
+	}
+
+	// Unlisted paths aren't limited
+	for range 10 {
+		if w := do("/api/notes", "10.0.0.1", 7); w.Code != http.StatusOK {
+			t.Fatalf("Expected unlimited path to be allowed, got %v", w.Code)
+		}
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.1, 192.168.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseProxies("10.0.0.1,nonsense"); err == nil {
+		t.Error("Expected an invalid address to be rejected")
+	}
+
+	var got string
+	handler := RealIP(proxies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = ClientIP(r) }))
+	tests := []struct {
+		remote, forwarded, want string
+	}{
+		{"10.0.0.1", "203.0.113.7", "203.0.113.7"},
+		{"10.0.0.1", "6.6.6.6, 203.0.113.7, 192.168.1.1", "203.0.113.7"}, // Spoofed first entry
+		{"10.0.0.1", "", "10.0.0.1"},
+		{"10.0.0.1", "garbage", "10.0.0.1"},
+		{"10.0.0.1", "192.168.1.1", "192.168.1.1"}, // Only proxies
+		{"203.0.113.9", "6.6.6.6", "203.0.113.9"},  // Not from a proxy
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote + ":1234"
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tt.want {
+			t.Errorf("From %s forwarding %q: got %s, want %s", tt.remote, tt.forwarded, got, tt.want)
+		}
+	}
+}
@@ -10,9 +10,25 @@ type User struct {
 
 type Notebook struct {
 	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
+	UserID    int       `json:"user_id"` // The owner
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
+	Role      string    `json:"role"` // The requesting user's role
+}
+
+// Notebook member roles
+const (
+	RoleOwner  = "owner"  // Created the notebook; can delete it and manage members
+	RoleEditor = "editor" // Can add, change and delete notes and images
+	RoleViewer = "viewer" // Read-only
+)
+
+// NotebookMember is a user with access to a notebook
+type NotebookMember struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"` // RoleOwner, RoleEditor or RoleViewer
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type NoteImage struct {
@@ -29,9 +45,183 @@ type Note struct {
 	Content    string      `json:"content"`
 	CreatedAt  time.Time   `json:"created_at"`
 	Images     []NoteImage `json:"images"`
+	Tags       []string    `json:"tags"`
+}
+
+// NoteRevision is one saved version of a note's content
+type NoteRevision struct {
+	ID        int       `json:"id"`
+	NoteID    int       `json:"note_id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TrashItem is a deleted notebook, note or image awaiting purge
+type TrashItem struct {
+	Type       string    `json:"type"` // "notebook", "note" or "image"
+	ID         int       `json:"id"`
+	NotebookID int       `json:"notebook_id,omitempty"`
+	NoteID     int       `json:"note_id,omitempty"`
+	Preview    string    `json:"preview"` // Notebook name, note content or image filename
+	DeletedAt  time.Time `json:"deleted_at"`
+	PurgeAt    time.Time `json:"purge_at"`
+}
+
+// Tag is a #hashtag with the number of the user's notes using it
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// NoteCursor marks a position in a notebook's notes, which are listed newest
+// first by (created_at, id)
+type NoteCursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// NoteListOptions controls which notes GetNotesPage returns
+type NoteListOptions struct {
+	Limit int         // <= 0 returns every matching note
+	After *NoteCursor // nil starts at the newest note
+	Tag   string      // Only notes tagged with this, if set
+}
+
+// NotePage is one page of a paginated note listing
+type NotePage struct {
+	Notes      []Note `json:"notes"`
+	NextCursor string `json:"next_cursor,omitempty"` // Empty on the last page
 }
 
 type ChatMessage struct {
 	Role    string `json:"role"` // "user" or "model"
 	Content string `json:"content"`
 }
+
+// Session is a signed-in browser or device. Only a hash of its token is
+// stored.
+type Session struct {
+	ID         int       `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // The session making the request
+	UserID     int       `json:"-"`
+}
+
+// API token scopes
+const (
+	ScopeRead  = "read"  // GET requests only
+	ScopeWrite = "write" // Everything the user can do
+)
+
+// APIToken is a named personal token for scripts, sent as
+// "Authorization: Bearer <token>". Only a hash of it is stored.
+type APIToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`           // ScopeRead or ScopeWrite
+	Token      string     `json:"token,omitempty"` // Only returned when created
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"` // nil never expires
+	UserID     int        `json:"-"`
+}
+
+// ShareLink is a public, read-only link to a notebook or a single note, at
+// /s/<token>. Only a hash of the token is stored.
+type ShareLink struct {
+	ID         int        `json:"id"`
+	NotebookID int        `json:"notebook_id,omitempty"` // Set when sharing a whole notebook
+	NoteID     int        `json:"note_id,omitempty"`     // Set when sharing one note
+	Token      string     `json:"token,omitempty"`       // Only returned when created
+	URL        string     `json:"url,omitempty"`         // Only returned when created
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"` // nil never expires
+	UserID     int        `json:"-"`
+}
+
+// Digest cadences
+const (
+	DigestWeekly  = "weekly"
+	DigestMonthly = "monthly"
+)
+
+// Digest is a user's schedule for AI summaries of their recent notes, which
+// are saved as notes in their "Digests" notebook
+type Digest struct {
+	ID           int       `json:"id"`
+	Cadence      string    `json:"cadence"` // DigestWeekly or DigestMonthly
+	Prompt       string    `json:"prompt"`
+	AllNotebooks bool      `json:"all_notebooks"` // Every notebook but Digests, including ones added later
+	NotebookIDs  []int     `json:"notebook_ids"`  // The notebooks otherwise; the user must own them
+	LastRunAt    time.Time `json:"last_run_at"`   // Creation time until the first run
+	CreatedAt    time.Time `json:"created_at"`
+	UserID       int       `json:"-"`
+	Failures     int       `json:"-"` // Failed runs in a row this period
+}
+
+// Import formats
+const (
+	ImportENEX     = "enex"     // An Evernote export of one notebook
+	ImportObsidian = "obsidian" // A zipped Obsidian vault
+)
+
+// Import job statuses
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// ImportJob is a background import of notes from another app
+type ImportJob struct {
+	ID          int           `json:"id"`
+	Format      string        `json:"format"` // ImportENEX or ImportObsidian
+	Status      string        `json:"status"` // ImportRunning, ImportDone or ImportFailed
+	Total       int           `json:"total"`  // Notes found, once the file has been read
+	Processed   int           `json:"processed"`
+	Imported    int           `json:"imported"`
+	NotebookIDs []int         `json:"notebook_ids"` // Created by the import
+	Skipped     []SkippedItem `json:"skipped"`
+	Error       string        `json:"error,omitempty"` // Why the import failed
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"` // When progress was last made
+	UserID      int           `json:"-"`
+}
+
+// SkippedItem is a note, image or file an import left out
+type SkippedItem struct {
+	Item   string `json:"item"`
+	Reason string `json:"reason"`
+}
+
+// Conversation is a saved analysis chat about one or more notebooks
+type Conversation struct {
+	ID          int           `json:"id"`
+	NotebookID  int           `json:"notebook_id"`  // Where the conversation is listed
+	NotebookIDs []int         `json:"notebook_ids"` // Every notebook it covers, starting with NotebookID
+	Title       string        `json:"title"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"` // When the last message was added
+	Messages    []ChatMessage `json:"messages,omitempty"`
+}
+
+// SearchFilters narrows a full-text search
+type SearchFilters struct {
+	NotebookID  int       // 0 searches all of the user's notebooks
+	NotebookIDs []int     // Only these notebooks, if set (combined with NotebookID)
+	Tag         string    // Only notes tagged with this, if set
+	Start, End  time.Time // Only notes created within the range; zero is unbounded
+	Limit       int
+}
+
+// SearchResult is a note matching a search query
+type SearchResult struct {
+	Note
+	NotebookName string  `json:"notebook_name"`
+	Snippet      string  `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+	Rank         float64 `json:"rank"`    // Higher is more relevant
+}
@@ -0,0 +1,91 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"tracky/internal/oidc/oidctest"
+)
+
+func TestLoginFlow(t *testing.T) {
+	idp := oidctest.NewServer("tracky", "secret")
+	defer idp.Close()
+	idp.Subject = "alice-123"
+	idp.PreferredUsername = "alice"
+
+	ctx := context.Background()
+	p, err := Discover(ctx, Config{
+		Issuer:       idp.URL,
+		ClientID:     "tracky",
+		ClientSecret: "secret",
+		RedirectURL:  "http://tracky.test/api/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := NewLoginState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL := p.AuthCodeURL(state)
+	if !strings.Contains(authURL, "code_challenge_method=S256") || strings.Contains(authURL, state.Verifier) {
+		t.Fatalf("Expected a PKCE challenge without the verifier, got %s", authURL)
+	}
+
+	// Follow the provider's redirect back to the app without fetching it
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	if callback.Query().Get("state") != state.State {
+		t.Fatalf("Expected state to round-trip, got %s", callback)
+	}
+	code := callback.Query().Get("code")
+
+	// A wrong verifier is refused
+	wrong := state
+	wrong.Verifier = "wrong"
+	if _, err := p.Exchange(ctx, code, wrong); err == nil {
+		t.Error("Expected exchange with the wrong verifier to fail")
+	}
+
+	resp, _ = client.Get(authURL)
+	resp.Body.Close()
+	callback, _ = url.Parse(resp.Header.Get("Location"))
+	claims, err := p.Exchange(ctx, callback.Query().Get("code"), state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "alice-123" || claims.PreferredUsername != "alice" || claims.Issuer != idp.URL {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	// The nonce ties the token to this login
+	resp, _ = client.Get(authURL)
+	resp.Body.Close()
+	callback, _ = url.Parse(resp.Header.Get("Location"))
+	other := state
+	other.Nonce = "other"
+	if _, err := p.Exchange(ctx, callback.Query().Get("code"), other); err == nil {
+		t.Error("Expected a nonce mismatch to fail")
+	}
+}
+
+func TestDiscoverErrors(t *testing.T) {
+	idp := oidctest.NewServer("tracky", "")
+	defer idp.Close()
+
+	if _, err := Discover(context.Background(), Config{Issuer: idp.URL, ClientID: "tracky"}); err == nil {
+		t.Error("Expected a missing redirect URL to fail")
+	}
+	if _, err := Discover(context.Background(), Config{Issuer: idp.URL + "/other", ClientID: "tracky", RedirectURL: "http://x"}); err == nil {
+		t.Error("Expected an unknown issuer to fail")
+	}
+}
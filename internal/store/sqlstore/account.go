@@ -0,0 +1,165 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (s *SQLStore) GetUserByID(userID int) (string, string, error) {
+	var username, hash string
+	err := s.db.QueryRow(s.rebind("SELECT username, password_hash FROM users WHERE id = ?"), userID).Scan(&username, &hash)
+	return username, hash, err
+}
+
+func (s *SQLStore) UpdatePassword(userID int, passwordHash string) error {
+	result, err := s.db.Exec(s.rebind("UPDATE users SET password_hash = ? WHERE id = ?"), passwordHash, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *SQLStore) GetUserByIdentity(issuer, subject string) (int, error) {
+	var userID int
+	err := s.db.QueryRow(s.rebind("SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?"), issuer, subject).Scan(&userID)
+	return userID, err
+}
+
+// CreateIdentityUser creates a user who signs in through an identity
+// provider. Their password hash is empty, which no password matches.
+func (s *SQLStore) CreateIdentityUser(username, issuer, subject string) (int64, error) {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	if s.dbType == Postgres {
+		err = tx.QueryRow(s.rebind("INSERT INTO users (username, password_hash) VALUES (?, '') RETURNING id"), username).Scan(&userID)
+	} else {
+		var result sql.Result
+		result, err = tx.Exec(s.rebind("INSERT INTO users (username, password_hash) VALUES (?, '')"), username)
+		if err == nil {
+			userID, err = result.LastInsertId()
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(s.rebind("INSERT INTO user_identities (user_id, issuer, subject, created_at) VALUES (?, ?, ?, ?)"), userID, issuer, subject, time.Now()); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+func (s *SQLStore) GetLockedUntil(userID int) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow(s.rebind("SELECT locked_until FROM users WHERE id = ? AND locked_until > ?"), userID, time.Now()).Scan(&lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return lockedUntil.Time, err
+}
+
+// RecordFailedLogin counts a failed sign-in attempt. The maxFailures'th
+// failure in a row locks the account for the lockout period and starts the
+// count again.
+func (s *SQLStore) RecordFailedLogin(userID, maxFailures int, lockout time.Duration) (time.Time, error) {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.rebind("UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ?"), userID); err != nil {
+		return time.Time{}, err
+	}
+	var failures int
+	if err := tx.QueryRow(s.rebind("SELECT failed_logins FROM users WHERE id = ?"), userID).Scan(&failures); err != nil {
+		return time.Time{}, err
+	}
+
+	var lockedUntil time.Time
+	if failures >= maxFailures {
+		lockedUntil = time.Now().Add(lockout)
+		if _, err := tx.Exec(s.rebind("UPDATE users SET failed_logins = 0, locked_until = ? WHERE id = ?"), lockedUntil, userID); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return lockedUntil, tx.Commit()
+}
+
+func (s *SQLStore) ResetFailedLogins(userID int) error {
+	_, err := s.db.Exec(s.rebind("UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?"), userID)
+	return err
+}
+
+// DeleteUser permanently deletes a user with everything they own, trashed or
+// not, including other members' notes in their notebooks and their own notes
+// in notebooks shared with them, and returns the filenames of the deleted
+// images so the files can be removed
+func (s *SQLStore) DeleteUser(userID int) ([]string, error) {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	userNotebooks := "SELECT id FROM notebooks WHERE user_id = ?"
+	userNotes := "SELECT id FROM notes WHERE user_id = ? OR notebook_id IN (" + userNotebooks + ")"
+	userConversations := "SELECT id FROM conversations WHERE user_id = ? OR notebook_id IN (" + userNotebooks + ")"
+
+	rows, err := tx.Query(s.rebind("SELECT filename FROM note_images WHERE note_id IN ("+userNotes+")"), userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var filenames []string
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		filenames = append(filenames, filename)
+	}
+	rows.Close()
+
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM note_images WHERE note_id IN (" + userNotes + ")", []interface{}{userID, userID}},
+		{"DELETE FROM note_revisions WHERE note_id IN (" + userNotes + ")", []interface{}{userID, userID}},
+		{"DELETE FROM note_tags WHERE note_id IN (" + userNotes + ")", []interface{}{userID, userID}},
+		{"DELETE FROM note_embeddings WHERE note_id IN (" + userNotes + ")", []interface{}{userID, userID}},
+		{"DELETE FROM share_links WHERE user_id = ? OR note_id IN (" + userNotes + ") OR notebook_id IN (" + userNotebooks + ")", []interface{}{userID, userID, userID, userID}},
+		{"DELETE FROM notes WHERE id IN (" + userNotes + ")", []interface{}{userID, userID}},
+		{"DELETE FROM conversation_messages WHERE conversation_id IN (" + userConversations + ")", []interface{}{userID, userID}},
+		{"DELETE FROM conversations WHERE id IN (" + userConversations + ")", []interface{}{userID, userID}},
+		{"DELETE FROM digest_notebooks WHERE digest_id IN (SELECT id FROM digests WHERE user_id = ?) OR notebook_id IN (" + userNotebooks + ")", []interface{}{userID, userID}},
+		{"DELETE FROM digests WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM notebook_members WHERE user_id = ? OR notebook_id IN (" + userNotebooks + ")", []interface{}{userID, userID}},
+		{"DELETE FROM notebooks WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM tags WHERE user_id = ? OR id NOT IN (SELECT tag_id FROM note_tags)", []interface{}{userID}},
+		{"DELETE FROM sessions WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM login_challenges WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM recovery_codes WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM user_identities WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM api_tokens WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM import_jobs WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM users WHERE id = ?", []interface{}{userID}},
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(s.rebind(stmt.query), stmt.args...); err != nil {
+			return nil, err
+		}
+	}
+	return filenames, tx.Commit()
+}
@@ -0,0 +1,84 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	"tracky/internal/models"
+)
+
+func (s *SQLStore) CreateAPIToken(userID int, name, tokenHash, scope string, expiresAt *time.Time) (int64, error) {
+	var expires sql.NullTime
+	if expiresAt != nil {
+		expires = sql.NullTime{Time: expiresAt.Local(), Valid: true}
+	}
+	query := "INSERT INTO api_tokens (user_id, name, token_hash, scope, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)"
+	args := []interface{}{userID, name, tokenHash, scope, time.Now(), expires}
+
+	if s.dbType == Postgres {
+		var id int64
+		err := s.db.QueryRow(s.rebind(query+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+	result, err := s.db.Exec(s.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *SQLStore) GetAPITokenByHash(tokenHash string) (models.APIToken, error) {
+	row := s.db.QueryRow(s.rebind("SELECT id, user_id, name, scope, created_at, last_used_at, expires_at FROM api_tokens WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > ?)"), tokenHash, time.Now())
+	return scanAPIToken(row)
+}
+
+func (s *SQLStore) TouchAPIToken(tokenID int, lastUsedAt time.Time) error {
+	_, err := s.db.Exec(s.rebind("UPDATE api_tokens SET last_used_at = ? WHERE id = ?"), lastUsedAt.Local(), tokenID)
+	return err
+}
+
+// GetAPITokens lists all of the user's tokens, including expired ones, newest
+// first
+func (s *SQLStore) GetAPITokens(userID int) ([]models.APIToken, error) {
+	rows, err := s.db.Query(s.rebind("SELECT id, user_id, name, scope, created_at, last_used_at, expires_at FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC"), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *SQLStore) DeleteAPIToken(tokenID, userID int) error {
+	result, err := s.db.Exec(s.rebind("DELETE FROM api_tokens WHERE id = ? AND user_id = ?"), tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (models.APIToken, error) {
+	var t models.APIToken
+	var lastUsed, expires sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.CreatedAt, &lastUsed, &expires); err != nil {
+		return t, err
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	if expires.Valid {
+		t.ExpiresAt = &expires.Time
+	}
+	return t, nil
+}
@@ -0,0 +1,253 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// backupTables are the tables in a backup, each after the tables it refers
+// to. Derived data is left out: note embeddings, which the indexer rebuilds,
+// and the search indexes, which the database maintains itself.
+var backupTables = []string{
+	"users", "notebooks", "notes", "note_images", "tags", "note_tags",
+	"note_revisions", "conversations", "conversation_messages", "digests",
+	"digest_notebooks", "sessions", "api_tokens", "recovery_codes",
+	"login_challenges", "user_identities", "notebook_members", "share_links",
+	"import_jobs",
+}
+
+// derivedColumns are generated by the database and can't be restored
+var derivedColumns = map[string]bool{"search_vector": true}
+
+// DumpWriter receives the tables and rows of a database dump. Values are
+// int64, float64, bool, string, []byte, time.Time (local) or nil.
+type DumpWriter interface {
+	WriteTable(name string, columns []string) error // Starts the next table
+	WriteRow(values []interface{}) error
+}
+
+// DumpReader supplies the tables and rows of a dump being restored, with
+// values of the same types as DumpWriter receives
+type DumpReader interface {
+	NextTable() (name string, columns []string, err error) // io.EOF after the last table
+	NextRow() ([]interface{}, error)                       // io.EOF after the table's last row
+}
+
+// Dump writes every table in a backup from a single read transaction, so
+// that the rows are consistent with each other. It returns the schema
+// version and the blob keys of all images, including trashed ones. The
+// database must be fully migrated.
+func (s *SQLStore) Dump(ctx context.Context, w DumpWriter) (int, []string, error) {
+	var opts *sql.TxOptions
+	if s.dbType == Postgres {
+		// One snapshot for every query, as pg_dump takes
+		opts = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+	}
+	tx, err := s.db.BeginTx(ctx, opts)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, nil, err
+	}
+	if version != latestVersion() {
+		return 0, nil, fmt.Errorf("database schema is at version %d, not %d: run the same version of tracky migrate up first", version, latestVersion())
+	}
+
+	for _, table := range backupTables {
+		if err := s.dumpTable(ctx, tx, table, w); err != nil {
+			return 0, nil, fmt.Errorf("dumping %s: %w", table, err)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT filename FROM note_images ORDER BY filename")
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	var blobs []string
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			return 0, nil, err
+		}
+		blobs = append(blobs, filename)
+	}
+	return version, blobs, rows.Err()
+}
+
+func (s *SQLStore) dumpTable(ctx context.Context, tx *sql.Tx, table string, w DumpWriter) error {
+	rows, err := tx.QueryContext(ctx, "SELECT * FROM "+table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	allColumns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for _, c := range allColumns {
+		if !derivedColumns[c] {
+			columns = append(columns, c)
+		}
+	}
+	if err := w.WriteTable(table, columns); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(allColumns))
+	ptrs := make([]interface{}, len(allColumns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		row := make([]interface{}, 0, len(columns))
+		for i, c := range allColumns {
+			if !derivedColumns[c] {
+				row = append(row, s.dumpValue(values[i]))
+			}
+		}
+		if err := w.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// dumpValue normalizes a scanned value to the types DumpWriter documents
+func (s *SQLStore) dumpValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		if s.dbType == Postgres {
+			// TIMESTAMP columns hold the server's local time without a zone
+			return time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), time.Local)
+		}
+		return v.Local()
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	}
+	return v
+}
+
+// Restore loads a dump made at schema version into an empty database. The
+// database is migrated to that version first, so that the dump's rows fit,
+// and to the latest version afterwards, so that later migrations convert
+// them. A database already migrated past the dump's version is refused,
+// since those migrations wouldn't run again. The rows are loaded in one
+// transaction.
+func (s *SQLStore) Restore(ctx context.Context, version int, r DumpReader) error {
+	if version > latestVersion() {
+		return fmt.Errorf("the backup's schema version %d is newer than this build's %d", version, latestVersion())
+	}
+	statuses, err := s.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		if st.AppliedAt != nil && st.Version > version {
+			return fmt.Errorf("the database is already at schema version %d, past the backup's %d: restore into a new database", st.Version, version)
+		}
+	}
+	if err := s.migrateUpTo(version); err != nil {
+		return err
+	}
+
+	for _, table := range backupTables {
+		// Tables added after the backup's version don't exist yet
+		var n int
+		err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n)
+		if err == nil && n > 0 {
+			return fmt.Errorf("the database isn't empty: %s has %d rows", table, n)
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for {
+		table, columns, err := r.NextTable()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.restoreTable(ctx, tx, table, columns, r); err != nil {
+			return fmt.Errorf("restoring %s: %w", table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.MigrateUp()
+}
+
+func (s *SQLStore) restoreTable(ctx context.Context, tx *sql.Tx, table string, columns []string, r DumpReader) error {
+	// Names come from the backup, so only known ones go into the SQL
+	if !slices.Contains(backupTables, table) {
+		return errors.New("unknown table")
+	}
+	for _, c := range columns {
+		if c == "" || strings.Trim(c, "abcdefghijklmnopqrstuvwxyz_0123456789") != "" {
+			return fmt.Errorf("invalid column name %q", c)
+		}
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	stmt, err := tx.PrepareContext(ctx, s.rebind(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders)))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for {
+		row, err := r.NextRow()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(row) != len(columns) {
+			return fmt.Errorf("row has %d values for %d columns", len(row), len(columns))
+		}
+		for i, v := range row {
+			if t, ok := v.(time.Time); ok {
+				row[i] = t.Local()
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+
+	// Postgres sequences don't advance for rows inserted with their IDs
+	if s.dbType == Postgres && slices.Contains(columns, "id") {
+		query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %s", table, table)
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package sqlstore
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dump is a DumpReader over rows held in memory
+type dump struct {
+	tables  []dumpTable
+	next    int
+	rows    [][]interface{}
+	nextRow int
+}
+
+type dumpTable struct {
+	name    string
+	columns []string
+	rows    [][]interface{}
+}
+
+func (d *dump) NextTable() (string, []string, error) {
+	if d.next == len(d.tables) {
+		return "", nil, io.EOF
+	}
+	t := d.tables[d.next]
+	d.next++
+	d.rows, d.nextRow = t.rows, 0
+	return t.name, t.columns, nil
+}
+
+func (d *dump) NextRow() ([]interface{}, error) {
+	if d.nextRow == len(d.rows) {
+		return nil, io.EOF
+	}
+	d.nextRow++
+	return d.rows[d.nextRow-1], nil
+}
+
+// olderDump is a dump from before notes had tags, with a tagged note
+func olderDump() *dump {
+	now := time.Now()
+	return &dump{tables: []dumpTable{
+		{"users", []string{"id", "username", "password_hash"}, [][]interface{}{{int64(1), "someone", "hash"}}},
+		{"notebooks", []string{"id", "user_id", "name", "created_at"}, [][]interface{}{{int64(1), int64(1), "Journal", now}}},
+		{"notes", []string{"id", "user_id", "notebook_id", "content", "created_at"}, [][]interface{}{{int64(1), int64(1), int64(1), "Ran 5k #running", now}}},
+	}}
+}
+
+func TestRestoreOlderVersion(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Restore(ctx, 3, olderDump()); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	tags, err := store.GetTags(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "running" || tags[0].Count != 1 {
+		t.Errorf("Expected the later migrations to tag the restored note, got %+v", tags)
+	}
+
+	// A database migrated past the backup would keep the rows as they were
+	migrated, err := New("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrated.Close()
+	if err := migrated.Restore(ctx, 3, olderDump()); err == nil || !strings.Contains(err.Error(), "past the backup's 3") {
+		t.Errorf("Expected restoring into a newer schema to fail, got %v", err)
+	}
+}
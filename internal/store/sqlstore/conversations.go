@@ -0,0 +1,159 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"tracky/internal/models"
+)
+
+// liveConversation restricts a conversations query (aliased as c) to
+// conversations whose notebook isn't in the trash
+const liveConversation = " AND c.notebook_id IN (SELECT id FROM notebooks WHERE deleted_at IS NULL)"
+
+// CreateConversation starts a conversation about notebooks the user is a
+// member of, listed under the first. It returns sql.ErrNoRows if they aren't
+// a member of the first; the caller checks the others.
+func (s *SQLStore) CreateConversation(userID int, notebookIDs []int, title string) (int64, error) {
+	if len(notebookIDs) == 0 {
+		return 0, sql.ErrNoRows
+	}
+	encoded, err := json.Marshal(notebookIDs)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	query := `INSERT INTO conversations (user_id, notebook_id, notebook_ids, title, created_at, updated_at)
+	          SELECT ?, ?, ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM notebooks WHERE id = ? AND deleted_at IS NULL AND id IN (` + readableNotebooks + `))`
+	args := []interface{}{userID, notebookIDs[0], string(encoded), title, now, now, notebookIDs[0], userID}
+
+	if s.dbType == Postgres {
+		var id int64
+		err := s.db.QueryRow(s.rebind(query+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+	result, err := s.db.Exec(s.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return result.LastInsertId()
+}
+
+const conversationColumns = "c.id, c.notebook_id, c.notebook_ids, c.title, c.created_at, c.updated_at"
+
+func (s *SQLStore) GetConversations(userID, notebookID int) ([]models.Conversation, error) {
+	query := `SELECT ` + conversationColumns + ` FROM conversations c
+	          WHERE c.user_id = ? AND c.notebook_id = ?` + liveConversation + `
+	          ORDER BY c.updated_at DESC, c.id DESC`
+	rows, err := s.db.Query(s.rebind(query), userID, notebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []models.Conversation
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
+			continue
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, nil
+}
+
+func (s *SQLStore) GetConversation(conversationID, userID int) (models.Conversation, error) {
+	query := "SELECT " + conversationColumns + " FROM conversations c WHERE c.id = ? AND c.user_id = ?" + liveConversation
+	c, err := scanConversation(s.db.QueryRow(s.rebind(query), conversationID, userID))
+	if err != nil {
+		return c, err
+	}
+
+	rows, err := s.db.Query(s.rebind("SELECT role, content FROM conversation_messages WHERE conversation_id = ? ORDER BY id ASC"), conversationID)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.ChatMessage
+		if err := rows.Scan(&m.Role, &m.Content); err != nil {
+			return c, err
+		}
+		c.Messages = append(c.Messages, m)
+	}
+	return c, rows.Err()
+}
+
+func (s *SQLStore) RenameConversation(conversationID, userID int, title string) error {
+	result, err := s.db.Exec(s.rebind("UPDATE conversations AS c SET title = ? WHERE c.id = ? AND c.user_id = ?"+liveConversation), title, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteConversation permanently deletes a conversation and its messages
+func (s *SQLStore) DeleteConversation(conversationID, userID int) error {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(s.rebind("DELETE FROM conversations WHERE id = ? AND user_id = ?"), conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(s.rebind("DELETE FROM conversation_messages WHERE conversation_id = ?"), conversationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddConversationMessages appends messages to a conversation in one
+// transaction and marks it as recently active
+func (s *SQLStore) AddConversationMessages(conversationID, userID int, messages ...models.ChatMessage) error {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(s.rebind("UPDATE conversations SET updated_at = ? WHERE id = ? AND user_id = ?"), now, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	for _, m := range messages {
+		_, err := tx.Exec(s.rebind("INSERT INTO conversation_messages (conversation_id, role, content, created_at) VALUES (?, ?, ?, ?)"), conversationID, m.Role, m.Content, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func scanConversation(row interface{ Scan(...interface{}) error }) (models.Conversation, error) {
+	var c models.Conversation
+	var notebookIDs string
+	if err := row.Scan(&c.ID, &c.NotebookID, &notebookIDs, &c.Title, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return c, err
+	}
+	err := json.Unmarshal([]byte(notebookIDs), &c.NotebookIDs)
+	return c, err
+}
@@ -0,0 +1,175 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"tracky/internal/models"
+)
+
+func (s *SQLStore) CreateDigest(userID int, d models.Digest) (int64, error) {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// The first digest covers the first full period after it was set up
+	now := time.Now()
+	var id int64
+	if s.dbType == Postgres {
+		err = tx.QueryRow(s.rebind("INSERT INTO digests (user_id, cadence, prompt, all_notebooks, last_run_at, created_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id"), userID, d.Cadence, d.Prompt, d.AllNotebooks, now, now).Scan(&id)
+	} else {
+		var result sql.Result
+		result, err = tx.Exec(s.rebind("INSERT INTO digests (user_id, cadence, prompt, all_notebooks, last_run_at, created_at) VALUES (?, ?, ?, ?, ?, ?)"), userID, d.Cadence, d.Prompt, d.AllNotebooks, now, now)
+		if err == nil {
+			id, err = result.LastInsertId()
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.setDigestNotebooks(ctx, tx, userID, id, d.NotebookIDs); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *SQLStore) GetDigests(userID int) ([]models.Digest, error) {
+	return s.queryDigests("SELECT id, user_id, cadence, prompt, all_notebooks, last_run_at, created_at, failures FROM digests WHERE user_id = ? ORDER BY id ASC", userID)
+}
+
+func (s *SQLStore) UpdateDigest(userID int, d models.Digest) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(s.rebind("UPDATE digests SET cadence = ?, prompt = ?, all_notebooks = ? WHERE id = ? AND user_id = ?"), d.Cadence, d.Prompt, d.AllNotebooks, d.ID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := s.setDigestNotebooks(ctx, tx, userID, int64(d.ID), d.NotebookIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) DeleteDigest(digestID, userID int) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(s.rebind("DELETE FROM digests WHERE id = ? AND user_id = ?"), digestID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(s.rebind("DELETE FROM digest_notebooks WHERE digest_id = ?"), digestID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) GetDueDigests(cadence string, ranBefore, now time.Time) ([]models.Digest, error) {
+	// Timestamps are stored in server local time, so compare in it too
+	query := `SELECT id, user_id, cadence, prompt, all_notebooks, last_run_at, created_at, failures FROM digests
+	          WHERE cadence = ? AND last_run_at < ? AND (retry_at IS NULL OR retry_at <= ?) ORDER BY id ASC`
+	return s.queryDigests(query, cadence, ranBefore.Local(), now.Local())
+}
+
+// ClaimDigestRun marks a digest as run at now if it was last run before the
+// cutoff. It returns false if another server claimed it first.
+func (s *SQLStore) ClaimDigestRun(digestID int, ranBefore, now time.Time) (bool, error) {
+	result, err := s.db.Exec(s.rebind("UPDATE digests SET last_run_at = ? WHERE id = ? AND last_run_at < ?"), now.Local(), digestID, ranBefore.Local())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// ReleaseDigestRun undoes a claim after a failed run, counting the failure,
+// so that the digest is due again at retryAt
+func (s *SQLStore) ReleaseDigestRun(digestID int, lastRunAt, retryAt time.Time) error {
+	_, err := s.db.Exec(s.rebind("UPDATE digests SET last_run_at = ?, failures = failures + 1, retry_at = ? WHERE id = ?"), lastRunAt.Local(), retryAt.Local(), digestID)
+	return err
+}
+
+// ResetDigestFailures forgets a digest's failed runs once it has run or been
+// given up on for the period
+func (s *SQLStore) ResetDigestFailures(digestID int) error {
+	_, err := s.db.Exec(s.rebind("UPDATE digests SET failures = 0, retry_at = NULL WHERE id = ?"), digestID)
+	return err
+}
+
+// setDigestNotebooks replaces a digest's notebooks. The handlers check that
+// the user owns them; any that were deleted meanwhile are skipped.
+func (s *SQLStore) setDigestNotebooks(ctx context.Context, q queryExecer, userID int, digestID int64, notebookIDs []int) error {
+	if _, err := q.ExecContext(ctx, s.rebind("DELETE FROM digest_notebooks WHERE digest_id = ?"), digestID); err != nil {
+		return err
+	}
+	for _, notebookID := range notebookIDs {
+		_, err := q.ExecContext(ctx, s.rebind(`INSERT INTO digest_notebooks (digest_id, notebook_id)
+		                                       SELECT ?, id FROM notebooks WHERE id = ? AND user_id = ? AND deleted_at IS NULL
+		                                       ON CONFLICT DO NOTHING`), digestID, notebookID, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryDigests runs a digests query and loads each digest's notebooks
+func (s *SQLStore) queryDigests(query string, args ...interface{}) ([]models.Digest, error) {
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	var digests []models.Digest
+	for rows.Next() {
+		var d models.Digest
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Cadence, &d.Prompt, &d.AllNotebooks, &d.LastRunAt, &d.CreatedAt, &d.Failures); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.NotebookIDs = []int{}
+		digests = append(digests, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Each digest's notebooks are read after the digests, so that only one
+	// connection is in use at a time
+	for i := range digests {
+		rows, err := s.db.Query(s.rebind("SELECT notebook_id FROM digest_notebooks WHERE digest_id = ? ORDER BY notebook_id ASC"), digests[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var notebookID int
+			if err := rows.Scan(&notebookID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			digests[i].NotebookIDs = append(digests[i].NotebookIDs, notebookID)
+		}
+		rows.Close()
+	}
+	return digests, nil
+}
@@ -0,0 +1,208 @@
+package sqlstore
+
+import (
+	"encoding/binary"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tracky/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// semanticSnippetLength is how many characters of a note semantic search
+// results show, since there are no matched terms to center on
+const semanticSnippetLength = 200
+
+// detectVectorType records whether migration 7 stored embeddings as pgvector
+// vectors, which it does when the extension could be enabled. A database
+// migrated without it can switch later by enabling the extension and running
+// ALTER TABLE note_embeddings ALTER COLUMN embedding TYPE vector USING embedding::vector.
+func (s *SQLStore) detectVectorType() {
+	if s.dbType != Postgres {
+		return
+	}
+	var udt string
+	s.db.QueryRow("SELECT udt_name FROM information_schema.columns WHERE table_name = 'note_embeddings' AND column_name = 'embedding'").Scan(&udt)
+	s.pgvector = udt == "vector"
+}
+
+// GetNotesWithoutEmbedding returns live notes after afterID, oldest first,
+// that have no embedding from model: new notes, notes edited since they were
+// embedded and notes embedded with a different model. Blank notes are
+// skipped.
+func (s *SQLStore) GetNotesWithoutEmbedding(model string, afterID, limit int) ([]models.Note, error) {
+	query := `SELECT n.id, n.user_id, n.notebook_id, n.content, n.created_at
+	          FROM notes n
+	          LEFT JOIN note_embeddings e ON e.note_id = n.id AND e.model = ?
+	          WHERE e.note_id IS NULL AND n.id > ? AND TRIM(n.content) <> ''` + liveNote + `
+	          ORDER BY n.id ASC LIMIT ?`
+	rows, err := s.db.Query(s.rebind(query), model, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		var n models.Note
+		if err := rows.Scan(&n.ID, &n.UserID, &n.NotebookID, &n.Content, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+// SaveNoteEmbedding stores the embedding of a note's content. It does nothing
+// if the note has changed since content was read, so an edit racing with the
+// indexer can't leave a stale embedding behind.
+func (s *SQLStore) SaveNoteEmbedding(noteID int, content, model string, embedding []float32) error {
+	value := "?"
+	if s.pgvector {
+		value = "?::vector"
+	}
+	query := `INSERT INTO note_embeddings (note_id, model, embedding, updated_at)
+	          SELECT ?, ?, ` + value + `, ? WHERE EXISTS (SELECT 1 FROM notes WHERE id = ? AND content = ?)
+	          ON CONFLICT (note_id) DO UPDATE SET model = excluded.model, embedding = excluded.embedding, updated_at = excluded.updated_at`
+	_, err := s.db.Exec(s.rebind(query), noteID, model, s.encodeEmbedding(embedding), time.Now(), noteID, content)
+	return err
+}
+
+// SemanticSearch ranks the user's notes by cosine similarity between their
+// embeddings and the query embedding. Notes not yet embedded with model
+// aren't searched.
+func (s *SQLStore) SemanticSearch(userID int, model string, embedding []float32, filters models.SearchFilters) ([]models.SearchResult, error) {
+	limit := filters.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	// Without pgvector there are no vector functions, so every embedding is
+	// compared here
+	inDB := s.pgvector
+
+	var query string
+	var args []interface{}
+	if inDB {
+		// <=> is pgvector's cosine distance
+		query = `SELECT n.id, n.user_id, n.notebook_id, nb.name, n.content, n.created_at, 1 - (e.embedding <=> ?::vector) AS rank`
+		args = append(args, s.encodeEmbedding(embedding))
+	} else {
+		query = `SELECT n.id, n.user_id, n.notebook_id, nb.name, n.content, n.created_at, e.embedding`
+	}
+	query += `
+	          FROM note_embeddings e
+	          JOIN notes n ON n.id = e.note_id
+	          JOIN notebooks nb ON nb.id = n.notebook_id
+	          WHERE e.model = ?` + readableNote + liveNote
+	args = append(args, model, userID)
+
+	query, args = applySearchFilters(query, args, filters)
+	if inDB {
+		query += " ORDER BY rank DESC, n.created_at DESC LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		var r models.SearchResult
+		switch {
+		case inDB:
+			err = rows.Scan(&r.ID, &r.UserID, &r.NotebookID, &r.NotebookName, &r.Content, &r.CreatedAt, &r.Rank)
+		case s.dbType == Postgres:
+			var v pq.Float32Array
+			err = rows.Scan(&r.ID, &r.UserID, &r.NotebookID, &r.NotebookName, &r.Content, &r.CreatedAt, &v)
+			r.Rank = cosineSimilarity(embedding, v)
+		default:
+			var blob []byte
+			err = rows.Scan(&r.ID, &r.UserID, &r.NotebookID, &r.NotebookName, &r.Content, &r.CreatedAt, &blob)
+			r.Rank = cosineSimilarity(embedding, decodeEmbedding(blob))
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Snippet = leadSnippet(r.Content)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !inDB {
+		sort.SliceStable(results, func(i, j int) bool {
+			if results[i].Rank != results[j].Rank {
+				return results[i].Rank > results[j].Rank
+			}
+			return results[i].CreatedAt.After(results[j].CreatedAt)
+		})
+		if len(results) > limit {
+			results = results[:limit]
+		}
+	}
+	return results, nil
+}
+
+// encodeEmbedding converts a vector to a pgvector literal, a Postgres array
+// without pgvector, or little-endian float32s on SQLite
+func (s *SQLStore) encodeEmbedding(v []float32) interface{} {
+	if s.pgvector {
+		parts := make([]string, len(v))
+		for i, f := range v {
+			parts[i] = strconv.FormatFloat(float64(f), 'g', -1, 32)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+	if s.dbType == Postgres {
+		return pq.Float32Array(v)
+	}
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func decodeEmbedding(buf []byte) []float32 {
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v
+}
+
+// cosineSimilarity returns 0 for vectors of different lengths or zero vectors
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// leadSnippet is the HTML-escaped start of a note's content
+func leadSnippet(content string) string {
+	runes := []rune(strings.TrimSpace(content))
+	if len(runes) > semanticSnippetLength {
+		return html.EscapeString(string(runes[:semanticSnippetLength])) + "…"
+	}
+	return html.EscapeString(string(runes))
+}
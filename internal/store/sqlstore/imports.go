@@ -0,0 +1,88 @@
+package sqlstore
+
+import (
+	"encoding/json"
+	"time"
+
+	"tracky/internal/models"
+)
+
+func (s *SQLStore) CreateImportJob(userID int, format string) (int64, error) {
+	now := time.Now()
+	query := "INSERT INTO import_jobs (user_id, format, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
+	args := []interface{}{userID, format, models.ImportRunning, now, now}
+
+	if s.dbType == Postgres {
+		var id int64
+		err := s.db.QueryRow(s.rebind(query+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+	result, err := s.db.Exec(s.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// UpdateImportJob saves a job's status and progress
+func (s *SQLStore) UpdateImportJob(job models.ImportJob) error {
+	notebookIDs, err := json.Marshal(job.NotebookIDs)
+	if err != nil {
+		return err
+	}
+	skipped, err := json.Marshal(job.Skipped)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE import_jobs SET status = ?, total = ?, processed = ?, imported = ?, notebook_ids = ?, skipped = ?, error = ?, updated_at = ?
+	          WHERE id = ?`
+	_, err = s.db.Exec(s.rebind(query), job.Status, job.Total, job.Processed, job.Imported, string(notebookIDs), string(skipped), job.Error, time.Now(), job.ID)
+	return err
+}
+
+func (s *SQLStore) GetImportJob(jobID, userID int) (models.ImportJob, error) {
+	row := s.db.QueryRow(s.rebind("SELECT "+importJobColumns+" FROM import_jobs WHERE id = ? AND user_id = ?"), jobID, userID)
+	return scanImportJob(row)
+}
+
+// GetImportJobs lists the user's imports, newest first
+func (s *SQLStore) GetImportJobs(userID int) ([]models.ImportJob, error) {
+	rows, err := s.db.Query(s.rebind("SELECT "+importJobColumns+" FROM import_jobs WHERE user_id = ? ORDER BY created_at DESC, id DESC"), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.ImportJob
+	for rows.Next() {
+		job, err := scanImportJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+const importJobColumns = "id, user_id, format, status, total, processed, imported, notebook_ids, skipped, error, created_at, updated_at"
+
+func scanImportJob(row interface{ Scan(...interface{}) error }) (models.ImportJob, error) {
+	var job models.ImportJob
+	var notebookIDs, skipped string
+	if err := row.Scan(&job.ID, &job.UserID, &job.Format, &job.Status, &job.Total, &job.Processed, &job.Imported, &notebookIDs, &skipped, &job.Error, &job.CreatedAt, &job.UpdatedAt); err != nil {
+		return job, err
+	}
+	if err := json.Unmarshal([]byte(notebookIDs), &job.NotebookIDs); err != nil {
+		return job, err
+	}
+	if err := json.Unmarshal([]byte(skipped), &job.Skipped); err != nil {
+		return job, err
+	}
+	if job.NotebookIDs == nil {
+		job.NotebookIDs = []int{}
+	}
+	if job.Skipped == nil {
+		job.Skipped = []models.SkippedItem{}
+	}
+	return job, nil
+}
@@ -0,0 +1,86 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	"tracky/internal/models"
+)
+
+// readableNotebooks and writableNotebooks select the IDs of the notebooks
+// whose notes a user, bound to the ?, may read or change
+const (
+	readableNotebooks = "SELECT notebook_id FROM notebook_members WHERE user_id = ?"
+	writableNotebooks = readableNotebooks + " AND role IN ('owner', 'editor')"
+)
+
+// readableNote and writableNote restrict a notes query (aliased as n) to
+// notes the user, bound to the ?, may read or change
+const (
+	readableNote = " AND n.notebook_id IN (" + readableNotebooks + ")"
+	writableNote = " AND n.notebook_id IN (" + writableNotebooks + ")"
+)
+
+func (s *SQLStore) GetNotebookRole(notebookID, userID int) (string, error) {
+	var role string
+	query := `SELECT m.role FROM notebook_members m
+	          JOIN notebooks nb ON nb.id = m.notebook_id
+	          WHERE m.notebook_id = ? AND m.user_id = ? AND nb.deleted_at IS NULL`
+	err := s.db.QueryRow(s.rebind(query), notebookID, userID).Scan(&role)
+	return role, err
+}
+
+func (s *SQLStore) GetNoteRole(noteID, userID int) (string, error) {
+	var role string
+	query := `SELECT m.role FROM notes n
+	          JOIN notebook_members m ON m.notebook_id = n.notebook_id
+	          WHERE n.id = ? AND m.user_id = ?` + liveNote
+	err := s.db.QueryRow(s.rebind(query), noteID, userID).Scan(&role)
+	return role, err
+}
+
+// GetNotebookMembers lists a notebook's members, owner first
+func (s *SQLStore) GetNotebookMembers(notebookID int) ([]models.NotebookMember, error) {
+	query := `SELECT m.user_id, u.username, m.role, m.created_at FROM notebook_members m
+	          JOIN users u ON u.id = m.user_id
+	          WHERE m.notebook_id = ?
+	          ORDER BY m.role = 'owner' DESC, m.created_at ASC, m.id ASC`
+	rows, err := s.db.Query(s.rebind(query), notebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.NotebookMember
+	for rows.Next() {
+		var m models.NotebookMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// SetNotebookMember adds a user to a notebook or changes their role. The
+// owner's role never changes.
+func (s *SQLStore) SetNotebookMember(notebookID, userID int, role string) error {
+	query := `INSERT INTO notebook_members (notebook_id, user_id, role, created_at) VALUES (?, ?, ?, ?)
+	          ON CONFLICT (notebook_id, user_id) DO UPDATE SET role = excluded.role
+	          WHERE notebook_members.role <> 'owner'`
+	_, err := s.db.Exec(s.rebind(query), notebookID, userID, role, time.Now())
+	return err
+}
+
+// DeleteNotebookMember removes a user's access to a notebook. It returns
+// sql.ErrNoRows for the owner, who can't be removed.
+func (s *SQLStore) DeleteNotebookMember(notebookID, userID int) error {
+	result, err := s.db.Exec(s.rebind("DELETE FROM notebook_members WHERE notebook_id = ? AND user_id = ? AND role <> 'owner'"), notebookID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
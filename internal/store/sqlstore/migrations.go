@@ -0,0 +1,898 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migrationLockID is the Postgres advisory lock key held while migrating
+const migrationLockID = 7242501
+
+// migration is one numbered schema change. up and down hold a SQL script per
+// dialect; scripts may contain several statements. backfill, if set, runs
+// after the up script for data changes that need Go code.
+type migration struct {
+	version  int
+	name     string
+	up       map[DBType]string
+	down     map[DBType]string
+	backfill func(s *SQLStore, ctx context.Context, q queryExecer) error
+}
+
+// MigrationStatus reports whether a known migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time // nil if pending
+}
+
+// migrations must stay sorted by version, and released migrations must never
+// be edited: add a new one instead
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create_tables",
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS users (
+				id SERIAL PRIMARY KEY,
+				username TEXT NOT NULL UNIQUE,
+				password_hash TEXT NOT NULL
+			);
+			CREATE TABLE IF NOT EXISTS notebooks (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				name TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			);
+			CREATE TABLE IF NOT EXISTS notes (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				notebook_id INTEGER REFERENCES notebooks(id),
+				content TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			);
+			CREATE TABLE IF NOT EXISTS note_images (
+				id SERIAL PRIMARY KEY,
+				note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+				filename TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL UNIQUE,
+				password_hash TEXT NOT NULL
+			);
+			CREATE TABLE IF NOT EXISTS notebooks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			);
+			CREATE TABLE IF NOT EXISTS notes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				notebook_id INTEGER,
+				content TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY(user_id) REFERENCES users(id),
+				FOREIGN KEY(notebook_id) REFERENCES notebooks(id)
+			);
+			CREATE TABLE IF NOT EXISTS note_images (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				note_id INTEGER NOT NULL,
+				filename TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY(note_id) REFERENCES notes(id) ON DELETE CASCADE
+			);`,
+		},
+		down: map[DBType]string{
+			Postgres: `
+			DROP TABLE IF EXISTS note_images;
+			DROP TABLE IF EXISTS notes;
+			DROP TABLE IF EXISTS notebooks;
+			DROP TABLE IF EXISTS users;`,
+			SQLite: `
+			DROP TABLE IF EXISTS note_images;
+			DROP TABLE IF EXISTS notes;
+			DROP TABLE IF EXISTS notebooks;
+			DROP TABLE IF EXISTS users;`,
+		},
+	},
+	{
+		version: 2,
+		name:    "notes_full_text_search",
+		up: map[DBType]string{
+			Postgres: `
+			ALTER TABLE notes ADD COLUMN IF NOT EXISTS search_vector tsvector
+				GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+			CREATE INDEX IF NOT EXISTS notes_search_idx ON notes USING GIN (search_vector);`,
+			SQLite: sqliteSearchUp,
+		},
+		down: map[DBType]string{
+			Postgres: `
+			DROP INDEX IF EXISTS notes_search_idx;
+			ALTER TABLE notes DROP COLUMN IF EXISTS search_vector;`,
+			SQLite: `
+			DROP TRIGGER IF EXISTS notes_fts_ai;
+			DROP TRIGGER IF EXISTS notes_fts_ad;
+			DROP TRIGGER IF EXISTS notes_fts_bd;
+			DROP TRIGGER IF EXISTS notes_fts_bu;
+			DROP TRIGGER IF EXISTS notes_fts_au;
+			DROP TABLE IF EXISTS notes_fts;`,
+		},
+	},
+	{
+		version: 3,
+		name:    "notes_pagination_index",
+		up: map[DBType]string{
+			Postgres: `CREATE INDEX IF NOT EXISTS notes_notebook_created_idx ON notes (notebook_id, created_at DESC, id DESC);`,
+			SQLite:   `CREATE INDEX IF NOT EXISTS notes_notebook_created_idx ON notes (notebook_id, created_at DESC, id DESC);`,
+		},
+		down: map[DBType]string{
+			Postgres: `DROP INDEX IF EXISTS notes_notebook_created_idx;`,
+			SQLite:   `DROP INDEX IF EXISTS notes_notebook_created_idx;`,
+		},
+	},
+	{
+		version: 4,
+		name:    "note_tags",
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS tags (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				name TEXT NOT NULL,
+				UNIQUE (user_id, name)
+			);
+			CREATE TABLE IF NOT EXISTS note_tags (
+				note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+				tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+				PRIMARY KEY (note_id, tag_id)
+			);
+			CREATE INDEX IF NOT EXISTS note_tags_tag_idx ON note_tags (tag_id);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS tags (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				UNIQUE (user_id, name),
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			);
+			CREATE TABLE IF NOT EXISTS note_tags (
+				note_id INTEGER NOT NULL,
+				tag_id INTEGER NOT NULL,
+				PRIMARY KEY (note_id, tag_id),
+				FOREIGN KEY(note_id) REFERENCES notes(id) ON DELETE CASCADE,
+				FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+			);
+			CREATE INDEX IF NOT EXISTS note_tags_tag_idx ON note_tags (tag_id);`,
+		},
+		down: map[DBType]string{
+			Postgres: `
+			DROP TABLE IF EXISTS note_tags;
+			DROP TABLE IF EXISTS tags;`,
+			SQLite: `
+			DROP TABLE IF EXISTS note_tags;
+			DROP TABLE IF EXISTS tags;`,
+		},
+		backfill: (*SQLStore).backfillTags,
+	},
+	{
+		version: 5,
+		name:    "note_revisions",
+		// Every note starts with one revision holding its current content
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS note_revisions (
+				id SERIAL PRIMARY KEY,
+				note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+				content TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS note_revisions_note_idx ON note_revisions (note_id, created_at);
+			INSERT INTO note_revisions (note_id, content, created_at) SELECT id, content, created_at FROM notes;`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS note_revisions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				note_id INTEGER NOT NULL,
+				content TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY(note_id) REFERENCES notes(id) ON DELETE CASCADE
+			);
+			CREATE INDEX IF NOT EXISTS note_revisions_note_idx ON note_revisions (note_id, created_at);
+			INSERT INTO note_revisions (note_id, content, created_at) SELECT id, content, created_at FROM notes;`,
+		},
+		down: map[DBType]string{
+			Postgres: `DROP TABLE IF EXISTS note_revisions;`,
+			SQLite:   `DROP TABLE IF EXISTS note_revisions;`,
+		},
+	},
+	{
+		version: 6,
+		name:    "soft_delete",
+		up: map[DBType]string{
+			Postgres: `
+			ALTER TABLE notebooks ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+			ALTER TABLE notes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+			ALTER TABLE note_images ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;`,
+			SQLite: `
+			ALTER TABLE notebooks ADD COLUMN deleted_at DATETIME;
+			ALTER TABLE notes ADD COLUMN deleted_at DATETIME;
+			ALTER TABLE note_images ADD COLUMN deleted_at DATETIME;`,
+		},
+		down: map[DBType]string{
+			Postgres: `
+			ALTER TABLE note_images DROP COLUMN IF EXISTS deleted_at;
+			ALTER TABLE notes DROP COLUMN IF EXISTS deleted_at;
+			ALTER TABLE notebooks DROP COLUMN IF EXISTS deleted_at;`,
+			SQLite: `
+			ALTER TABLE note_images DROP COLUMN deleted_at;
+			ALTER TABLE notes DROP COLUMN deleted_at;
+			ALTER TABLE notebooks DROP COLUMN deleted_at;`,
+		},
+	},
+	{
+		version: 7,
+		name:    "note_embeddings",
+		// Vectors of any length are allowed since the dimension depends on
+		// the embedding model. On Postgres, embeddings use pgvector if it can
+		// be enabled, and are otherwise stored as real[] and compared in the
+		// server.
+		up: map[DBType]string{
+			Postgres: `
+			DO $$
+			BEGIN
+				IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'vector') THEN
+					BEGIN
+						CREATE EXTENSION IF NOT EXISTS vector;
+					EXCEPTION WHEN insufficient_privilege THEN
+						RAISE NOTICE 'not allowed to enable pgvector, storing embeddings as arrays';
+					END;
+				END IF;
+				EXECUTE format('CREATE TABLE IF NOT EXISTS note_embeddings (
+					note_id INTEGER PRIMARY KEY REFERENCES notes(id) ON DELETE CASCADE,
+					model TEXT NOT NULL,
+					embedding %s NOT NULL,
+					updated_at TIMESTAMP NOT NULL
+				)', CASE WHEN EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'vector') THEN 'vector' ELSE 'real[]' END);
+			END $$;`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS note_embeddings (
+				note_id INTEGER PRIMARY KEY,
+				model TEXT NOT NULL,
+				embedding BLOB NOT NULL,
+				updated_at DATETIME NOT NULL,
+				FOREIGN KEY(note_id) REFERENCES notes(id) ON DELETE CASCADE
+			);`,
+		},
+		down: map[DBType]string{
+			Postgres: `DROP TABLE IF EXISTS note_embeddings;`,
+			SQLite:   `DROP TABLE IF EXISTS note_embeddings;`,
+		},
+	},
+	{
+		version: 8,
+		name:    "conversations",
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS conversations (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				notebook_id INTEGER NOT NULL REFERENCES notebooks(id),
+				notebook_ids TEXT NOT NULL DEFAULT '[]',
+				title TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS conversations_notebook_idx ON conversations (notebook_id, updated_at);
+			CREATE TABLE IF NOT EXISTS conversation_messages (
+				id SERIAL PRIMARY KEY,
+				conversation_id INTEGER NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+				role TEXT NOT NULL,
+				content TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS conversation_messages_conversation_idx ON conversation_messages (conversation_id, id);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS conversations (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				notebook_id INTEGER NOT NULL,
+				notebook_ids TEXT NOT NULL DEFAULT '[]',
+				title TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL,
+				FOREIGN KEY(user_id) REFERENCES users(id),
+				FOREIGN KEY(notebook_id) REFERENCES notebooks(id)
+			);
+			CREATE INDEX IF NOT EXISTS conversations_notebook_idx ON conversations (notebook_id, updated_at);
+			CREATE TABLE IF NOT EXISTS conversation_messages (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				conversation_id INTEGER NOT NULL,
+				role TEXT NOT NULL,
+				content TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY(conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+			);
+			CREATE INDEX IF NOT EXISTS conversation_messages_conversation_idx ON conversation_messages (conversation_id, id);`,
+		},
+		down: map[DBType]string{
+			Postgres: `
+			DROP TABLE IF EXISTS conversation_messages;
+			DROP TABLE IF EXISTS conversations;`,
+			SQLite: `
+			DROP TABLE IF EXISTS conversation_messages;
+			DROP TABLE IF EXISTS conversations;`,
+		},
+	},
+	{
+		version: 9,
+		name:    "digests",
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS digests (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				cadence TEXT NOT NULL,
+				prompt TEXT NOT NULL,
+				all_notebooks BOOLEAN NOT NULL DEFAULT FALSE,
+				failures INTEGER NOT NULL DEFAULT 0,
+				retry_at TIMESTAMP,
+				last_run_at TIMESTAMP NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS digests_cadence_idx ON digests (cadence, last_run_at);
+			CREATE TABLE IF NOT EXISTS digest_notebooks (
+				digest_id INTEGER NOT NULL REFERENCES digests(id) ON DELETE CASCADE,
+				notebook_id INTEGER NOT NULL REFERENCES notebooks(id) ON DELETE CASCADE,
+				PRIMARY KEY (digest_id, notebook_id)
+			);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS digests (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				cadence TEXT NOT NULL,
+				prompt TEXT NOT NULL,
+				all_notebooks BOOLEAN NOT NULL DEFAULT 0,
+				failures INTEGER NOT NULL DEFAULT 0,
+				retry_at DATETIME,
+				last_run_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			);
+			CREATE INDEX IF NOT EXISTS digests_cadence_idx ON digests (cadence, last_run_at);
+			CREATE TABLE IF NOT EXISTS digest_notebooks (
+				digest_id INTEGER NOT NULL,
+				notebook_id INTEGER NOT NULL,
+				PRIMARY KEY (digest_id, notebook_id),
+				FOREIGN KEY(digest_id) REFERENCES digests(id) ON DELETE CASCADE,
+				FOREIGN KEY(notebook_id) REFERENCES notebooks(id) ON DELETE CASCADE
+			);`,
+		},
+		down: map[DBType]string{
+			Postgres: `
+			DROP TABLE IF EXISTS digest_notebooks;
+			DROP TABLE IF EXISTS digests;`,
+			SQLite: `
+			DROP TABLE IF EXISTS digest_notebooks;
+			DROP TABLE IF EXISTS digests;`,
+		},
+	},
+	{
+		version: 10,
+		name:    "sessions",
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS sessions (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				token_hash TEXT NOT NULL UNIQUE,
+				user_agent TEXT NOT NULL,
+				ip_address TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				last_seen_at TIMESTAMP NOT NULL,
+				expires_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS sessions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				user_agent TEXT NOT NULL,
+				ip_address TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				last_seen_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			);
+			CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id);`,
+		},
+		down: map[DBType]string{
+			Postgres: `DROP TABLE IF EXISTS sessions;`,
+			SQLite:   `DROP TABLE IF EXISTS sessions;`,
+		},
+	},
+	{
+		version: 11,
+		name:    "api_tokens",
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS api_tokens (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				scope TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				last_used_at TIMESTAMP,
+				expires_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS api_tokens_user_idx ON api_tokens (user_id);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS api_tokens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				scope TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				last_used_at DATETIME,
+				expires_at DATETIME,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			);
+			CREATE INDEX IF NOT EXISTS api_tokens_user_idx ON api_tokens (user_id);`,
+		},
+		down: map[DBType]string{
+			Postgres: `DROP TABLE IF EXISTS api_tokens;`,
+			SQLite:   `DROP TABLE IF EXISTS api_tokens;`,
+		},
+	},
+	{
+		version: 12,
+		name:    "two_factor",
+		up: map[DBType]string{
+			Postgres: `
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT;
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				code_hash TEXT NOT NULL,
+				used_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS recovery_codes_user_idx ON recovery_codes (user_id);
+			CREATE TABLE IF NOT EXISTS login_challenges (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				token_hash TEXT NOT NULL UNIQUE,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				expires_at TIMESTAMP NOT NULL
+			);`,
+			SQLite: `
+			ALTER TABLE users ADD COLUMN totp_secret TEXT;
+			ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT 0;
+			ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				code_hash TEXT NOT NULL,
+				used_at DATETIME,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			);
+			CREATE INDEX IF NOT EXISTS recovery_codes_user_idx ON recovery_codes (user_id);
+			CREATE TABLE IF NOT EXISTS login_challenges (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				expires_at DATETIME NOT NULL,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			);`,
+		},
+		down: map[DBType]string{
+			Postgres: `
+			DROP TABLE IF EXISTS login_challenges;
+			DROP TABLE IF EXISTS recovery_codes;
+			ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+			ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+			ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;`,
+			SQLite: `
+			DROP TABLE IF EXISTS login_challenges;
+			DROP TABLE IF EXISTS recovery_codes;
+			ALTER TABLE users DROP COLUMN totp_last_step;
+			ALTER TABLE users DROP COLUMN totp_enabled;
+			ALTER TABLE users DROP COLUMN totp_secret;`,
+		},
+	},
+	{
+		version: 13,
+		name:    "login_lockout",
+		up: map[DBType]string{
+			Postgres: `
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;`,
+			SQLite: `
+			ALTER TABLE users ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE users ADD COLUMN locked_until DATETIME;`,
+		},
+		down: map[DBType]string{
+			Postgres: `
+			ALTER TABLE users DROP COLUMN IF EXISTS locked_until;
+			ALTER TABLE users DROP COLUMN IF EXISTS failed_logins;`,
+			SQLite: `
+			ALTER TABLE users DROP COLUMN locked_until;
+			ALTER TABLE users DROP COLUMN failed_logins;`,
+		},
+	},
+	{
+		version: 14,
+		name:    "user_identities",
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS user_identities (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				issuer TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				UNIQUE (issuer, subject)
+			);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS user_identities (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				issuer TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				UNIQUE (issuer, subject),
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			);`,
+		},
+		down: map[DBType]string{
+			Postgres: `DROP TABLE IF EXISTS user_identities;`,
+			SQLite:   `DROP TABLE IF EXISTS user_identities;`,
+		},
+	},
+	{
+		// Every notebook's owner is a member too, so access checks only need
+		// this table
+		version: 15,
+		name:    "notebook_members",
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS notebook_members (
+				id SERIAL PRIMARY KEY,
+				notebook_id INTEGER NOT NULL REFERENCES notebooks(id),
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				role TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				UNIQUE (notebook_id, user_id)
+			);
+			CREATE INDEX IF NOT EXISTS notebook_members_user_idx ON notebook_members (user_id);
+			INSERT INTO notebook_members (notebook_id, user_id, role, created_at)
+			SELECT id, user_id, 'owner', created_at FROM notebooks;`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS notebook_members (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				notebook_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				role TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				UNIQUE (notebook_id, user_id),
+				FOREIGN KEY(notebook_id) REFERENCES notebooks(id),
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			);
+			CREATE INDEX IF NOT EXISTS notebook_members_user_idx ON notebook_members (user_id);
+			INSERT INTO notebook_members (notebook_id, user_id, role, created_at)
+			SELECT id, user_id, 'owner', created_at FROM notebooks;`,
+		},
+		down: map[DBType]string{
+			Postgres: `DROP TABLE IF EXISTS notebook_members;`,
+			SQLite:   `DROP TABLE IF EXISTS notebook_members;`,
+		},
+	},
+	{
+		// A link shares either a notebook or a single note
+		version: 16,
+		name:    "share_links",
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS share_links (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				notebook_id INTEGER REFERENCES notebooks(id),
+				note_id INTEGER REFERENCES notes(id),
+				token_hash TEXT UNIQUE NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				expires_at TIMESTAMP
+			);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS share_links (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				notebook_id INTEGER,
+				note_id INTEGER,
+				token_hash TEXT UNIQUE NOT NULL,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME,
+				FOREIGN KEY(user_id) REFERENCES users(id),
+				FOREIGN KEY(notebook_id) REFERENCES notebooks(id),
+				FOREIGN KEY(note_id) REFERENCES notes(id)
+			);`,
+		},
+		down: map[DBType]string{
+			Postgres: `DROP TABLE IF EXISTS share_links;`,
+			SQLite:   `DROP TABLE IF EXISTS share_links;`,
+		},
+	},
+	{
+		// notebook_ids and skipped hold JSON arrays
+		version: 17,
+		name:    "import_jobs",
+		up: map[DBType]string{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS import_jobs (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				format TEXT NOT NULL,
+				status TEXT NOT NULL,
+				total INTEGER NOT NULL DEFAULT 0,
+				processed INTEGER NOT NULL DEFAULT 0,
+				imported INTEGER NOT NULL DEFAULT 0,
+				notebook_ids TEXT NOT NULL DEFAULT '[]',
+				skipped TEXT NOT NULL DEFAULT '[]',
+				error TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS import_jobs_user_idx ON import_jobs (user_id);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS import_jobs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				format TEXT NOT NULL,
+				status TEXT NOT NULL,
+				total INTEGER NOT NULL DEFAULT 0,
+				processed INTEGER NOT NULL DEFAULT 0,
+				imported INTEGER NOT NULL DEFAULT 0,
+				notebook_ids TEXT NOT NULL DEFAULT '[]',
+				skipped TEXT NOT NULL DEFAULT '[]',
+				error TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			);
+			CREATE INDEX IF NOT EXISTS import_jobs_user_idx ON import_jobs (user_id);`,
+		},
+		down: map[DBType]string{
+			Postgres: `DROP TABLE IF EXISTS import_jobs;`,
+			SQLite:   `DROP TABLE IF EXISTS import_jobs;`,
+		},
+	},
+}
+
+// latestVersion is the schema version once every migration is applied
+func latestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// queryExecer is satisfied by *sql.DB, *sql.Conn and *sql.Tx
+type queryExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// MigrateUp applies all pending migrations
+func (s *SQLStore) MigrateUp() error {
+	return s.migrateUpTo(latestVersion())
+}
+
+// migrateUpTo applies the pending migrations up to and including version
+func (s *SQLStore) migrateUpTo(version int) error {
+	return s.withMigrationLock(func(ctx context.Context, q queryExecer) error {
+		if err := s.createMigrationsTable(ctx, q); err != nil {
+			return err
+		}
+		applied, err := s.appliedMigrations(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok || m.version > version {
+				continue
+			}
+			if _, err := q.ExecContext(ctx, m.up[s.dbType]); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+			if m.backfill != nil {
+				if err := m.backfill(s, ctx, q); err != nil {
+					return fmt.Errorf("migration %d (%s) backfill: %w", m.version, m.name, err)
+				}
+			}
+			_, err := q.ExecContext(ctx, s.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"), m.version, m.name, time.Now())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the most recently applied migrations, newest first
+func (s *SQLStore) MigrateDown(steps int) error {
+	return s.withMigrationLock(func(ctx context.Context, q queryExecer) error {
+		if err := s.createMigrationsTable(ctx, q); err != nil {
+			return err
+		}
+		applied, err := s.appliedMigrations(ctx, q)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			if _, err := q.ExecContext(ctx, m.down[s.dbType]); err != nil {
+				return fmt.Errorf("reverting migration %d (%s): %w", m.version, m.name, err)
+			}
+			if _, err := q.ExecContext(ctx, s.rebind("DELETE FROM schema_migrations WHERE version = ?"), m.version); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// MigrationStatus lists every known migration and when it was applied. It
+// only reads, so it doesn't wait for a migration in progress.
+func (s *SQLStore) MigrationStatus() ([]MigrationStatus, error) {
+	ctx := context.Background()
+	applied := make(map[int]time.Time)
+	exists, err := s.migrationsTableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if applied, err = s.appliedMigrations(ctx, s.db); err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.version, Name: m.name}
+		if at, ok := applied[m.version]; ok {
+			st.AppliedAt = &at
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// migrationsTableExists reports whether schema_migrations has been created,
+// which it isn't until migrations first run
+func (s *SQLStore) migrationsTableExists(ctx context.Context) (bool, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'"
+	if s.dbType == Postgres {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_migrations'"
+	}
+	var n int
+	err := s.db.QueryRowContext(ctx, query).Scan(&n)
+	return n > 0, err
+}
+
+// createMigrationsTable creates the schema_migrations table if needed
+func (s *SQLStore) createMigrationsTable(ctx context.Context, q queryExecer) error {
+	_, err := q.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	return err
+}
+
+// appliedMigrations returns the applied versions with their timestamps
+func (s *SQLStore) appliedMigrations(ctx context.Context, q queryExecer) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// withMigrationLock runs fn in a single transaction that holds a database-wide
+// lock, so two servers starting together apply migrations one at a time
+func (s *SQLStore) withMigrationLock(fn func(ctx context.Context, q queryExecer) error) error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if s.dbType == Postgres {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		// Released automatically when the transaction ends
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+			return err
+		}
+		if err := fn(ctx, tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	// BEGIN IMMEDIATE takes SQLite's write lock up front; busy_timeout makes a
+	// second process wait for it rather than fail
+	if _, err := conn.ExecContext(ctx, "PRAGMA busy_timeout = 10000"); err != nil {
+		return err
+	}
+	if err := s.upgradeLegacySQLite(ctx, conn); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	if err := fn(ctx, conn); err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	return err
+}
+
+// upgradeLegacySQLite adds notes.notebook_id to databases created before
+// notebooks existed, which migration 1 can't do since its tables already exist
+func (s *SQLStore) upgradeLegacySQLite(ctx context.Context, conn *sql.Conn) error {
+	rows, err := conn.QueryContext(ctx, "SELECT name FROM pragma_table_info('notes')")
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns = append(columns, name)
+	}
+	rows.Close()
+
+	if len(columns) == 0 {
+		return nil // Fresh database
+	}
+	for _, c := range columns {
+		if c == "notebook_id" {
+			return nil
+		}
+	}
+	_, err = conn.ExecContext(ctx, "ALTER TABLE notes ADD COLUMN notebook_id INTEGER")
+	return err
+}
@@ -0,0 +1,67 @@
+package sqlstore
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMigrateDownAndUp(t *testing.T) {
+	store, err := New("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	statuses, err := store.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range statuses {
+		if st.AppliedAt == nil {
+			t.Errorf("Expected migration %d to be applied", st.Version)
+		}
+	}
+
+	if err := store.MigrateDown(len(migrations)); err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+	if err := store.CreateUser("someone", "hash"); err == nil {
+		t.Error("Expected users table to be dropped")
+	}
+
+	if err := store.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+	if err := store.CreateUser("someone", "hash"); err != nil {
+		t.Errorf("Expected users table to be recreated: %v", err)
+	}
+
+	// Applying again is a no-op
+	if err := store.MigrateUp(); err != nil {
+		t.Fatalf("Second MigrateUp failed: %v", err)
+	}
+}
+
+func TestMigrationStatusReadOnly(t *testing.T) {
+	store, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	statuses, err := store.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("Expected %d migrations, got %d", len(migrations), len(statuses))
+	}
+	for _, st := range statuses {
+		if st.AppliedAt != nil {
+			t.Errorf("Expected migration %d not to be applied", st.Version)
+		}
+	}
+	if exists, err := store.migrationsTableExists(context.Background()); err != nil || exists {
+		t.Errorf("Expected the status check not to create schema_migrations, got %v, %v", exists, err)
+	}
+}
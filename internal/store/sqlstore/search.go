@@ -0,0 +1,186 @@
+package sqlstore
+
+import (
+	"html"
+	"sort"
+	"strings"
+
+	"tracky/internal/models"
+)
+
+const defaultSearchLimit = 20
+
+// Markers wrapped around matched terms by the database; replaced with <mark>
+// after the snippet has been HTML-escaped
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+// detectSearchModule records which FTS module the notes_fts table was created
+// with, which may differ from the current build if the database is older
+func (s *SQLStore) detectSearchModule() {
+	if s.dbType != SQLite {
+		return
+	}
+	var ddl string
+	s.db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'notes_fts'").Scan(&ddl)
+	if strings.Contains(strings.ToLower(ddl), "fts5") {
+		s.ftsModule = "fts5"
+	} else {
+		s.ftsModule = "fts4"
+	}
+}
+
+// Search runs a full-text query over all of a user's notes, most relevant first
+func (s *SQLStore) Search(userID int, query string, filters models.SearchFilters) ([]models.SearchResult, error) {
+	limit := filters.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	var sqlQuery string
+	var args []interface{}
+
+	switch {
+	case s.dbType == Postgres:
+		if strings.TrimSpace(query) == "" {
+			return nil, nil
+		}
+		headlineOpts := "StartSel=" + highlightStart + ", StopSel=" + highlightEnd + ", MaxWords=35, MinWords=15"
+		sqlQuery = `SELECT n.id, n.user_id, n.notebook_id, nb.name, n.content, n.created_at,
+		                   ts_headline('english', n.content, q, ?), ts_rank(n.search_vector, q) AS rank
+		            FROM notes n
+		            JOIN notebooks nb ON nb.id = n.notebook_id,
+		                 websearch_to_tsquery('english', ?) q
+		            WHERE n.search_vector @@ q` + readableNote + liveNote
+		args = []interface{}{headlineOpts, query, userID}
+
+	case s.ftsModule == "fts5":
+		match := ftsMatchQuery(query)
+		if match == "" {
+			return nil, nil
+		}
+		// bm25() is lower for better matches, so negate it for the rank
+		sqlQuery = `SELECT n.id, n.user_id, n.notebook_id, nb.name, n.content, n.created_at,
+		                   snippet(notes_fts, 0, char(2), char(3), '…', 16), -bm25(notes_fts) AS rank
+		            FROM notes_fts
+		            JOIN notes n ON n.id = notes_fts.rowid
+		            JOIN notebooks nb ON nb.id = n.notebook_id
+		            WHERE notes_fts MATCH ?` + readableNote + liveNote
+		args = []interface{}{match, userID}
+
+	default:
+		match := ftsMatchQuery(query)
+		if match == "" {
+			return nil, nil
+		}
+		// FTS4 has no built-in ranking; offsets() lists one 4-tuple per hit,
+		// which is ranked by hit count in Go below
+		sqlQuery = `SELECT n.id, n.user_id, n.notebook_id, nb.name, n.content, n.created_at,
+		                   snippet(notes_fts, char(2), char(3), '…', -1, 16), offsets(notes_fts)
+		            FROM notes_fts
+		            JOIN notes n ON n.id = notes_fts.docid
+		            JOIN notebooks nb ON nb.id = n.notebook_id
+		            WHERE notes_fts MATCH ?` + readableNote + liveNote
+		args = []interface{}{match, userID}
+	}
+
+	sqlQuery, args = applySearchFilters(sqlQuery, args, filters)
+	if s.ftsModule != "fts4" {
+		sqlQuery += " ORDER BY rank DESC, n.created_at DESC LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(s.rebind(sqlQuery), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		var r models.SearchResult
+		var rank interface{}
+		if err := rows.Scan(&r.ID, &r.UserID, &r.NotebookID, &r.NotebookName, &r.Content, &r.CreatedAt, &r.Snippet, &rank); err != nil {
+			return nil, err
+		}
+		switch v := rank.(type) {
+		case float64:
+			r.Rank = v
+		case []byte:
+			r.Rank = float64(len(strings.Fields(string(v))) / 4)
+		case string:
+			r.Rank = float64(len(strings.Fields(v)) / 4)
+		}
+		r.Snippet = formatSnippet(r.Snippet)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if s.ftsModule == "fts4" {
+		sort.SliceStable(results, func(i, j int) bool {
+			if results[i].Rank != results[j].Rank {
+				return results[i].Rank > results[j].Rank
+			}
+			return results[i].CreatedAt.After(results[j].CreatedAt)
+		})
+		if len(results) > limit {
+			results = results[:limit]
+		}
+	}
+	return results, nil
+}
+
+// applySearchFilters adds the conditions for filters to a notes query
+// (aliased as n)
+func applySearchFilters(query string, args []interface{}, filters models.SearchFilters) (string, []interface{}) {
+	if filters.NotebookID != 0 {
+		query += " AND n.notebook_id = ?"
+		args = append(args, filters.NotebookID)
+	}
+	if len(filters.NotebookIDs) > 0 {
+		placeholders := make([]string, len(filters.NotebookIDs))
+		for i, id := range filters.NotebookIDs {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		query += " AND n.notebook_id IN (" + strings.Join(placeholders, ",") + ")"
+	}
+	if filters.Tag != "" {
+		query += tagFilter
+		args = append(args, normalizeTag(filters.Tag))
+	}
+	if !filters.Start.IsZero() {
+		query += " AND n.created_at >= ?"
+		args = append(args, filters.Start.Local()) // Timestamps are stored in server local time
+	}
+	if !filters.End.IsZero() {
+		query += " AND n.created_at <= ?"
+		args = append(args, filters.End.Local())
+	}
+	return query, args
+}
+
+// ftsMatchQuery turns free text into an FTS MATCH expression where every word
+// is a quoted term, so user input can't trip over the query syntax
+func ftsMatchQuery(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		word = strings.ReplaceAll(word, `"`, "")
+		if word != "" {
+			terms = append(terms, `"`+word+`"`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// formatSnippet escapes a raw snippet for HTML and turns the highlight
+// markers into <mark> tags
+func formatSnippet(raw string) string {
+	escaped := html.EscapeString(raw)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, highlightEnd, "</mark>")
+}
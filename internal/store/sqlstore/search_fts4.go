@@ -0,0 +1,21 @@
+//go:build !sqlite_fts5
+
+package sqlstore
+
+// sqliteSearchUp indexes notes with an external-content FTS4 table, used when
+// the SQLite driver is built without the sqlite_fts5 tag
+const sqliteSearchUp = `
+CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts4(content='notes', content, tokenize=porter);
+CREATE TRIGGER IF NOT EXISTS notes_fts_ai AFTER INSERT ON notes BEGIN
+	INSERT INTO notes_fts(docid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER IF NOT EXISTS notes_fts_bd BEFORE DELETE ON notes BEGIN
+	DELETE FROM notes_fts WHERE docid = old.id;
+END;
+CREATE TRIGGER IF NOT EXISTS notes_fts_bu BEFORE UPDATE OF content ON notes BEGIN
+	DELETE FROM notes_fts WHERE docid = old.id;
+END;
+CREATE TRIGGER IF NOT EXISTS notes_fts_au AFTER UPDATE OF content ON notes BEGIN
+	INSERT INTO notes_fts(docid, content) VALUES (new.id, new.content);
+END;
+INSERT INTO notes_fts(notes_fts) VALUES ('rebuild');`
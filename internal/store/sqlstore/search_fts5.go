@@ -0,0 +1,19 @@
+//go:build sqlite_fts5
+
+package sqlstore
+
+// sqliteSearchUp indexes notes with an external-content FTS5 table kept in
+// sync by triggers
+const sqliteSearchUp = `
+CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(content, content='notes', content_rowid='id', tokenize='porter unicode61');
+CREATE TRIGGER IF NOT EXISTS notes_fts_ai AFTER INSERT ON notes BEGIN
+	INSERT INTO notes_fts(rowid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER IF NOT EXISTS notes_fts_ad AFTER DELETE ON notes BEGIN
+	INSERT INTO notes_fts(notes_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+CREATE TRIGGER IF NOT EXISTS notes_fts_au AFTER UPDATE OF content ON notes BEGIN
+	INSERT INTO notes_fts(notes_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	INSERT INTO notes_fts(rowid, content) VALUES (new.id, new.content);
+END;
+INSERT INTO notes_fts(notes_fts) VALUES ('rebuild');`
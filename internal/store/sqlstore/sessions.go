@@ -0,0 +1,80 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	"tracky/internal/models"
+)
+
+func (s *SQLStore) CreateSession(userID int, tokenHash, userAgent, ipAddress string, expiresAt time.Time) (int64, error) {
+	now := time.Now()
+	query := "INSERT INTO sessions (user_id, token_hash, user_agent, ip_address, created_at, last_seen_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	args := []interface{}{userID, tokenHash, userAgent, ipAddress, now, now, expiresAt.Local()}
+
+	if s.dbType == Postgres {
+		var id int64
+		err := s.db.QueryRow(s.rebind(query+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+	result, err := s.db.Exec(s.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *SQLStore) GetSessionByToken(tokenHash string) (models.Session, error) {
+	var sess models.Session
+	err := s.db.QueryRow(s.rebind("SELECT id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at FROM sessions WHERE token_hash = ? AND expires_at > ?"), tokenHash, time.Now()).
+		Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.IPAddress, &sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt)
+	return sess, err
+}
+
+func (s *SQLStore) TouchSession(sessionID int, lastSeenAt time.Time) error {
+	_, err := s.db.Exec(s.rebind("UPDATE sessions SET last_seen_at = ? WHERE id = ?"), lastSeenAt.Local(), sessionID)
+	return err
+}
+
+// GetSessions lists the user's unexpired sessions, most recently used first
+func (s *SQLStore) GetSessions(userID int) ([]models.Session, error) {
+	rows, err := s.db.Query(s.rebind("SELECT id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY last_seen_at DESC, id DESC"), userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var sess models.Session
+		if err := rows.Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.IPAddress, &sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *SQLStore) DeleteSession(sessionID, userID int) error {
+	result, err := s.db.Exec(s.rebind("DELETE FROM sessions WHERE id = ? AND user_id = ?"), sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteUserSessions(userID int) error {
+	_, err := s.db.Exec(s.rebind("DELETE FROM sessions WHERE user_id = ?"), userID)
+	return err
+}
+
+func (s *SQLStore) DeleteExpiredSessions(now time.Time) error {
+	if _, err := s.db.Exec(s.rebind("DELETE FROM sessions WHERE expires_at <= ?"), now.Local()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(s.rebind("DELETE FROM login_challenges WHERE expires_at <= ?"), now.Local())
+	return err
+}
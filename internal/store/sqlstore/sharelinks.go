@@ -0,0 +1,97 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	"tracky/internal/models"
+)
+
+func (s *SQLStore) CreateShareLink(userID int, link models.ShareLink, tokenHash string) (int64, error) {
+	var notebookID, noteID sql.NullInt64
+	if link.NotebookID != 0 {
+		notebookID = sql.NullInt64{Int64: int64(link.NotebookID), Valid: true}
+	}
+	if link.NoteID != 0 {
+		noteID = sql.NullInt64{Int64: int64(link.NoteID), Valid: true}
+	}
+	var expires sql.NullTime
+	if link.ExpiresAt != nil {
+		expires = sql.NullTime{Time: link.ExpiresAt.Local(), Valid: true}
+	}
+	query := "INSERT INTO share_links (user_id, notebook_id, note_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)"
+	args := []interface{}{userID, notebookID, noteID, tokenHash, time.Now(), expires}
+
+	if s.dbType == Postgres {
+		var id int64
+		err := s.db.QueryRow(s.rebind(query+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+	result, err := s.db.Exec(s.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *SQLStore) GetShareLinkByHash(tokenHash string) (models.ShareLink, error) {
+	row := s.db.QueryRow(s.rebind("SELECT id, user_id, notebook_id, note_id, created_at, expires_at FROM share_links WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > ?)"), tokenHash, time.Now())
+	return scanShareLink(row)
+}
+
+// GetShareLinkImage returns the filename of an image on one of the link's
+// notes, as long as the link's creator can still read it
+func (s *SQLStore) GetShareLinkImage(link models.ShareLink, imageID int) (string, error) {
+	var filename string
+	query := `SELECT ni.filename FROM note_images ni
+	          JOIN notes n ON ni.note_id = n.id
+	          WHERE ni.id = ? AND ni.deleted_at IS NULL AND (n.id = ? OR n.notebook_id = ?)` + readableNote + liveNote
+	err := s.db.QueryRow(s.rebind(query), imageID, link.NoteID, link.NotebookID, link.UserID).Scan(&filename)
+	return filename, err
+}
+
+// GetShareLinks lists all of the user's share links, including expired ones,
+// newest first
+func (s *SQLStore) GetShareLinks(userID int) ([]models.ShareLink, error) {
+	rows, err := s.db.Query(s.rebind("SELECT id, user_id, notebook_id, note_id, created_at, expires_at FROM share_links WHERE user_id = ? ORDER BY created_at DESC, id DESC"), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []models.ShareLink
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *SQLStore) DeleteShareLink(linkID, userID int) error {
+	result, err := s.db.Exec(s.rebind("DELETE FROM share_links WHERE id = ? AND user_id = ?"), linkID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanShareLink(row interface{ Scan(...interface{}) error }) (models.ShareLink, error) {
+	var link models.ShareLink
+	var notebookID, noteID sql.NullInt64
+	var expires sql.NullTime
+	if err := row.Scan(&link.ID, &link.UserID, &notebookID, &noteID, &link.CreatedAt, &expires); err != nil {
+		return link, err
+	}
+	link.NotebookID = int(notebookID.Int64)
+	link.NoteID = int(noteID.Int64)
+	if expires.Valid {
+		link.ExpiresAt = &expires.Time
+	}
+	return link, nil
+}
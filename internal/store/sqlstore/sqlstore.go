@@ -1,6 +1,7 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -23,31 +24,47 @@ const (
 
 // SQLStore implements the Store interface for SQL databases
 type SQLStore struct {
-	db     *sql.DB
-	dbType DBType
+	db        *sql.DB
+	dbType    DBType
+	ftsModule string // "fts5" or "fts4" on SQLite, empty on Postgres
+	pgvector  bool   // Postgres stores embeddings as pgvector vectors rather than arrays
 }
 
-// New creates a new SQLStore with the given driver and connection string
+// New creates a new SQLStore with the given driver and connection string,
+// applying any pending schema migrations
 func New(driver, connStr string) (*SQLStore, error) {
-	db, err := sql.Open(driver, connStr)
+	store, err := Open(driver, connStr)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := store.MigrateUp(); err != nil {
+		store.Close()
 		return nil, err
 	}
+	store.detectSearchModule()
+	store.detectVectorType()
 
-	store := &SQLStore{
-		db:     db,
-		dbType: DBType(driver),
+	return store, nil
+}
+
+// Open connects to the database without touching the schema, for tools such
+// as the migrate command that manage migrations themselves
+func Open(driver, connStr string) (*SQLStore, error) {
+	db, err := sql.Open(driver, connStr)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := store.initSchema(); err != nil {
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	return store, nil
+	return &SQLStore{
+		db:     db,
+		dbType: DBType(driver),
+	}, nil
 }
 
 // rebind converts ? placeholders to $1, $2, etc. for PostgreSQL
@@ -69,97 +86,15 @@ func (s *SQLStore) rebind(query string) string {
 	return result.String()
 }
 
-func (s *SQLStore) initSchema() error {
-	var createUsersTable, createNotebooksTable, createNotesTable, createNoteImagesTable string
-
-	if s.dbType == Postgres {
-		createUsersTable = `
-		CREATE TABLE IF NOT EXISTS users (
-			id SERIAL PRIMARY KEY,
-			username TEXT NOT NULL UNIQUE,
-			password_hash TEXT NOT NULL
-		);`
-
-		createNotebooksTable = `
-		CREATE TABLE IF NOT EXISTS notebooks (
-			id SERIAL PRIMARY KEY,
-			user_id INTEGER NOT NULL REFERENCES users(id),
-			name TEXT NOT NULL,
-			created_at TIMESTAMP NOT NULL
-		);`
-
-		createNotesTable = `
-		CREATE TABLE IF NOT EXISTS notes (
-			id SERIAL PRIMARY KEY,
-			user_id INTEGER NOT NULL REFERENCES users(id),
-			notebook_id INTEGER REFERENCES notebooks(id),
-			content TEXT NOT NULL,
-			created_at TIMESTAMP NOT NULL
-		);`
-
-		createNoteImagesTable = `
-		CREATE TABLE IF NOT EXISTS note_images (
-			id SERIAL PRIMARY KEY,
-			note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
-			filename TEXT NOT NULL,
-			created_at TIMESTAMP NOT NULL
-		);`
-	} else {
-		createUsersTable = `
-		CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT NOT NULL UNIQUE,
-			password_hash TEXT NOT NULL
-		);`
-
-		createNotebooksTable = `
-		CREATE TABLE IF NOT EXISTS notebooks (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER NOT NULL,
-			name TEXT NOT NULL,
-			created_at DATETIME NOT NULL,
-			FOREIGN KEY(user_id) REFERENCES users(id)
-		);`
-
-		createNotesTable = `
-		CREATE TABLE IF NOT EXISTS notes (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER NOT NULL,
-			notebook_id INTEGER,
-			content TEXT NOT NULL,
-			created_at DATETIME NOT NULL,
-			FOREIGN KEY(user_id) REFERENCES users(id),
-			FOREIGN KEY(notebook_id) REFERENCES notebooks(id)
-		);`
-
-		createNoteImagesTable = `
-		CREATE TABLE IF NOT EXISTS note_images (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			note_id INTEGER NOT NULL,
-			filename TEXT NOT NULL,
-			created_at DATETIME NOT NULL,
-			FOREIGN KEY(note_id) REFERENCES notes(id) ON DELETE CASCADE
-		);`
-	}
-
-	for _, stmt := range []string{createUsersTable, createNotebooksTable, createNotesTable, createNoteImagesTable} {
-		if _, err := s.db.Exec(stmt); err != nil {
-			return err
-		}
-	}
-
-	// SQLite migration for existing DBs
-	if s.dbType == SQLite {
-		s.db.Exec("ALTER TABLE notes ADD COLUMN notebook_id INTEGER")
-	}
-
-	return nil
-}
-
 func (s *SQLStore) Close() error {
 	return s.db.Close()
 }
 
+// DBType returns the database's dialect
+func (s *SQLStore) DBType() DBType {
+	return s.dbType
+}
+
 // User functions
 func (s *SQLStore) CreateUser(username, passwordHash string) error {
 	_, err := s.db.Exec(s.rebind("INSERT INTO users (username, password_hash) VALUES (?, ?)"), username, passwordHash)
@@ -180,25 +115,47 @@ func (s *SQLStore) GetUserID(username string) (int, error) {
 }
 
 // Notebook functions
+
+// CreateNotebook creates a notebook with the user as its owner
 func (s *SQLStore) CreateNotebook(userID int, name string) (int64, error) {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var id int64
 	if s.dbType == Postgres {
-		var id int64
-		err := s.db.QueryRow(s.rebind("INSERT INTO notebooks (user_id, name, created_at) VALUES (?, ?, ?) RETURNING id"), userID, name, time.Now()).Scan(&id)
-		return id, err
+		err = tx.QueryRow(s.rebind("INSERT INTO notebooks (user_id, name, created_at) VALUES (?, ?, ?) RETURNING id"), userID, name, now).Scan(&id)
+	} else {
+		var result sql.Result
+		result, err = tx.Exec(s.rebind("INSERT INTO notebooks (user_id, name, created_at) VALUES (?, ?, ?)"), userID, name, now)
+		if err == nil {
+			id, err = result.LastInsertId()
+		}
 	}
-	result, err := s.db.Exec(s.rebind("INSERT INTO notebooks (user_id, name, created_at) VALUES (?, ?, ?)"), userID, name, time.Now())
 	if err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+
+	if _, err := tx.Exec(s.rebind("INSERT INTO notebook_members (notebook_id, user_id, role, created_at) VALUES (?, ?, ?, ?)"), id, userID, models.RoleOwner, now); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
 }
 
 func (s *SQLStore) CreateDefaultNotebook(userID int) (int64, error) {
 	return s.CreateNotebook(userID, "Default")
 }
 
+// GetNotebooks lists the notebooks the user owns or has been shared, with
+// their role in each
 func (s *SQLStore) GetNotebooks(userID int) ([]models.Notebook, error) {
-	rows, err := s.db.Query(s.rebind("SELECT id, name, created_at FROM notebooks WHERE user_id = ? ORDER BY created_at ASC"), userID)
+	query := `SELECT nb.id, nb.user_id, nb.name, nb.created_at, m.role FROM notebooks nb
+	          JOIN notebook_members m ON m.notebook_id = nb.id
+	          WHERE m.user_id = ? AND nb.deleted_at IS NULL ORDER BY nb.created_at ASC`
+	rows, err := s.db.Query(s.rebind(query), userID)
 	if err != nil {
 		return nil, err
 	}
@@ -207,8 +164,7 @@ func (s *SQLStore) GetNotebooks(userID int) ([]models.Notebook, error) {
 	var notebooks []models.Notebook
 	for rows.Next() {
 		var nb models.Notebook
-		nb.UserID = userID
-		if err := rows.Scan(&nb.ID, &nb.Name, &nb.CreatedAt); err != nil {
+		if err := rows.Scan(&nb.ID, &nb.UserID, &nb.Name, &nb.CreatedAt, &nb.Role); err != nil {
 			log.Printf("Error scanning notebook: %v", err)
 			continue
 		}
@@ -219,12 +175,13 @@ func (s *SQLStore) GetNotebooks(userID int) ([]models.Notebook, error) {
 
 func (s *SQLStore) GetNotebookByName(userID int, name string) (int, error) {
 	var id int
-	err := s.db.QueryRow(s.rebind("SELECT id FROM notebooks WHERE user_id = ? AND name = ?"), userID, name).Scan(&id)
+	err := s.db.QueryRow(s.rebind("SELECT id FROM notebooks WHERE user_id = ? AND name = ? AND deleted_at IS NULL"), userID, name).Scan(&id)
 	return id, err
 }
 
+// DeleteNotebook moves a notebook to the trash; its notes are hidden with it
 func (s *SQLStore) DeleteNotebook(notebookID, userID int) error {
-	result, err := s.db.Exec(s.rebind("DELETE FROM notebooks WHERE id = ? AND user_id = ?"), notebookID, userID)
+	result, err := s.db.Exec(s.rebind("UPDATE notebooks SET deleted_at = ? WHERE id = ? AND user_id = ? AND deleted_at IS NULL"), time.Now(), notebookID, userID)
 	if err != nil {
 		return err
 	}
@@ -232,19 +189,49 @@ func (s *SQLStore) DeleteNotebook(notebookID, userID int) error {
 	if rowsAffected == 0 {
 		return sql.ErrNoRows
 	}
-	// Also delete notes in this notebook
-	s.db.Exec(s.rebind("DELETE FROM notes WHERE notebook_id = ?"), notebookID)
 	return nil
 }
 
 // Note functions
-func (s *SQLStore) CreateNote(userID, notebookID int, content string) error {
-	_, err := s.db.Exec(s.rebind("INSERT INTO notes (user_id, notebook_id, content, created_at) VALUES (?, ?, ?, ?)"), userID, notebookID, content, time.Now())
-	return err
+func (s *SQLStore) CreateNote(userID, notebookID int, content string) (int64, error) {
+	return s.CreateNoteAt(userID, notebookID, content, time.Now())
+}
+
+// CreateNoteAt creates a note with the given creation time, for imports
+func (s *SQLStore) CreateNoteAt(userID, notebookID int, content string, createdAt time.Time) (int64, error) {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := createdAt.Local()
+	var id int64
+	if s.dbType == Postgres {
+		err = tx.QueryRow(s.rebind("INSERT INTO notes (user_id, notebook_id, content, created_at) VALUES (?, ?, ?, ?) RETURNING id"), userID, notebookID, content, now).Scan(&id)
+	} else {
+		var result sql.Result
+		result, err = tx.Exec(s.rebind("INSERT INTO notes (user_id, notebook_id, content, created_at) VALUES (?, ?, ?, ?)"), userID, notebookID, content, now)
+		if err == nil {
+			id, err = result.LastInsertId()
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.syncNoteTags(ctx, tx, userID, id, content); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(s.rebind("INSERT INTO note_revisions (note_id, content, created_at) VALUES (?, ?, ?)"), id, content, now); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
 }
 
 func (s *SQLStore) GetNotes(userID, notebookID int) ([]models.Note, error) {
-	rows, err := s.db.Query(s.rebind("SELECT id, content, created_at FROM notes WHERE user_id = ? AND notebook_id = ? ORDER BY created_at DESC"), userID, notebookID)
+	rows, err := s.db.Query(s.rebind("SELECT n.id, n.user_id, n.content, n.created_at FROM notes n WHERE n.notebook_id = ?"+readableNote+liveNote+" ORDER BY n.created_at DESC"), notebookID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -253,9 +240,49 @@ func (s *SQLStore) GetNotes(userID, notebookID int) ([]models.Note, error) {
 	var notes []models.Note
 	for rows.Next() {
 		var n models.Note
-		n.UserID = userID
 		n.NotebookID = notebookID
-		if err := rows.Scan(&n.ID, &n.Content, &n.CreatedAt); err != nil {
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Content, &n.CreatedAt); err != nil {
+			continue
+		}
+		notes = append(notes, n)
+	}
+	return notes, nil
+}
+
+func (s *SQLStore) GetNote(noteID, userID int) (models.Note, error) {
+	n := models.Note{ID: noteID}
+	err := s.db.QueryRow(s.rebind("SELECT n.user_id, n.notebook_id, n.content, n.created_at FROM notes n WHERE n.id = ?"+readableNote+liveNote), noteID, userID).Scan(&n.UserID, &n.NotebookID, &n.Content, &n.CreatedAt)
+	return n, err
+}
+
+func (s *SQLStore) GetNotesPage(userID, notebookID int, opts models.NoteListOptions) ([]models.Note, error) {
+	query := "SELECT n.id, n.user_id, n.content, n.created_at FROM notes n WHERE n.notebook_id = ?" + readableNote + liveNote
+	args := []interface{}{notebookID, userID}
+	if opts.After != nil {
+		query += " AND (n.created_at < ? OR (n.created_at = ? AND n.id < ?))"
+		args = append(args, opts.After.CreatedAt, opts.After.CreatedAt, opts.After.ID)
+	}
+	if opts.Tag != "" {
+		query += tagFilter
+		args = append(args, normalizeTag(opts.Tag))
+	}
+	query += " ORDER BY n.created_at DESC, n.id DESC"
+	if opts.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, opts.Limit)
+	}
+
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		var n models.Note
+		n.NotebookID = notebookID
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Content, &n.CreatedAt); err != nil {
 			continue
 		}
 		notes = append(notes, n)
@@ -264,7 +291,9 @@ func (s *SQLStore) GetNotes(userID, notebookID int) ([]models.Note, error) {
 }
 
 func (s *SQLStore) GetNotesByTimeRange(userID, notebookID int, start, end time.Time) ([]models.Note, error) {
-	rows, err := s.db.Query(s.rebind("SELECT content, created_at FROM notes WHERE user_id = ? AND notebook_id = ? AND created_at >= ? AND created_at <= ? ORDER BY created_at DESC"), userID, notebookID, start, end)
+	// Timestamps are stored in server local time, so compare in it too
+	start, end = start.Local(), end.Local()
+	rows, err := s.db.Query(s.rebind("SELECT n.id, n.user_id, n.content, n.created_at FROM notes n WHERE n.notebook_id = ? AND n.created_at >= ? AND n.created_at <= ?"+readableNote+liveNote+" ORDER BY n.created_at DESC"), notebookID, start, end, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -273,7 +302,8 @@ func (s *SQLStore) GetNotesByTimeRange(userID, notebookID int, start, end time.T
 	var notes []models.Note
 	for rows.Next() {
 		var n models.Note
-		if err := rows.Scan(&n.Content, &n.CreatedAt); err != nil {
+		n.NotebookID = notebookID
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Content, &n.CreatedAt); err != nil {
 			continue
 		}
 		notes = append(notes, n)
@@ -281,20 +311,53 @@ func (s *SQLStore) GetNotesByTimeRange(userID, notebookID int, start, end time.T
 	return notes, nil
 }
 
+// UpdateNote changes a note in a notebook the user can edit. Its tags stay
+// with its author.
 func (s *SQLStore) UpdateNote(noteID, userID int, content string) error {
-	result, err := s.db.Exec(s.rebind("UPDATE notes SET content = ? WHERE id = ? AND user_id = ?"), content, noteID, userID)
+	return s.updateNote(noteID, userID, content, false)
+}
+
+// updateNote records a revision even when the content is unchanged if always
+// is set
+func (s *SQLStore) updateNote(noteID, userID int, content string, always bool) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	defer tx.Rollback()
+
+	var current string
+	var authorID int
+	err = tx.QueryRow(s.rebind("SELECT n.content, n.user_id FROM notes n WHERE n.id = ?"+writableNote+liveNote), noteID, userID).Scan(&current, &authorID)
+	if err != nil {
+		return err
 	}
-	return nil
+	if current == content && !always {
+		return nil
+	}
+
+	if current != content {
+		if _, err := tx.Exec(s.rebind("UPDATE notes SET content = ? WHERE id = ?"), content, noteID); err != nil {
+			return err
+		}
+		if err := s.syncNoteTags(ctx, tx, authorID, int64(noteID), content); err != nil {
+			return err
+		}
+		// The background indexer embeds the new content
+		if _, err := tx.Exec(s.rebind("DELETE FROM note_embeddings WHERE note_id = ?"), noteID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(s.rebind("INSERT INTO note_revisions (note_id, content, created_at) VALUES (?, ?, ?)"), noteID, content, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+// DeleteNote moves a note in a notebook the user can edit to the trash
 func (s *SQLStore) DeleteNote(noteID, userID int) error {
-	result, err := s.db.Exec(s.rebind("DELETE FROM notes WHERE id = ? AND user_id = ?"), noteID, userID)
+	result, err := s.db.Exec(s.rebind("UPDATE notes SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL AND notebook_id IN ("+writableNotebooks+")"), time.Now(), noteID, userID)
 	if err != nil {
 		return err
 	}
@@ -305,6 +368,44 @@ func (s *SQLStore) DeleteNote(noteID, userID int) error {
 	return nil
 }
 
+// Note Revision functions
+func (s *SQLStore) GetNoteRevisions(noteID, userID int) ([]models.NoteRevision, error) {
+	var owned int
+	err := s.db.QueryRow(s.rebind("SELECT n.id FROM notes n WHERE n.id = ?"+readableNote+liveNote), noteID, userID).Scan(&owned)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(s.rebind("SELECT id, content, created_at FROM note_revisions WHERE note_id = ? ORDER BY created_at ASC, id ASC"), noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.NoteRevision
+	for rows.Next() {
+		var rev models.NoteRevision
+		rev.NoteID = noteID
+		if err := rows.Scan(&rev.ID, &rev.Content, &rev.CreatedAt); err != nil {
+			continue
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, nil
+}
+
+// RestoreNoteRevision sets a note in a notebook the user can edit back to one
+// of its revisions. The restore is recorded as a new revision even when the
+// note already has that content, so that the history shows it.
+func (s *SQLStore) RestoreNoteRevision(revisionID, noteID, userID int) error {
+	var content string
+	err := s.db.QueryRow(s.rebind("SELECT content FROM note_revisions WHERE id = ? AND note_id = ?"), revisionID, noteID).Scan(&content)
+	if err != nil {
+		return err
+	}
+	return s.updateNote(noteID, userID, content, true)
+}
+
 // Note Image functions
 func (s *SQLStore) CreateNoteImage(noteID int, filename string) (int64, error) {
 	if s.dbType == Postgres {
@@ -320,7 +421,7 @@ func (s *SQLStore) CreateNoteImage(noteID int, filename string) (int64, error) {
 }
 
 func (s *SQLStore) GetNoteImages(noteID int) ([]models.NoteImage, error) {
-	rows, err := s.db.Query(s.rebind("SELECT id, filename, created_at FROM note_images WHERE note_id = ? ORDER BY created_at ASC"), noteID)
+	rows, err := s.db.Query(s.rebind("SELECT id, filename, created_at FROM note_images WHERE note_id = ? AND deleted_at IS NULL ORDER BY created_at ASC"), noteID)
 	if err != nil {
 		return nil, err
 	}
@@ -338,13 +439,17 @@ func (s *SQLStore) GetNoteImages(noteID int) ([]models.NoteImage, error) {
 	return images, nil
 }
 
-func (s *SQLStore) DeleteNoteImage(imageID int) (string, error) {
+// DeleteNoteImage moves an image on a note the user can edit to the trash;
+// its file is kept until purged
+func (s *SQLStore) DeleteNoteImage(imageID, userID int) (string, error) {
 	var filename string
-	err := s.db.QueryRow(s.rebind("SELECT filename FROM note_images WHERE id = ?"), imageID).Scan(&filename)
-	if err != nil {
+	query := `SELECT ni.filename FROM note_images ni
+	          JOIN notes n ON ni.note_id = n.id
+	          WHERE ni.id = ? AND ni.deleted_at IS NULL` + writableNote + liveNote
+	if err := s.db.QueryRow(s.rebind(query), imageID, userID).Scan(&filename); err != nil {
 		return "", err
 	}
-	_, err = s.db.Exec(s.rebind("DELETE FROM note_images WHERE id = ?"), imageID)
+	_, err := s.db.Exec(s.rebind("UPDATE note_images SET deleted_at = ? WHERE id = ?"), time.Now(), imageID)
 	if err != nil {
 		return "", err
 	}
@@ -353,9 +458,9 @@ func (s *SQLStore) DeleteNoteImage(imageID int) (string, error) {
 
 func (s *SQLStore) GetNoteImageWithOwner(imageID, userID int) (string, error) {
 	var filename string
-	query := `SELECT ni.filename FROM note_images ni 
-	          JOIN notes n ON ni.note_id = n.id 
-	          WHERE ni.id = ? AND n.user_id = ?`
+	query := `SELECT ni.filename FROM note_images ni
+	          JOIN notes n ON ni.note_id = n.id
+	          WHERE ni.id = ? AND ni.deleted_at IS NULL` + readableNote + liveNote
 	err := s.db.QueryRow(s.rebind(query), imageID, userID).Scan(&filename)
 	return filename, err
 }
@@ -377,7 +482,7 @@ func (s *SQLStore) GetNoteImagesByNoteIDs(noteIDs []int) (map[int][]models.NoteI
 		args[i] = id
 	}
 
-	query := fmt.Sprintf("SELECT id, note_id, filename, created_at FROM note_images WHERE note_id IN (%s) ORDER BY created_at ASC", strings.Join(placeholders, ","))
+	query := fmt.Sprintf("SELECT id, note_id, filename, created_at FROM note_images WHERE note_id IN (%s) AND deleted_at IS NULL ORDER BY created_at ASC", strings.Join(placeholders, ","))
 
 	rows, err := s.db.Query(query, args...)
 	if err != nil {
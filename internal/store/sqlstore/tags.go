@@ -0,0 +1,157 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"tracky/internal/models"
+)
+
+// A hashtag starts at the beginning of the text or after a character that
+// can't be part of a word, URL fragment or HTML entity (e.g. "&#39;")
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&#/])#([\p{L}\p{N}_][\p{L}\p{N}_/-]*)`)
+
+// extractTags returns the distinct lowercased #hashtags in content, in order
+// of first appearance. Purely numeric tags such as "#1" are ignored.
+func extractTags(content string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, m := range hashtagPattern.FindAllStringSubmatch(content, -1) {
+		tag := strings.ToLower(strings.TrimRight(m[1], "/-"))
+		if tag == "" || seen[tag] || !strings.ContainsFunc(tag, unicode.IsLetter) {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// syncNoteTags replaces a note's tags with the hashtags found in its content
+// and drops tags the user no longer uses
+func (s *SQLStore) syncNoteTags(ctx context.Context, q queryExecer, userID int, noteID int64, content string) error {
+	if _, err := q.ExecContext(ctx, s.rebind("DELETE FROM note_tags WHERE note_id = ?"), noteID); err != nil {
+		return err
+	}
+
+	for _, tag := range extractTags(content) {
+		_, err := q.ExecContext(ctx, s.rebind("INSERT INTO tags (user_id, name) VALUES (?, ?) ON CONFLICT (user_id, name) DO NOTHING"), userID, tag)
+		if err != nil {
+			return err
+		}
+		var tagID int64
+		err = q.QueryRowContext(ctx, s.rebind("SELECT id FROM tags WHERE user_id = ? AND name = ?"), userID, tag).Scan(&tagID)
+		if err != nil {
+			return err
+		}
+		_, err = q.ExecContext(ctx, s.rebind("INSERT INTO note_tags (note_id, tag_id) VALUES (?, ?)"), noteID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := q.ExecContext(ctx, s.rebind("DELETE FROM tags WHERE user_id = ? AND id NOT IN (SELECT tag_id FROM note_tags)"), userID)
+	return err
+}
+
+// backfillTags extracts tags from notes written before tags existed
+func (s *SQLStore) backfillTags(ctx context.Context, q queryExecer) error {
+	rows, err := q.QueryContext(ctx, "SELECT id, user_id, content FROM notes WHERE content LIKE '%#%'")
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		id      int64
+		userID  int
+		content string
+	}
+	// Read everything first: Postgres can't run queries while rows are open
+	var notes []pending
+	for rows.Next() {
+		var n pending
+		if err := rows.Scan(&n.id, &n.userID, &n.content); err != nil {
+			rows.Close()
+			return err
+		}
+		notes = append(notes, n)
+	}
+	rows.Close()
+
+	for _, n := range notes {
+		if err := s.syncNoteTags(ctx, q, n.userID, n.id, n.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTags lists the tags on notes the user can read, whoever wrote them,
+// with how many notes use each, most used first
+func (s *SQLStore) GetTags(userID int) ([]models.Tag, error) {
+	query := `SELECT t.name, COUNT(DISTINCT nt.note_id) AS note_count
+	          FROM tags t
+	          JOIN note_tags nt ON nt.tag_id = t.id
+	          JOIN notes n ON n.id = nt.note_id
+	          WHERE n.notebook_id IN (` + readableNotebooks + `)` + liveNote + `
+	          GROUP BY t.name
+	          ORDER BY note_count DESC, t.name ASC`
+	rows, err := s.db.Query(s.rebind(query), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.Tag
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.Name, &t.Count); err != nil {
+			continue
+		}
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
+
+func (s *SQLStore) GetTagsByNoteIDs(noteIDs []int) (map[int][]string, error) {
+	result := make(map[int][]string)
+	if len(noteIDs) == 0 {
+		return result, nil
+	}
+
+	placeholders := make([]string, len(noteIDs))
+	args := make([]interface{}, len(noteIDs))
+	for i, id := range noteIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	query := fmt.Sprintf(`SELECT nt.note_id, t.name FROM note_tags nt
+	                      JOIN tags t ON t.id = nt.tag_id
+	                      WHERE nt.note_id IN (%s) ORDER BY t.name ASC`, strings.Join(placeholders, ","))
+
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var noteID int
+		var name string
+		if err := rows.Scan(&noteID, &name); err != nil {
+			continue
+		}
+		result[noteID] = append(result[noteID], name)
+	}
+	return result, nil
+}
+
+// normalizeTag turns user input such as "#Work" into the stored form
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// tagFilter restricts a notes query (aliased as n) to notes with the tag
+const tagFilter = " AND n.id IN (SELECT nt.note_id FROM note_tags nt JOIN tags t ON t.id = nt.tag_id WHERE t.name = ?)"
@@ -0,0 +1,68 @@
+package sqlstore
+
+import (
+	"slices"
+	"testing"
+
+	"tracky/internal/models"
+)
+
+func TestExtractTags(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"#Work meeting about #roadmap-2025", []string{"work", "roadmap-2025"}},
+		{"Duplicate #idea and #IDEA", []string{"idea"}},
+		{"Issue #42 and https://example.com/page#section", nil},
+		{"It&#39;s not a tag, but (#this) is", []string{"this"}},
+		{"Nested #project/alpha/ tag", []string{"project/alpha"}},
+	}
+	for _, tt := range tests {
+		if got := extractTags(tt.content); !slices.Equal(got, tt.want) {
+			t.Errorf("extractTags(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestGetTagsSharedNotebook(t *testing.T) {
+	store, err := New("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.CreateUser("owner", "hash")
+	store.CreateUser("editor", "hash")
+	ownerID, _ := store.GetUserID("owner")
+	editorID, _ := store.GetUserID("editor")
+	notebookID, _ := store.CreateNotebook(ownerID, "Team")
+	privateID, _ := store.CreateNotebook(editorID, "Private")
+	if err := store.SetNotebookMember(int(notebookID), editorID, models.RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	store.CreateNote(ownerID, int(notebookID), "Kickoff #planning")
+	store.CreateNote(editorID, int(notebookID), "Follow-up #planning #hiring")
+	store.CreateNote(editorID, int(privateID), "Interview #hiring")
+
+	tags, err := store.GetTags(ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Tag{{Name: "planning", Count: 2}, {Name: "hiring", Count: 1}}
+	if !slices.Equal(tags, want) {
+		t.Errorf("Expected the editor's tags in the shared notebook, got %+v", tags)
+	}
+
+	if err := store.DeleteNotebookMember(int(notebookID), editorID); err != nil {
+		t.Fatal(err)
+	}
+	tags, err = store.GetTags(editorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []models.Tag{{Name: "hiring", Count: 1}}
+	if !slices.Equal(tags, want) {
+		t.Errorf("Expected only the removed member's own notebook, got %+v", tags)
+	}
+}
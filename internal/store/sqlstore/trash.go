@@ -0,0 +1,184 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"tracky/internal/models"
+	"tracky/internal/store"
+)
+
+// liveNote restricts a notes query (aliased as n) to notes that are neither
+// in the trash nor in a trashed notebook
+const liveNote = " AND n.deleted_at IS NULL AND n.notebook_id IN (SELECT id FROM notebooks WHERE deleted_at IS NULL)"
+
+// GetTrash lists the user's deleted notebooks, notes and images, most
+// recently deleted first. Trashed notes and images belong to everyone who
+// can write to their notebook, whoever wrote or deleted them; trashed
+// notebooks to their owner. Notes hidden only because their notebook was
+// deleted are not listed separately.
+func (s *SQLStore) GetTrash(userID int) ([]models.TrashItem, error) {
+	queries := []struct {
+		itemType string
+		query    string
+	}{
+		{"notebook", "SELECT id, 0, 0, name, deleted_at FROM notebooks WHERE user_id = ? AND deleted_at IS NOT NULL"},
+		{"note", "SELECT n.id, COALESCE(n.notebook_id, 0), 0, n.content, n.deleted_at FROM notes n WHERE n.deleted_at IS NOT NULL" + writableNote},
+		{"image", `SELECT ni.id, COALESCE(n.notebook_id, 0), ni.note_id, ni.filename, ni.deleted_at FROM note_images ni
+		           JOIN notes n ON ni.note_id = n.id
+		           WHERE ni.deleted_at IS NOT NULL` + writableNote},
+	}
+
+	var items []models.TrashItem
+	for _, q := range queries {
+		rows, err := s.db.Query(s.rebind(q.query), userID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			item := models.TrashItem{Type: q.itemType}
+			if err := rows.Scan(&item.ID, &item.NotebookID, &item.NoteID, &item.Preview, &item.DeletedAt); err != nil {
+				continue
+			}
+			items = append(items, item)
+		}
+		rows.Close()
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	return items, nil
+}
+
+// RestoreTrashItem takes an item out of the trash, along with the note and
+// notebook containing it if those were deleted too. Notes and images can be
+// restored by anyone who can write to their notebook, but a trashed notebook
+// only by its owner: restoring a note or image in one someone else owns
+// returns store.ErrNotebookTrashed.
+func (s *SQLStore) RestoreTrashItem(userID int, itemType string, id int) error {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var noteID, notebookID sql.NullInt64
+	switch itemType {
+	case "notebook":
+		notebookID = sql.NullInt64{Int64: int64(id), Valid: true}
+		var owned int
+		if err := tx.QueryRow(s.rebind("SELECT id FROM notebooks WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL"), id, userID).Scan(&owned); err != nil {
+			return err
+		}
+	case "note":
+		noteID = sql.NullInt64{Int64: int64(id), Valid: true}
+		if err := tx.QueryRow(s.rebind("SELECT n.notebook_id FROM notes n WHERE n.id = ? AND n.deleted_at IS NOT NULL"+writableNote), id, userID).Scan(&notebookID); err != nil {
+			return err
+		}
+	case "image":
+		query := `SELECT ni.note_id, n.notebook_id FROM note_images ni
+		          JOIN notes n ON ni.note_id = n.id
+		          WHERE ni.id = ? AND ni.deleted_at IS NOT NULL` + writableNote
+		if err := tx.QueryRow(s.rebind(query), id, userID).Scan(&noteID, &notebookID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(s.rebind("UPDATE note_images SET deleted_at = NULL WHERE id = ?"), id); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown trash item type %q", itemType)
+	}
+
+	if noteID.Valid {
+		if _, err := tx.Exec(s.rebind("UPDATE notes SET deleted_at = NULL WHERE id = ?"), noteID.Int64); err != nil {
+			return err
+		}
+	}
+	if notebookID.Valid {
+		var ownerID int
+		var deletedAt sql.NullTime
+		if err := tx.QueryRow(s.rebind("SELECT user_id, deleted_at FROM notebooks WHERE id = ?"), notebookID.Int64).Scan(&ownerID, &deletedAt); err != nil {
+			return err
+		}
+		if deletedAt.Valid {
+			if ownerID != userID {
+				return store.ErrNotebookTrashed
+			}
+			if _, err := tx.Exec(s.rebind("UPDATE notebooks SET deleted_at = NULL WHERE id = ?"), notebookID.Int64); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// PurgeTrash permanently deletes everything trashed before the cutoff,
+// including notes inside purged notebooks, and returns the filenames of the
+// purged images so their files can be removed
+func (s *SQLStore) PurgeTrash(deletedBefore time.Time) ([]string, error) {
+	return s.purge("deleted_at < ?", []interface{}{deletedBefore}, "SELECT id FROM notebooks WHERE deleted_at < ?", []interface{}{deletedBefore})
+}
+
+// PurgeNotebook permanently deletes a notebook the user owns that is in the
+// trash, with everything in it, and returns the filenames of its images
+func (s *SQLStore) PurgeNotebook(notebookID, userID int) ([]string, error) {
+	return s.purge("0 = 1", nil, "SELECT id FROM notebooks WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL", []interface{}{notebookID, userID})
+}
+
+// purge permanently deletes the notes and images matching trashed, the
+// notebooks listed by the notebooks query, and everything in those
+// notebooks, and returns the filenames of the purged images
+func (s *SQLStore) purge(trashed string, trashedArgs []interface{}, notebooks string, notebookArgs []interface{}) ([]string, error) {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	purgedNotes := "SELECT id FROM notes WHERE " + trashed + " OR notebook_id IN (" + notebooks + ")"
+	purgedImages := trashed + " OR note_id IN (" + purgedNotes + ")"
+	noteArgs := append(slices.Clone(trashedArgs), notebookArgs...)
+	imageArgs := append(slices.Clone(trashedArgs), noteArgs...)
+
+	rows, err := tx.Query(s.rebind("SELECT filename FROM note_images WHERE "+purgedImages), imageArgs...)
+	if err != nil {
+		return nil, err
+	}
+	var filenames []string
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		filenames = append(filenames, filename)
+	}
+	rows.Close()
+
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM note_images WHERE " + purgedImages, imageArgs},
+		{"DELETE FROM note_revisions WHERE note_id IN (" + purgedNotes + ")", noteArgs},
+		{"DELETE FROM note_tags WHERE note_id IN (" + purgedNotes + ")", noteArgs},
+		{"DELETE FROM note_embeddings WHERE note_id IN (" + purgedNotes + ")", noteArgs},
+		{"DELETE FROM share_links WHERE note_id IN (" + purgedNotes + ") OR notebook_id IN (" + notebooks + ")", append(slices.Clone(noteArgs), notebookArgs...)},
+		{"DELETE FROM notes WHERE id IN (" + purgedNotes + ")", noteArgs},
+		{"DELETE FROM conversation_messages WHERE conversation_id IN (SELECT id FROM conversations WHERE notebook_id IN (" + notebooks + "))", notebookArgs},
+		{"DELETE FROM conversations WHERE notebook_id IN (" + notebooks + ")", notebookArgs},
+		{"DELETE FROM digest_notebooks WHERE notebook_id IN (" + notebooks + ")", notebookArgs},
+		{"DELETE FROM notebook_members WHERE notebook_id IN (" + notebooks + ")", notebookArgs},
+		{"DELETE FROM notebooks WHERE id IN (" + notebooks + ")", notebookArgs},
+		{"DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM note_tags)", nil},
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(s.rebind(stmt.query), stmt.args...); err != nil {
+			return nil, err
+		}
+	}
+	return filenames, tx.Commit()
+}
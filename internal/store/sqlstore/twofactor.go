@@ -0,0 +1,110 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+func (s *SQLStore) GetTOTP(userID int) (string, bool, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := s.db.QueryRow(s.rebind("SELECT totp_secret, totp_enabled FROM users WHERE id = ?"), userID).Scan(&secret, &enabled)
+	return secret.String, enabled, err
+}
+
+// SetTOTPSecret stores a secret awaiting verification; two-factor
+// authentication stays off until EnableTOTP
+func (s *SQLStore) SetTOTPSecret(userID int, secret string) error {
+	_, err := s.db.Exec(s.rebind("UPDATE users SET totp_secret = ?, totp_enabled = ?, totp_last_step = 0 WHERE id = ?"), secret, false, userID)
+	return err
+}
+
+func (s *SQLStore) EnableTOTP(userID int, step int64, recoveryCodeHashes []string) error {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.rebind("UPDATE users SET totp_enabled = ?, totp_last_step = ? WHERE id = ?"), true, step, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.Exec(s.rebind("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)"), userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) DisableTOTP(userID int) error {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.rebind("UPDATE users SET totp_secret = NULL, totp_enabled = ?, totp_last_step = 0 WHERE id = ?"), false, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.rebind("DELETE FROM login_challenges WHERE user_id = ?"), userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code for the time step was used, so the same
+// code can't be replayed
+func (s *SQLStore) UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := s.db.Exec(s.rebind("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?"), step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (s *SQLStore) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	result, err := s.db.Exec(s.rebind("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL"), time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLStore) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(s.rebind("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL"), userID).Scan(&count)
+	return count, err
+}
+
+// CreateLoginChallenge stores a half-finished login awaiting the second
+// factor
+func (s *SQLStore) CreateLoginChallenge(userID int, tokenHash string, expiresAt time.Time) error {
+	_, err := s.db.Exec(s.rebind("INSERT INTO login_challenges (user_id, token_hash, expires_at) VALUES (?, ?, ?)"), userID, tokenHash, expiresAt.Local())
+	return err
+}
+
+func (s *SQLStore) GetLoginChallenge(tokenHash string) (int, int, error) {
+	var userID, attempts int
+	err := s.db.QueryRow(s.rebind("SELECT user_id, attempts FROM login_challenges WHERE token_hash = ? AND expires_at > ?"), tokenHash, time.Now()).Scan(&userID, &attempts)
+	return userID, attempts, err
+}
+
+func (s *SQLStore) FailLoginChallenge(tokenHash string) error {
+	_, err := s.db.Exec(s.rebind("UPDATE login_challenges SET attempts = attempts + 1 WHERE token_hash = ?"), tokenHash)
+	return err
+}
+
+func (s *SQLStore) DeleteLoginChallenge(tokenHash string) error {
+	_, err := s.db.Exec(s.rebind("DELETE FROM login_challenges WHERE token_hash = ?"), tokenHash)
+	return err
+}
@@ -1,37 +1,143 @@
 package store
 
 import (
+	"errors"
 	"time"
 
 	"tracky/internal/models"
 )
 
+// ErrNotebookTrashed is returned when restoring a note or image from a
+// notebook in the trash that only the notebook's owner can restore
+var ErrNotebookTrashed = errors.New("notebook is in the trash")
+
 // Store defines the interface for all database operations
 type Store interface {
 	// Users
 	CreateUser(username, passwordHash string) error
 	GetUserByUsername(username string) (int, string, error)
 	GetUserID(username string) (int, error)
+	GetUserByID(userID int) (string, string, error) // Returns username and password hash
+	UpdatePassword(userID int, passwordHash string) error
+	DeleteUser(userID int) ([]string, error)                                             // Deletes the account and all its data; returns image filenames
+	GetUserByIdentity(issuer, subject string) (int, error)                               // Finds the user linked to a single sign-on identity
+	CreateIdentityUser(username, issuer, subject string) (int64, error)                  // Creates a user without a password, linked to the identity
+	GetLockedUntil(userID int) (time.Time, error)                                        // Zero if the account isn't locked
+	RecordFailedLogin(userID, maxFailures int, lockout time.Duration) (time.Time, error) // Locks the account after maxFailures in a row; returns when it unlocks
+	ResetFailedLogins(userID int) error
 
 	// Notebooks
 	CreateNotebook(userID int, name string) (int64, error)
 	CreateDefaultNotebook(userID int) (int64, error)
 	GetNotebooks(userID int) ([]models.Notebook, error)
-	GetNotebookByName(userID int, name string) (int, error)
-	DeleteNotebook(notebookID, userID int) error
+	GetNotebookByName(userID int, name string) (int, error) // Owned notebooks only
+	DeleteNotebook(notebookID, userID int) error            // Owner only
+
+	// Notebook sharing. Note methods taking a user ID check their membership:
+	// any role can read, owners and editors can write.
+	GetNotebookRole(notebookID, userID int) (string, error) // sql.ErrNoRows if not a member
+	GetNoteRole(noteID, userID int) (string, error)         // The user's role in the note's notebook
+	GetNotebookMembers(notebookID int) ([]models.NotebookMember, error)
+	SetNotebookMember(notebookID, userID int, role string) error // Adds the user or changes their role
+	DeleteNotebookMember(notebookID, userID int) error
 
 	// Notes
-	CreateNote(userID, notebookID int, content string) error
+	CreateNote(userID, notebookID int, content string) (int64, error)
+	CreateNoteAt(userID, notebookID int, content string, createdAt time.Time) (int64, error) // For imports
+	GetNote(noteID, userID int) (models.Note, error)
 	GetNotes(userID, notebookID int) ([]models.Note, error)
+	GetNotesPage(userID, notebookID int, opts models.NoteListOptions) ([]models.Note, error)
 	GetNotesByTimeRange(userID, notebookID int, start, end time.Time) ([]models.Note, error)
 	UpdateNote(noteID, userID int, content string) error
 	DeleteNote(noteID, userID int) error
 
+	// Note Revisions (written by CreateNote, UpdateNote and RestoreNoteRevision)
+	GetNoteRevisions(noteID, userID int) ([]models.NoteRevision, error) // Oldest first
+	RestoreNoteRevision(revisionID, noteID, userID int) error
+
+	// Analysis Conversations
+	CreateConversation(userID int, notebookIDs []int, title string) (int64, error) // Listed under the first notebook
+	GetConversations(userID, notebookID int) ([]models.Conversation, error)        // Most recently active first
+	GetConversation(conversationID, userID int) (models.Conversation, error)       // Includes messages, oldest first
+	RenameConversation(conversationID, userID int, title string) error
+	DeleteConversation(conversationID, userID int) error
+	AddConversationMessages(conversationID, userID int, messages ...models.ChatMessage) error
+
+	// Two-factor authentication (TOTP)
+	GetTOTP(userID int) (string, bool, error) // Returns the secret (empty if never set up) and whether it's enabled
+	SetTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, step int64, recoveryCodeHashes []string) error // Replaces any previous recovery codes
+	DisableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error) // False if this or a later step was already used
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error) // Unused codes only
+	CreateLoginChallenge(userID int, tokenHash string, expiresAt time.Time) error
+	GetLoginChallenge(tokenHash string) (int, int, error) // Returns user ID and failed attempts; sql.ErrNoRows if expired
+	FailLoginChallenge(tokenHash string) error
+	DeleteLoginChallenge(tokenHash string) error
+
+	// Sessions
+	CreateSession(userID int, tokenHash, userAgent, ipAddress string, expiresAt time.Time) (int64, error)
+	GetSessionByToken(tokenHash string) (models.Session, error) // Returns sql.ErrNoRows if revoked or expired
+	TouchSession(sessionID int, lastSeenAt time.Time) error
+	GetSessions(userID int) ([]models.Session, error)
+	DeleteSession(sessionID, userID int) error
+	DeleteUserSessions(userID int) error       // Logs the user out everywhere
+	DeleteExpiredSessions(now time.Time) error // Also deletes expired login challenges
+
+	// API tokens
+	CreateAPIToken(userID int, name, tokenHash, scope string, expiresAt *time.Time) (int64, error)
+	GetAPITokenByHash(tokenHash string) (models.APIToken, error) // Returns sql.ErrNoRows if revoked or expired
+	TouchAPIToken(tokenID int, lastUsedAt time.Time) error
+	GetAPITokens(userID int) ([]models.APIToken, error)
+	DeleteAPIToken(tokenID, userID int) error
+
+	// Public share links
+	CreateShareLink(userID int, link models.ShareLink, tokenHash string) (int64, error)
+	GetShareLinkByHash(tokenHash string) (models.ShareLink, error)        // Returns sql.ErrNoRows if revoked or expired
+	GetShareLinkImage(link models.ShareLink, imageID int) (string, error) // Returns the filename of an image on the link's notes
+	GetShareLinks(userID int) ([]models.ShareLink, error)
+	DeleteShareLink(linkID, userID int) error
+
+	// Import jobs
+	CreateImportJob(userID int, format string) (int64, error) // Starts out running
+	UpdateImportJob(job models.ImportJob) error
+	GetImportJob(jobID, userID int) (models.ImportJob, error)
+	GetImportJobs(userID int) ([]models.ImportJob, error)
+
+	// Digests
+	CreateDigest(userID int, d models.Digest) (int64, error) // Notebooks the user doesn't own are dropped
+	GetDigests(userID int) ([]models.Digest, error)
+	UpdateDigest(userID int, d models.Digest) error
+	DeleteDigest(digestID, userID int) error
+	GetDueDigests(cadence string, ranBefore, now time.Time) ([]models.Digest, error) // All users' digests last run before the cutoff and not waiting to retry
+	ClaimDigestRun(digestID int, ranBefore, now time.Time) (bool, error)             // Sets last_run_at unless another server already did
+	ReleaseDigestRun(digestID int, lastRunAt, retryAt time.Time) error               // Undoes a claim after a failed run
+	ResetDigestFailures(digestID int) error
+
+	// Tags (extracted from #hashtags in note content)
+	GetTags(userID int) ([]models.Tag, error)
+	GetTagsByNoteIDs(noteIDs []int) (map[int][]string, error)
+
+	// Trash (Delete* methods move items here)
+	GetTrash(userID int) ([]models.TrashItem, error)            // Notebooks the user owns, notes and images in notebooks they can write to
+	RestoreTrashItem(userID int, itemType string, id int) error // ErrNotebookTrashed if it needs a notebook the user doesn't own restored
+	PurgeTrash(deletedBefore time.Time) ([]string, error)       // Returns filenames of purged images
+	PurgeNotebook(notebookID, userID int) ([]string, error)     // A trashed notebook the user owns; returns filenames of purged images
+
+	// Search
+	Search(userID int, query string, filters models.SearchFilters) ([]models.SearchResult, error)
+
+	// Embeddings (for semantic search; notes are embedded by a background indexer)
+	GetNotesWithoutEmbedding(model string, afterID, limit int) ([]models.Note, error) // Keyset-paginated by note ID
+	SaveNoteEmbedding(noteID int, content, model string, embedding []float32) error   // No-op if the note's content has changed
+	SemanticSearch(userID int, model string, embedding []float32, filters models.SearchFilters) ([]models.SearchResult, error)
+
 	// Note Images
 	CreateNoteImage(noteID int, filename string) (int64, error)
 	GetNoteImages(noteID int) ([]models.NoteImage, error)
-	GetNoteImageWithOwner(imageID, userID int) (string, error) // Returns filename if user owns image
-	DeleteNoteImage(imageID int) (string, error)
+	GetNoteImageWithOwner(imageID, userID int) (string, error) // Returns filename if user can read the image's notebook
+	DeleteNoteImage(imageID, userID int) (string, error)
 	GetNoteImagesByNoteIDs(noteIDs []int) (map[int][]models.NoteImage, error)
 
 	Close() error
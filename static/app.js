@@ -5,6 +5,7 @@ document.addEventListener('DOMContentLoaded', () => {
     const notesContainer = document.getElementById('notes-container');
     const loginForm = document.getElementById('login-form');
     const signupForm = document.getElementById('signup-form');
+    const totpForm = document.getElementById('totp-form');
     const tabLogin = document.getElementById('tab-login');
     const tabSignup = document.getElementById('tab-signup');
     const authMessage = document.getElementById('auth-message');
@@ -36,6 +37,8 @@ document.addEventListener('DOMContentLoaded', () => {
     const analysisView = document.getElementById('analysis-view');
     const analysisChat = document.getElementById('analysis-chat');
     const analysisQuestion = document.getElementById('analysis-question');
+    const conversationSelect = document.getElementById('conversation-select');
+    const analysisRange = document.getElementById('analysis-range');
     const sendQuestionBtn = document.getElementById('send-question-btn');
     const backToNotesBtn = document.getElementById('back-to-notes');
 
@@ -60,11 +63,23 @@ document.addEventListener('DOMContentLoaded', () => {
     let currentView = 'list'; // 'list' or 'calendar'
     let calendarDate = new Date(); // The month being viewed
     let selectedDate = new Date(); // The selected day
-    let allNotes = []; // Store all notes for calendar view
-    let chatHistory = []; // Store chat history for context
-
-    // Check initial session
-    checkSession();
+    let allNotes = []; // Notes loaded so far, newest first
+    let nextCursor = null; // Cursor for the next page of older notes
+    const NOTES_PAGE_SIZE = 100;
+    let currentConversationId = null; // Saved conversation being continued, if any
+    let loginChallenge = null; // Pending two-factor login, if any
+
+    // Check initial session; single sign-on redirects here with a challenge
+    // to finish a two-factor login
+    const ssoChallenge = new URLSearchParams(window.location.hash.slice(1)).get('two_factor');
+    if (ssoChallenge) {
+        history.replaceState(null, '', window.location.pathname);
+    }
+    checkSession().then(() => {
+        if (ssoChallenge && !isLoggedIn) {
+            showTOTPForm(ssoChallenge);
+        }
+    });
 
     // Event Listeners
     tabLogin.addEventListener('click', () => switchTab('login'));
@@ -77,6 +92,16 @@ document.addEventListener('DOMContentLoaded', () => {
         await handleAuth('/api/login', { username, password });
     });
 
+    totpForm.addEventListener('submit', async (e) => {
+        e.preventDefault();
+        const value = document.getElementById('totp-code').value.trim();
+        // Authenticator codes are six digits; anything else is a recovery code
+        const data = /^\d{6}$/.test(value.replace(/\s/g, ''))
+            ? { challenge: loginChallenge, code: value }
+            : { challenge: loginChallenge, recovery_code: value };
+        await handleAuth('/api/login/totp', data);
+    });
+
     signupForm.addEventListener('submit', async (e) => {
         e.preventDefault();
         const username = document.getElementById('signup-username').value;
@@ -85,13 +110,14 @@ document.addEventListener('DOMContentLoaded', () => {
     });
 
     logoutBtn.addEventListener('click', async () => {
-        await fetch('/api/logout');
+        await fetch('/api/logout', { method: 'POST', headers: withCSRF() });
         setLoggedIn(false);
     });
 
     createNoteBtn.addEventListener('click', () => createNote());
     calendarCreateNoteBtn.addEventListener('click', () => createNote(calendarNoteContent));
     createNotebookBtn.addEventListener('click', createNotebook);
+    document.getElementById('import-notebook-file').addEventListener('change', importNotebook);
     backToNotebooks.addEventListener('click', showNotebooks);
 
     // Calendar event listeners
@@ -102,8 +128,13 @@ document.addEventListener('DOMContentLoaded', () => {
 
     // Analysis event listeners
     analysisBtn.addEventListener('click', showAnalysis);
+    document.getElementById('export-btn').addEventListener('click', () => {
+        window.location = `/api/notebooks/${currentNotebook.id}/export?format=markdown`;
+    });
+    document.getElementById('share-link-btn').addEventListener('click', () => createShareLink({ notebook_id: currentNotebook.id }));
     backToNotesBtn.addEventListener('click', hideAnalysis);
     sendQuestionBtn.addEventListener('click', sendAnalysisQuestion);
+    conversationSelect.addEventListener('change', () => openConversation(conversationSelect.value));
     analysisQuestion.addEventListener('keydown', (e) => {
         if (e.key === 'Enter' && !e.shiftKey) {
             e.preventDefault();
@@ -114,6 +145,8 @@ document.addEventListener('DOMContentLoaded', () => {
     // Functions
     function switchTab(tab) {
         authMessage.textContent = '';
+        loginChallenge = null;
+        totpForm.classList.add('hidden');
         if (tab === 'login') {
             tabLogin.classList.add('active');
             tabSignup.classList.remove('active');
@@ -134,15 +167,19 @@ document.addEventListener('DOMContentLoaded', () => {
         try {
             const res = await fetch(endpoint, {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: withCSRF({ 'Content-Type': 'application/json' }),
                 body: JSON.stringify(data)
             });
 
             if (res.ok) {
+                const body = await res.json().catch(() => ({}));
                 if (isSignup) {
                     authMessage.textContent = 'Signup successful! Please login.';
                     authMessage.className = 'success';
                     switchTab('login');
+                } else if (body.two_factor_required) {
+                    // Password accepted; ask for the second factor
+                    showTOTPForm(body.challenge);
                 } else {
                     setLoggedIn(true);
                 }
@@ -157,6 +194,14 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
+    function showTOTPForm(challenge) {
+        loginChallenge = challenge;
+        loginForm.classList.add('hidden');
+        totpForm.classList.remove('hidden');
+        document.getElementById('totp-code').focus();
+        authMessage.textContent = '';
+    }
+
     function setLoggedIn(status) {
         isLoggedIn = status;
         currentNotebook = null;
@@ -173,6 +218,8 @@ document.addEventListener('DOMContentLoaded', () => {
             notebooksList.innerHTML = '';
             loginForm.reset();
             signupForm.reset();
+            totpForm.reset();
+            switchTab('login');
             authMessage.textContent = '';
         }
     }
@@ -185,22 +232,14 @@ document.addEventListener('DOMContentLoaded', () => {
     }
 
     function showNotes(notebook) {
-        // Always clear chat history when entering a notebook
-        chatHistory = [];
-        const welcome = `
-            <div class="analysis-welcome">
-                <p>Ask questions about your notes, like:</p>
-                <ul>
-                    <li>"What did I work on this week?"</li>
-                    <li>"Summarize my notes from this month"</li>
-                    <li>"What themes appear in my notes?"</li>
-                </ul>
-            </div>`;
-        analysisChat.innerHTML = welcome;
+        // Always start a new conversation when entering a notebook
+        resetConversation();
         currentNotebook = notebook;
         currentNotebookName.textContent = notebook.name;
         notebooksContainer.classList.add('hidden');
         notesContainer.classList.remove('hidden');
+        allNotes = [];
+        nextCursor = null;
         fetchNotes();
     }
 
@@ -239,19 +278,48 @@ document.addEventListener('DOMContentLoaded', () => {
         notebooks.forEach(nb => {
             const div = document.createElement('div');
             div.className = 'notebook-card';
+            // Only owners can share or delete; shared notebooks show the user's role
+            const actions = nb.role === 'owner'
+                ? `<button class="share-notebook-btn" title="Share">👥</button>
+                   <button class="delete-notebook-btn" title="Delete">🗑️</button>`
+                : `<span class="notebook-role">Shared · ${escapeHtml(nb.role)}</span>`;
             div.innerHTML = `
                 <span class="notebook-name">${escapeHtml(nb.name)}</span>
-                <button class="delete-notebook-btn" title="Delete">🗑️</button>
+                <span class="notebook-actions">${actions}</span>
             `;
             div.addEventListener('click', () => showNotes(nb));
-            div.querySelector('.delete-notebook-btn').addEventListener('click', (e) => {
-                e.stopPropagation();
-                deleteNotebook(nb.id);
-            });
+            if (nb.role === 'owner') {
+                div.querySelector('.share-notebook-btn').addEventListener('click', (e) => {
+                    e.stopPropagation();
+                    shareNotebook(nb.id);
+                });
+                div.querySelector('.delete-notebook-btn').addEventListener('click', (e) => {
+                    e.stopPropagation();
+                    deleteNotebook(nb.id);
+                });
+            }
             notebooksList.appendChild(div);
         });
     }
 
+    async function shareNotebook(id) {
+        const username = prompt('Share with which user?');
+        if (!username) return;
+        const role = confirm('Allow them to add and edit notes? Cancel to make them a viewer.') ? 'editor' : 'viewer';
+        try {
+            const res = await fetch(`/api/notebooks/${id}/members`, {
+                method: 'POST',
+                headers: withCSRF({ 'Content-Type': 'application/json' }),
+                body: JSON.stringify({ username: username.trim(), role })
+            });
+            if (!res.ok) {
+                alert(await res.text());
+            }
+        } catch (e) {
+            console.error('Failed to share notebook');
+        }
+    }
+
     async function createNotebook() {
         const name = notebookName.value.trim();
         if (!name) return;
@@ -259,7 +327,7 @@ document.addEventListener('DOMContentLoaded', () => {
         try {
             const res = await fetch('/api/notebooks', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: withCSRF({ 'Content-Type': 'application/json' }),
                 body: JSON.stringify({ name })
             });
 
@@ -272,10 +340,65 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
+    async function importNotebook(e) {
+        const file = e.target.files[0];
+        if (!file) return;
+
+        const format = document.getElementById('import-format').value;
+        const formData = new FormData();
+        formData.append('file', file);
+        if (format !== 'markdown') formData.append('format', format);
+        try {
+            const res = await fetch(format === 'markdown' ? '/api/notebooks/import' : '/api/imports', {
+                method: 'POST',
+                headers: withCSRF(),
+                body: formData
+            });
+            if (!res.ok) {
+                alert(await res.text());
+            } else if (format === 'markdown') {
+                fetchNotebooks();
+            } else {
+                watchImport((await res.json()).id);
+            }
+        } catch (err) {
+            console.error('Failed to import notebook');
+        }
+        e.target.value = '';
+    }
+
+    // watchImport shows a background import's progress until it finishes
+    async function watchImport(id) {
+        const status = document.getElementById('import-status');
+        status.classList.remove('hidden');
+        status.textContent = 'Reading the file...';
+        try {
+            const res = await fetch(`/api/imports/${id}`);
+            if (!res.ok) return;
+            const job = await res.json();
+            if (job.status === 'running') {
+                if (job.total > 0) status.textContent = `Imported ${job.processed} of ${job.total} notes...`;
+                setTimeout(() => watchImport(id), 1000);
+                return;
+            }
+            if (job.status === 'failed') {
+                status.textContent = `Import failed: ${job.error}`;
+                return;
+            }
+            status.textContent = `Imported ${job.imported} of ${job.total} notes.`;
+            if (job.skipped.length > 0) {
+                status.textContent += ` Skipped: ${job.skipped.map(s => `${s.item} (${s.reason})`).join('; ')}`;
+            }
+            fetchNotebooks();
+        } catch (err) {
+            console.error('Failed to check import');
+        }
+    }
+
     async function deleteNotebook(id) {
         if (!confirm('Delete this notebook and all its notes?')) return;
         try {
-            const res = await fetch(`/api/notebooks?id=${id}`, { method: 'DELETE' });
+            const res = await fetch(`/api/notebooks?id=${id}`, { method: 'DELETE', headers: withCSRF() });
             if (res.ok) {
                 fetchNotebooks();
             }
@@ -284,14 +407,17 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
+    // Reloads the newest notes, keeping as many as were already loaded
     async function fetchNotes() {
         if (!currentNotebook) return;
+        const limit = Math.max(NOTES_PAGE_SIZE, allNotes.length);
         try {
-            const res = await fetch(`/api/notes?notebook_id=${currentNotebook.id}`);
+            const res = await fetch(`/api/notes?notebook_id=${currentNotebook.id}&limit=${limit}`);
             if (res.ok) {
-                const notes = await res.json();
-                allNotes = notes || [];
-                renderNotes(notes);
+                const page = await res.json();
+                allNotes = page.notes || [];
+                nextCursor = page.next_cursor || null;
+                renderNotes(allNotes);
                 if (currentView === 'calendar') {
                     renderCalendar();
                 }
@@ -301,6 +427,23 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
+    // Appends the next page of older notes
+    async function loadMoreNotes() {
+        if (!currentNotebook || !nextCursor) return false;
+        try {
+            const res = await fetch(`/api/notes?notebook_id=${currentNotebook.id}&limit=${NOTES_PAGE_SIZE}&cursor=${encodeURIComponent(nextCursor)}`);
+            if (!res.ok) return false;
+            const page = await res.json();
+            allNotes = allNotes.concat(page.notes || []);
+            nextCursor = page.next_cursor || null;
+            renderNotes(allNotes);
+            return true;
+        } catch (e) {
+            console.error('Failed to fetch notes');
+            return false;
+        }
+    }
+
     async function createNote(textareaEl = noteContent) {
         if (!currentNotebook) return;
         const content = textareaEl.value.trim();
@@ -309,7 +452,7 @@ document.addEventListener('DOMContentLoaded', () => {
         try {
             const res = await fetch(`/api/notes?notebook_id=${currentNotebook.id}`, {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: withCSRF({ 'Content-Type': 'application/json' }),
                 body: JSON.stringify({ content })
             });
 
@@ -325,7 +468,7 @@ document.addEventListener('DOMContentLoaded', () => {
     async function deleteNote(id) {
         if (!confirm('Delete this note?')) return;
         try {
-            const res = await fetch(`/api/notes?id=${id}`, { method: 'DELETE' });
+            const res = await fetch(`/api/notes?id=${id}`, { method: 'DELETE', headers: withCSRF() });
             if (res.ok) {
                 fetchNotes();
             }
@@ -340,7 +483,7 @@ document.addEventListener('DOMContentLoaded', () => {
         try {
             const res = await fetch(`/api/notes?id=${id}`, {
                 method: 'PUT',
-                headers: { 'Content-Type': 'application/json' },
+                headers: withCSRF({ 'Content-Type': 'application/json' }),
                 body: JSON.stringify({ content: newContent })
             });
             if (res.ok) {
@@ -542,6 +685,17 @@ document.addEventListener('DOMContentLoaded', () => {
 
             notesList.appendChild(yearDetails);
         });
+
+        if (nextCursor) {
+            const loadMoreBtn = document.createElement('button');
+            loadMoreBtn.className = 'primary-btn load-more-btn';
+            loadMoreBtn.textContent = 'Load older notes';
+            loadMoreBtn.addEventListener('click', () => {
+                loadMoreBtn.disabled = true;
+                loadMoreNotes();
+            });
+            notesList.appendChild(loadMoreBtn);
+        }
     }
 
     function createNoteCard(note) {
@@ -571,6 +725,7 @@ document.addEventListener('DOMContentLoaded', () => {
                     <label class="upload-btn" title="Add image">📷
                         <input type="file" accept="image/*" style="display:none" class="image-upload-input">
                     </label>
+                    <button class="link-btn" title="Public link">🔗</button>
                     <button class="edit-btn" title="Edit">✏️</button>
                     <button class="delete-btn" title="Delete">🗑️</button>
                 </div>
@@ -579,6 +734,7 @@ document.addEventListener('DOMContentLoaded', () => {
             ${imagesHtml}
         `;
 
+        div.querySelector('.link-btn').addEventListener('click', () => createShareLink({ note_id: note.id }));
         div.querySelector('.edit-btn').addEventListener('click', () => startEdit(div, note));
         div.querySelector('.delete-btn').addEventListener('click', () => deleteNote(note.id));
 
@@ -613,6 +769,25 @@ document.addEventListener('DOMContentLoaded', () => {
         return div;
     }
 
+    // Creates a public read-only link to a note or notebook and shows it for copying
+    async function createShareLink(target) {
+        try {
+            const res = await fetch('/api/shares', {
+                method: 'POST',
+                headers: withCSRF({ 'Content-Type': 'application/json' }),
+                body: JSON.stringify(target)
+            });
+            if (!res.ok) {
+                alert(await res.text());
+                return;
+            }
+            const link = await res.json();
+            prompt('Anyone with this link can read it:', new URL(link.url, window.location.origin).href);
+        } catch (e) {
+            console.error('Failed to create share link', e);
+        }
+    }
+
     async function uploadImage(noteId, file) {
         const formData = new FormData();
         formData.append('note_id', noteId);
@@ -621,6 +796,7 @@ document.addEventListener('DOMContentLoaded', () => {
         try {
             const res = await fetch('/api/images', {
                 method: 'POST',
+                headers: withCSRF(),
                 body: formData
             });
             if (res.ok) {
@@ -636,7 +812,8 @@ document.addEventListener('DOMContentLoaded', () => {
     async function deleteImage(imageId) {
         try {
             const res = await fetch(`/api/images?id=${imageId}`, {
-                method: 'DELETE'
+                method: 'DELETE',
+                headers: withCSRF()
             });
             if (res.ok) {
                 fetchNotes();
@@ -652,6 +829,13 @@ document.addEventListener('DOMContentLoaded', () => {
         return div.innerHTML;
     }
 
+    // Adds the CSRF token from the csrf_token cookie, which the server
+    // requires on every request that changes something
+    function withCSRF(headers = {}) {
+        const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+        return { ...headers, 'X-CSRF-Token': match ? decodeURIComponent(match[1]) : '' };
+    }
+
     // Calendar Functions
     function toggleView() {
         if (currentView === 'list') {
@@ -682,9 +866,16 @@ document.addEventListener('DOMContentLoaded', () => {
         renderCalendar();
     }
 
-    function renderCalendar() {
+    async function renderCalendar() {
         const year = calendarDate.getFullYear();
         const month = calendarDate.getMonth();
+
+        // Load older pages until the viewed month is covered
+        const monthStart = new Date(year, month, 1);
+        while (nextCursor && allNotes.length > 0 &&
+            new Date(allNotes[allNotes.length - 1].created_at) >= monthStart) {
+            if (!await loadMoreNotes()) break;
+        }
         const monthNames = ['January', 'February', 'March', 'April', 'May', 'June',
             'July', 'August', 'September', 'October', 'November', 'December'];
 
@@ -785,6 +976,7 @@ document.addEventListener('DOMContentLoaded', () => {
 
     // Analysis Functions
     function showAnalysis() {
+        fetchConversations();
         listView.classList.add('hidden');
         calendarView.classList.add('hidden');
         listCreateNote.classList.add('hidden');
@@ -817,36 +1009,131 @@ document.addEventListener('DOMContentLoaded', () => {
         const loadingId = addChatMessage('assistant', '<div class="loading-dots"><span></span><span></span><span></span></div>');
 
         try {
-            const res = await fetch('/api/analysis', {
+            const res = await fetch('/api/analysis/stream', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: withCSRF({ 'Content-Type': 'application/json' }),
                 body: JSON.stringify({
                     notebook_id: currentNotebook.id,
+                    conversation_id: currentConversationId || 0,
                     question: question,
-                    history: chatHistory
+                    range: analysisRange.value,
+                    timezone: Intl.DateTimeFormat().resolvedOptions().timeZone
                 })
             });
 
-            // Remove loading indicator
-            document.getElementById(loadingId)?.remove();
-
-            if (res.ok) {
-                const data = await res.json();
-                addChatMessage('assistant', formatMarkdown(data.answer));
-
-                // Update history
-                chatHistory.push({ role: 'user', content: question });
-                chatHistory.push({ role: 'model', content: data.answer });
-            } else {
+            if (!res.ok) {
+                document.getElementById(loadingId)?.remove();
                 const errorText = await res.text();
                 addChatMessage('assistant', `Error: ${errorText}`);
+                return;
             }
+
+            // Replace the loading indicator with the answer as it streams in
+            let answer = '';
+            const answerEl = document.getElementById(loadingId);
+            await readEventStream(res, (event, data) => {
+                if (event === 'done' && data.conversation_id && !currentConversationId) {
+                    currentConversationId = data.conversation_id;
+                    fetchConversations();
+                } else if (event === 'token') {
+                    answer += data.text;
+                    answerEl.innerHTML = formatMarkdown(answer);
+                    analysisChat.scrollTop = analysisChat.scrollHeight;
+                } else if (event === 'error') {
+                    answerEl.innerHTML = `Error: ${data.error}`;
+                }
+            });
         } catch (e) {
             document.getElementById(loadingId)?.remove();
             addChatMessage('assistant', 'Failed to get response. Please try again.');
         }
     }
 
+    // readEventStream calls onEvent(event, data) for each Server-Sent Event in
+    // a fetch response and resolves with the name of the last event
+    async function readEventStream(res, onEvent) {
+        const reader = res.body.getReader();
+        const decoder = new TextDecoder();
+        let buffer = '';
+        let last = null;
+        while (true) {
+            const { done, value } = await reader.read();
+            if (done) break;
+            buffer += decoder.decode(value, { stream: true });
+
+            let sep;
+            while ((sep = buffer.indexOf('\n\n')) !== -1) {
+                const block = buffer.slice(0, sep);
+                buffer = buffer.slice(sep + 2);
+                let event = 'message';
+                let data = '';
+                for (const line of block.split('\n')) {
+                    if (line.startsWith('event: ')) event = line.slice(7);
+                    else if (line.startsWith('data: ')) data += line.slice(6);
+                }
+                onEvent(event, data ? JSON.parse(data) : null);
+                last = event;
+            }
+        }
+        return last;
+    }
+
+    function resetConversation() {
+        currentConversationId = null;
+        conversationSelect.value = '';
+        analysisChat.innerHTML = `
+            <div class="analysis-welcome">
+                <p>Ask questions about your notes, like:</p>
+                <ul>
+                    <li>"What did I work on this week?"</li>
+                    <li>"Summarize my notes from this month"</li>
+                    <li>"What themes appear in my notes?"</li>
+                </ul>
+            </div>`;
+    }
+
+    async function fetchConversations() {
+        if (!currentNotebook) return;
+        try {
+            const res = await fetch(`/api/conversations?notebook_id=${currentNotebook.id}`);
+            if (!res.ok) return;
+            const conversations = await res.json();
+            conversationSelect.innerHTML = '<option value="">New conversation</option>';
+            conversations.forEach(c => {
+                const option = document.createElement('option');
+                option.value = c.id;
+                option.textContent = c.title;
+                conversationSelect.appendChild(option);
+            });
+            conversationSelect.value = currentConversationId || '';
+        } catch (e) {
+            console.error('Failed to fetch conversations', e);
+        }
+    }
+
+    async function openConversation(id) {
+        if (!id) {
+            resetConversation();
+            return;
+        }
+        try {
+            const res = await fetch(`/api/conversations/${id}`);
+            if (!res.ok) return;
+            const conversation = await res.json();
+            currentConversationId = conversation.id;
+            analysisChat.innerHTML = '';
+            conversation.messages.forEach(m => {
+                if (m.role === 'user') {
+                    addChatMessage('user', m.content);
+                } else {
+                    addChatMessage('assistant', formatMarkdown(m.content));
+                }
+            });
+        } catch (e) {
+            console.error('Failed to open conversation', e);
+        }
+    }
+
     function addChatMessage(role, content) {
         // Remove welcome message if present
         const welcome = analysisChat.querySelector('.analysis-welcome');